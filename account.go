@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	user "wallet/pkg/user"
+)
+
+// changePassword replaces the authenticated user's password and ends their
+// other sessions (protected by AuthMiddleware)
+func changePassword(c *gin.Context) {
+	var request struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+	if err := userService.ChangePassword(userID, request.CurrentPassword, request.NewPassword); err != nil {
+		respondFieldError(c, err)
+		return
+	}
+
+	revoked, err := authService.RevokeOtherSessions(userID, c.MustGet("session_id").(primitive.ObjectID))
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", userID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "revoked_sessions": revoked})
+}
+
+// changeEmail moves the authenticated user to a new email address, which
+// must be verified again, and ends their other sessions (protected by
+// AuthMiddleware)
+func changeEmail(c *gin.Context) {
+	var request struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		Email           string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+	if err := userService.ChangeEmail(userID, request.CurrentPassword, request.Email, mail); err != nil {
+		respondFieldError(c, err)
+		return
+	}
+
+	revoked, err := authService.RevokeOtherSessions(userID, c.MustGet("session_id").(primitive.ObjectID))
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", userID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed, check your new address to verify it", "revoked_sessions": revoked})
+}
+
+// respondFieldError maps errors of user updates to HTTP responses, listing
+// the refused fields
+func respondFieldError(c *gin.Context, err error) {
+	var fieldErr *user.FieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           fieldErr.Error(),
+			"rejected_fields": fieldErr.Rejected,
+			"invalid_fields":  fieldErr.Invalid,
+		})
+	case errors.Is(err, user.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrUserExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
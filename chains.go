@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listChains returns the chains the API can operate on. RPC URLs are left out
+// as they often embed provider API keys, and pool settings as they are
+// internal.
+func listChains(c *gin.Context) {
+	all := registry.All()
+	for i := range all {
+		all[i].RPCURLs = nil
+		all[i].Pool = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{"chains": all, "default": registry.Default().ID})
+}
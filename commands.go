@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"wallet/pkg/auth"
+	"wallet/pkg/migrations"
+	mongodb "wallet/pkg/mongo"
+	"wallet/pkg/wallet"
+)
+
+// runCommand runs a maintenance command instead of starting the server
+func runCommand(name string) error {
+	// Every command but key generation works on the database
+	var db *mongodb.DB
+	if name != "generate-jwt-key" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		db, err = setupServices(ctx, cfg.Database)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		defer db.Close(context.Background())
+	}
+
+	switch name {
+	case "rewrap-keys":
+		updated, err := userService.RewrapWallets()
+		if err != nil {
+			return fmt.Errorf("failed to rewrap wallet keys: %w", err)
+		}
+		log.Printf("Rewrapped %d wallets", updated)
+	case "migrate":
+		applied, err := migrations.Run(context.Background(), db)
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		log.Printf("Applied %d migrations", len(applied))
+	case "migrate-balances":
+		updated, err := wallet.MigrateFloatBalances(db)
+		if err != nil {
+			return fmt.Errorf("failed to migrate balances: %w", err)
+		}
+		log.Printf("Migrated balances of %d users", updated)
+	case "hash-passwords":
+		updated, err := userService.HashPlaintextPasswords()
+		if err != nil {
+			return fmt.Errorf("failed to hash passwords: %w", err)
+		}
+		log.Printf("Hashed plaintext passwords of %d users", updated)
+	case "generate-jwt-key":
+		keyType := "ed25519"
+		if len(os.Args) > 2 {
+			keyType = os.Args[2]
+		}
+		data, err := auth.GenerateKeyPEM(keyType)
+		if err != nil {
+			return fmt.Errorf("failed to generate JWT key: %w", err)
+		}
+		kid, err := auth.KeyFingerprint(data)
+		if err != nil {
+			return fmt.Errorf("failed to generate JWT key: %w", err)
+		}
+		os.Stdout.Write(data)
+		log.Printf("Generated %s key, suggested key ID %s", keyType, kid)
+	case "set-role":
+		if len(os.Args) != 4 {
+			return fmt.Errorf("usage: %s set-role <email> <role>", os.Args[0])
+		}
+		userData, err := userService.GetUserByEmail(os.Args[2])
+		if err != nil {
+			return fmt.Errorf("failed to find user %s: %w", os.Args[2], err)
+		}
+		if err := userService.SetRole(userData.ID, os.Args[3]); err != nil {
+			return fmt.Errorf("failed to set role: %w", err)
+		}
+		log.Printf("User %s is now %s", os.Args[2], os.Args[3])
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
+	"wallet/pkg/models"
+	mongodb "wallet/pkg/mongo"
+)
+
+// readinessTimeout bounds the dependency checks of one /readyz request
+const readinessTimeout = 5 * time.Second
+
+// healthz reports that the process is up. It checks no dependencies, so an
+// outage elsewhere does not get the API restarted.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// chainReadiness is the outcome of probing the RPC nodes of a chain
+type chainReadiness struct {
+	ChainID int64                  `json:"chain_id"`
+	Name    string                 `json:"name"`
+	Ready   bool                   `json:"ready"`
+	Error   string                 `json:"error,omitempty"`
+	Nodes   []ethereum.ProbeResult `json:"nodes,omitempty"`
+}
+
+// readyz reports whether the API can serve requests: MongoDB answers and
+// every chain with RPC nodes configured has at least one node answering
+func readyz(db *mongodb.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+
+		ready := true
+
+		// The error may name database hosts, so it is only logged
+		database := gin.H{"ready": true}
+		if err := db.Ping(ctx); err != nil {
+			log.Printf("Readiness check failed to ping MongoDB: %v", err)
+			ready = false
+			database["ready"] = false
+		}
+
+		var configured []models.Chain
+		for _, chain := range registry.All() {
+			if len(chain.RPCURLs) > 0 {
+				configured = append(configured, chain)
+			}
+		}
+
+		results := make([]chainReadiness, len(configured))
+		var wg sync.WaitGroup
+		for i, chain := range configured {
+			wg.Add(1)
+			go func(i int, chain models.Chain) {
+				defer wg.Done()
+				results[i] = probeChain(ctx, chain)
+			}(i, chain)
+		}
+		wg.Wait()
+
+		for _, result := range results {
+			ready = ready && result.Ready
+		}
+
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"ready": ready, "database": database, "chains": results})
+	}
+}
+
+// probeChain asks every RPC node of a chain for its head block. The chain is
+// ready if any node answers.
+func probeChain(ctx context.Context, chain models.Chain) chainReadiness {
+	result := chainReadiness{ChainID: chain.ID, Name: chain.Name}
+
+	client, err := chains.Client(chain)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Nodes = client.Pool().Probe(ctx)
+	for _, node := range result.Nodes {
+		if node.Error == "" {
+			result.Ready = true
+		}
+	}
+
+	return result
+}
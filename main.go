@@ -1,37 +1,234 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
-	"time"
+	"sync"
+	"syscall"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"wallet/pkg/auth"
+	"wallet/pkg/chains"
+	config "wallet/pkg/config"
+	"wallet/pkg/deposits"
+	"wallet/pkg/mailer"
+	"wallet/pkg/migrations"
 	"wallet/pkg/models"
+	mongodb "wallet/pkg/mongo"
+	"wallet/pkg/repository"
 	user "wallet/pkg/user"
+	"wallet/pkg/wallet"
+)
+
+// registry holds the chains the API can operate on, loaded at startup
+var registry *chains.Registry
+
+// mail sends verification emails, configured at startup
+var mail mailer.Mailer
+
+// Services shared by the handlers, workers and commands, wired to a single
+// database client by setupServices
+var (
+	authService   *auth.Service
+	userService   *user.Service
+	walletService *wallet.Service
+	depositStore  *deposits.RepositoryStore
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve runs the API and its workers until SIGINT or SIGTERM, then lets
+// requests in flight and workers finish before closing the database client
+func serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	log.Printf("Configuration: %s", cfg)
+
+	db, err := setupServices(ctx, cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer db.Close(context.Background())
+	defer chains.CloseClients()
+
+	// Bring the schema up to date unless deployments migrate separately
+	if cfg.Database.MigrateOnStartup {
+		if _, err := migrations.Run(ctx, db); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	registry, err = chains.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load chain registry: %w", err)
+	}
+
+	if _, err := auth.CurrentKeySet(); err != nil {
+		return fmt.Errorf("failed to load JWT keys: %w", err)
+	}
+
+	mail, err = mailer.Load()
+	if err != nil {
+		return fmt.Errorf("failed to configure mailer: %w", err)
+	}
+
+	// Workers stop with ctx and are waited for before the database closes
+	var workers sync.WaitGroup
+	runWorker := func(work func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			work()
+		}()
+	}
+	defer workers.Wait()
+	defer stop()
+
+	if interval := cfg.Workers.BalanceInterval; interval > 0 {
+		runWorker(func() { walletService.RunBalanceWorker(ctx, registry, interval) })
+	}
+	if interval := cfg.Workers.DepositInterval; interval > 0 {
+		runWorker(func() { deposits.Run(ctx, registry, depositStore, interval) })
+	}
+	if interval := cfg.Workers.SessionExpiryInterval; interval > 0 {
+		runWorker(func() { expireSessions(ctx, interval) })
+	}
+
+	server := newServer(cfg.Server, newRouter(db))
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+
+	// Let requests in flight finish before the database client goes away
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+
+	return nil
+}
+
+// newRouter registers the API routes. db is only used by the readiness
+// check.
+func newRouter(db *mongodb.DB) *gin.Engine {
 	r := gin.Default()
 
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz(db))
+
 	// Apply authentication middleware
 	r.POST("/login", loginUser) // Handle user login
+	r.POST("/login/2fa", completeLogin)
+	r.POST("/login/passkey/begin", beginPasskeyLogin)
+	r.POST("/login/passkey/finish", finishPasskeyLogin)
+	r.GET("/siwe/nonce", siweNonce)
+	r.POST("/login/siwe", siweLogin)
+	r.POST("/token/refresh", refreshToken)
+	r.GET("/.well-known/jwks.json", jwks)
+	r.POST("/register", registerUser)
+	r.POST("/register/resend", resendVerification)
+	r.GET("/verify", confirmVerification)
+	r.POST("/verify", verifyEmail)
 
 	eg := r.Group("/api/v1", AuthMiddleware()) // Protect these routes with AuthMiddleware
 	{
-		eg.POST("/create", createUser)
-		eg.PATCH("/update/:id", updateUser)
-		eg.DELETE("/users/:id", deleteUser)
-		eg.GET("/users/:id", getUser) // Optionally, protect the "get user" endpoint too
+		eg.GET("/chains", listChains)
+		eg.POST("/create", Authorize(auth.PermCreateUsers), createUser)
+		eg.PATCH("/update/:id", Authorize(auth.PermUpdateUsers), updateUser)
+
+		// Users can only reach their own records unless their role allows more
+		users := eg.Group("/users/:id")
+		{
+			users.GET("", Authorize(auth.PermReadUsers), getUser)
+			users.DELETE("", Authorize(auth.PermDeleteUsers), deleteUser)
+			users.PUT("/role", Authorize(auth.PermManageRoles), setUserRole)
+			users.POST("/balances/refresh", Authorize(auth.PermRefreshBalances), refreshBalances)
+			users.GET("/deposits", Authorize(auth.PermReadDeposits), listDeposits)
+		}
+
+		eg.GET("/wallet/accounts", listAccounts)
+		eg.POST("/wallet/accounts", createAccount)
+		eg.POST("/wallets/:address/transfers", RequireStepUp(), createTransfer)
+		eg.POST("/2fa/totp/enroll", RequireStepUp(), enrollTOTP)
+		eg.GET("/2fa/totp/qr.png", RequireStepUp(), totpQRCode)
+		eg.POST("/2fa/totp/confirm", RequireStepUp(), confirmTOTP)
+		eg.POST("/2fa/totp/disable", disableTOTP)
+		eg.POST("/step-up", stepUp)
+		eg.POST("/passkeys/register/begin", RequireStepUp(), beginPasskeyRegistration)
+		eg.POST("/passkeys/register/finish", RequireStepUp(), finishPasskeyRegistration)
+		eg.GET("/passkeys", listPasskeys)
+		eg.DELETE("/passkeys/:id", deletePasskey)
+		eg.POST("/siwe/addresses", RequireStepUp(), linkEthereumAddress)
+		eg.DELETE("/siwe/addresses/:address", unlinkEthereumAddress)
+		eg.GET("/sessions", listSessions)
+		eg.DELETE("/sessions/:id", revokeSession)
+		eg.POST("/logout", logout)
+		eg.POST("/logout/all", logoutAll)
+		eg.PUT("/account/password", changePassword)
+		eg.PUT("/account/email", changeEmail)
+	}
+
+	return r
+}
+
+// setupServices connects to MongoDB and wires the services to the client.
+// The caller closes the returned client on shutdown.
+func setupServices(ctx context.Context, cfg config.DatabaseConfig) (*mongodb.DB, error) {
+	db, err := mongodb.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	r.Run(":8080")
+	wireServices(repository.NewMongo(db))
+
+	return db, nil
 }
 
-// AuthMiddleware validates JWT token from the request
+// wireServices builds the services on repos
+func wireServices(repos repository.Repositories) {
+	authService = auth.NewService(repos)
+	userService = user.NewService(repos, authService)
+	walletService = wallet.NewService(repos, userService)
+	depositStore = deposits.NewRepositoryStore(repos)
+}
+
+// AuthMiddleware validates JWT token from the request and checks that its
+// session has not been revoked
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -41,72 +238,88 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := strings.Split(authHeader, "Bearer ")[1]
-
-		// Parse the JWT token
-		token, err := jwt.ParseWithClaims(tokenString, &auth.UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return auth.ValidateToken(tokenString) // Use auth.ValidateToken for validation
-		})
+		tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header must use the Bearer scheme"})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
+		// Check the signature, expiry and session of the token
+		claims, session, err := authService.ValidateToken(tokenString)
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrSessionRevoked) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
-
-		// Check if token is expired and handle refresh
-		if claims, ok := token.Claims.(*auth.UserClaims); ok && token.Valid {
-			if claims.ExpiresAt < time.Now().Unix() {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
-				c.Abort()
-				return
-			}
-
-			// Set the user email in the context
-			c.Set("email", claims.Email)
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
+		// Set the user email, role and session in the context
+		c.Set("email", claims.Email)
+		c.Set("role", claims.Role)
+		c.Set("user_id", session.UserID)
+		c.Set("session_id", session.ID)
+		c.Set("session", session)
+
 		c.Next()
 	}
 }
 
-// loginUser authenticates a user and returns a JWT token
+// LoginRequest is the body of a login. Device is an optional name for the
+// session, e.g. "Alice's phone".
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Device   string `json:"device"`
+}
+
+// loginUser authenticates a user, starts a session and returns a JWT token.
+// Users with two-factor authentication get a pre-auth token to complete the
+// login at /login/2fa.
 func loginUser(c *gin.Context) {
-	var userModel models.User
-	if err := c.ShouldBindJSON(&userModel); err != nil {
+	var request LoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Authenticate the user and generate a JWT token
-	token, err := auth.UserLogin(userModel.Email, userModel.Password)
+	result, err := authService.UserLogin(request.Email, request.Password, auth.SessionMeta{
+		Device:    request.Device,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, result)
 }
 
 // createUser creates a new user (protected by AuthMiddleware)
 func createUser(c *gin.Context) {
-	var userModel models.User
-	if err := c.ShouldBindJSON(&userModel); err != nil {
+	var request RegisterRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := user.CreateUser(userModel.Username, userModel.Email, userModel.Password)
+	createdUser, err := userService.CreateUser(request.Username, request.Email, request.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondFieldError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "User created successfully",
+		"id":      createdUser.ID,
+		"address": createdUser.Wallet.PublicKey,
+	})
 }
 
 // getUser retrieves a user by ID (protected by AuthMiddleware)
@@ -125,7 +338,7 @@ func getUser(c *gin.Context) {
 		return
 	}
 
-	userData, err := user.GetUserByID(userID)
+	userData, err := userService.GetUserByID(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -136,6 +349,8 @@ func getUser(c *gin.Context) {
 		ID:       userData.ID,
 		Username: userData.Username,
 		Email:    userData.Email,
+		Address:  userData.Wallet.PublicKey,
+		Role:     auth.UserRole(userData),
 		Balances: userData.Balances,
 	}
 
@@ -156,15 +371,21 @@ func updateUser(c *gin.Context) {
 		return
 	}
 
-	var updatedData map[string]interface{}
-	if err := c.BindJSON(&updatedData); err != nil {
+	data, err := c.GetRawData()
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err = user.UpdateUser(userID, updatedData)
+	update, err := user.DecodeProfileUpdate(data)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondFieldError(c, err)
+		return
+	}
+
+	err = userService.UpdateUser(userID, update)
+	if err != nil {
+		respondFieldError(c, err)
 		return
 	}
 
@@ -185,7 +406,7 @@ func deleteUser(c *gin.Context) {
 		return
 	}
 
-	err = user.DeactivateUser(userID)
+	err = userService.DeactivateUser(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
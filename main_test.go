@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet/pkg/config/configtest"
+	"wallet/pkg/repository"
+)
+
+// newTestRouter wires the services to in-memory repositories and returns
+// the API routes
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	configtest.Use(t, configtest.Config())
+
+	gin.SetMode(gin.TestMode)
+	wireServices(repository.NewMemory())
+
+	return newRouter(nil)
+}
+
+// serveJSON sends a request with an optional JSON body and bearer token and
+// decodes the JSON response into out
+func serveJSON(t *testing.T, r http.Handler, method, path, token string, body, out any) int {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s returned %d with invalid JSON: %s", method, path, w.Code, w.Body)
+		}
+	}
+
+	return w.Code
+}
+
+func TestLoginSessionLogout(t *testing.T) {
+	r := newTestRouter(t)
+
+	if _, err := userService.CreateUser("alice", "alice@example.com", "correct horse"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	credentials := LoginRequest{Email: "Alice@Example.com", Password: "correct horse", Device: "laptop"}
+	if code := serveJSON(t, r, http.MethodPost, "/login", "", credentials, &login); code != http.StatusOK || login.Token == "" {
+		t.Fatalf("login returned %d, token %q", code, login.Token)
+	}
+
+	wrong := LoginRequest{Email: "alice@example.com", Password: "wrong password"}
+	if code := serveJSON(t, r, http.MethodPost, "/login", "", wrong, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong password, got %d", code)
+	}
+
+	if code := serveJSON(t, r, http.MethodGet, "/api/v1/sessions", "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", code)
+	}
+
+	var sessions struct {
+		Sessions []struct {
+			Device string `json:"device"`
+		} `json:"sessions"`
+	}
+	if code := serveJSON(t, r, http.MethodGet, "/api/v1/sessions", login.Token, nil, &sessions); code != http.StatusOK {
+		t.Fatalf("listing sessions returned %d", code)
+	}
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].Device != "laptop" {
+		t.Errorf("unexpected sessions %+v", sessions.Sessions)
+	}
+
+	if code := serveJSON(t, r, http.MethodPost, "/api/v1/logout", login.Token, nil, nil); code != http.StatusOK {
+		t.Fatalf("logout returned %d", code)
+	}
+	if code := serveJSON(t, r, http.MethodGet, "/api/v1/sessions", login.Token, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after logout, got %d", code)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	"wallet/pkg/models"
+)
+
+// CodeRequest carries a TOTP or recovery code
+type CodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// completeLogin finishes a two-factor login with the pre-auth token returned
+// by /login and a TOTP or recovery code
+func completeLogin(c *gin.Context) {
+	var request struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+		Device   string `json:"device"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.CompleteMFALogin(request.MFAToken, request.Code, auth.SessionMeta{
+		Device:    request.Device,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// enrollTOTP starts authenticator app enrollment for the authenticated user
+// and returns the otpauth:// URI with its QR code as a base64 PNG (protected
+// by AuthMiddleware and RequireStepUp)
+func enrollTOTP(c *gin.Context) {
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	uri, err := authService.EnrollTOTP(userData)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"otpauth_url": uri,
+		"qr_png":      base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// totpQRCode returns the QR code of an unconfirmed enrollment as a PNG image
+// (protected by AuthMiddleware and RequireStepUp)
+func totpQRCode(c *gin.Context) {
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	uri, err := auth.TOTPEnrollmentURI(userData)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// confirmTOTP enables two-factor authentication with a code from the newly
+// enrolled authenticator and returns the recovery codes (protected by
+// AuthMiddleware and RequireStepUp)
+func confirmTOTP(c *gin.Context) {
+	var request CodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := authService.ConfirmTOTP(userData, request.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled, store the recovery codes safely",
+		"recovery_codes": codes,
+	})
+}
+
+// disableTOTP turns two-factor authentication off (protected by
+// AuthMiddleware)
+func disableTOTP(c *gin.Context) {
+	var request CodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := authService.DisableTOTP(userData, request.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// stepUp re-verifies the user of the current session with a TOTP code, or
+// with their password if two-factor authentication is off (protected by
+// AuthMiddleware)
+func stepUp(c *gin.Context) {
+	var request struct {
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := authService.StepUp(c.MustGet("session").(models.Token), request.Code, request.Password); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verified", "valid_for": auth.StepUpTTL.String()})
+}
+
+// RequireStepUp rejects requests from sessions that were not re-verified
+// within auth.StepUpTTL. It must run after AuthMiddleware.
+func RequireStepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.CheckStepUp(c.MustGet("session").(models.Token)); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "step_up_required": true})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// currentUser loads the authenticated user, responding with an error if it
+// cannot
+func currentUser(c *gin.Context) (models.User, bool) {
+	userData, err := userService.GetUserByID(c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return models.User{}, false
+	}
+
+	return userData, true
+}
+
+// respondMFAError maps two-factor errors to HTTP responses
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode), errors.Is(err, auth.ErrInvalidToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrTOTPEnabled), errors.Is(err, auth.ErrTOTPNotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
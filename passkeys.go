@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	"wallet/pkg/webauthn"
+)
+
+// beginPasskeyLogin returns the options for navigator.credentials.get, with
+// which any discoverable passkey for this site can be used
+func beginPasskeyLogin(c *gin.Context) {
+	options, challengeID, err := authService.BeginPasskeyLogin()
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"challenge_id": challengeID, "publicKey": options})
+}
+
+// finishPasskeyLogin verifies the assertion from navigator.credentials.get
+// and returns the same tokens as /login
+func finishPasskeyLogin(c *gin.Context) {
+	var request struct {
+		ChallengeID string                     `json:"challenge_id" binding:"required"`
+		Credential  webauthn.AssertionResponse `json:"credential" binding:"required"`
+		Device      string                     `json:"device"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.FinishPasskeyLogin(request.ChallengeID, request.Credential, auth.SessionMeta{
+		Device:    request.Device,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// beginPasskeyRegistration returns the options for
+// navigator.credentials.create (protected by AuthMiddleware and
+// RequireStepUp)
+func beginPasskeyRegistration(c *gin.Context) {
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	options, challengeID, err := authService.BeginPasskeyRegistration(userData)
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"challenge_id": challengeID, "publicKey": options})
+}
+
+// finishPasskeyRegistration verifies the response from
+// navigator.credentials.create and stores the passkey (protected by
+// AuthMiddleware and RequireStepUp)
+func finishPasskeyRegistration(c *gin.Context) {
+	var request struct {
+		ChallengeID string                       `json:"challenge_id" binding:"required"`
+		Credential  webauthn.AttestationResponse `json:"credential" binding:"required"`
+		Name        string                       `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	credential, err := authService.FinishPasskeyRegistration(userData, request.ChallengeID, request.Credential, request.Name)
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+// listPasskeys returns the passkeys of the authenticated user (protected by
+// AuthMiddleware)
+func listPasskeys(c *gin.Context) {
+	credentials, err := authService.ListPasskeys(c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// deletePasskey removes one of the authenticated user's passkeys (protected
+// by AuthMiddleware)
+func deletePasskey(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+
+	if err := authService.DeletePasskey(c.MustGet("user_id").(primitive.ObjectID), id); err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
+
+// respondPasskeyError maps WebAuthn ceremony errors to HTTP responses
+func respondPasskeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrPasskeyNotFound) && c.Request.Method == http.MethodDelete:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrPasskeyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrChallengeNotFound), errors.Is(err, auth.ErrPasskeyNotFound),
+		errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, webauthn.ErrInvalidClientData), errors.Is(err, webauthn.ErrInvalidAuthData),
+		errors.Is(err, webauthn.ErrChallengeMismatch), errors.Is(err, webauthn.ErrOriginMismatch),
+		errors.Is(err, webauthn.ErrRPIDMismatch), errors.Is(err, webauthn.ErrUserNotPresent),
+		errors.Is(err, webauthn.ErrUserNotVerified), errors.Is(err, webauthn.ErrInvalidSignature),
+		errors.Is(err, webauthn.ErrCounterRegressed), errors.Is(err, webauthn.ErrUnsupportedKey):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
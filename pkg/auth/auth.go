@@ -2,213 +2,390 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"log"
 	"time"
 	config "wallet/pkg/config"
 	"wallet/pkg/models"
-	mongodb "wallet/pkg/mongo"
+	"wallet/pkg/repository"
 
 	"github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// UserClaims defines the JWT claims for the user
+// AccessTokenTTL is the lifetime of an access token and its session
+const AccessTokenTTL = 15 * time.Minute
+
+var (
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Service authenticates users and manages their sessions, second factors,
+// passkeys and linked addresses
+type Service struct {
+	users         repository.UserRepository
+	tokens        repository.TokenRepository
+	refreshTokens repository.RefreshTokenRepository
+	credentials   repository.CredentialRepository
+	challenges    repository.ChallengeRepository
+	nonces        repository.NonceRepository
+}
+
+// NewService returns a Service storing its data in repos
+func NewService(repos repository.Repositories) *Service {
+	return &Service{
+		users:         repos.Users,
+		tokens:        repos.Tokens,
+		refreshTokens: repos.RefreshTokens,
+		credentials:   repos.Credentials,
+		challenges:    repos.Challenges,
+		nonces:        repos.Nonces,
+	}
+}
+
+// UserClaims defines the JWT claims for the user. The subject is the user ID
+// and the JWT ID names the session in the tokens collection. Role is read
+// from the user whenever a token is issued, so role changes apply from the
+// next refresh.
 type UserClaims struct {
 	Email string `json:"email"`
+	Role  string `json:"role"`
 	jwt.StandardClaims
 }
 
-// UserLogin authenticates a user and returns a JWT token
-func UserLogin(email string, password string) (string, error) {
-	connection, err := mongodb.Connect()
-	if err != nil {
-		return "", err
-	}
-	defer connection.Disconnect(context.Background())
-
-	var userData models.User
-	collection := connection.Database("wallet").Collection("users")
+// SessionMeta describes the client a session was started from
+type SessionMeta struct {
+	Device    string
+	IP        string
+	UserAgent string
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// UserLogin authenticates a user, records a session for the client and
+// returns its tokens. Users with two-factor authentication get a pre-auth
+// token instead, to be completed with CompleteMFALogin.
+func (s *Service) UserLogin(email string, password string, meta SessionMeta) (LoginResult, error) {
+	ctx := context.Background()
 
-	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&userData)
+	userData, err := s.users.FindByEmail(ctx, email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return "", errors.New("invalid email or password")
+		if errors.Is(err, repository.ErrNotFound) {
+			// Spend the same time as a real check so unknown emails are not revealed
+			VerifyPassword(dummyHash, password)
+			return LoginResult{}, errors.New("invalid email or password")
 		}
-		return "", err
+		return LoginResult{}, err
 	}
 
 	// Compare the hashed password with the provided password
-	err = bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(password))
-	if err != nil {
-		return "", errors.New("invalid email or password")
+	ok, needsRehash, err := VerifyPassword(userData.Password, password)
+	if err != nil || !ok || !userData.Active {
+		return LoginResult{}, errors.New("invalid email or password")
 	}
 
-	// Generate a JWT token with a 15-minute expiration time
-	expirationTime := time.Now().Add(15 * time.Minute)
-	token, err := GenerateJWT(userData.Email, expirationTime)
+	// Upgrade bcrypt or outdated Argon2id hashes while the password is known
+	if needsRehash {
+		if err := s.rehashPassword(ctx, userData, password); err != nil {
+			log.Printf("Failed to rehash password of user %s: %v", userData.ID.Hex(), err)
+		}
+	}
+
+	if userData.TOTP != nil && userData.TOTP.Enabled {
+		mfaToken, err := newMFAToken(userData.ID)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	tokens, _, err := s.GenerateAndStoreToken(userData, meta)
 	if err != nil {
-		return "", err
+		return LoginResult{}, err
 	}
 
-	return token, nil
+	return LoginResult{TokenPair: &tokens}, nil
 }
 
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
-}
+// rehashPassword replaces a user's password hash with one using the current
+// parameters, unless the hash changed since it was read
+func (s *Service) rehashPassword(ctx context.Context, userData models.User, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
 
-// generateJWT generates a JWT token for a given email
-func GenerateJWT(email string, expirationTime time.Time) (string, error) {
-	claims := &UserClaims{
-		Email: email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(), // Set the expiration time here
-		},
+	err = s.users.ReplacePassword(ctx, userData.ID, userData.Password, hash)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return err
+}
 
-	// Sign the token with the secret key from the config
-	tokenString, err := token.SignedString(config.LoadEnv().JWTSecret)
+// GenerateJWT signs the claims with the active key
+func GenerateJWT(claims *UserClaims) (string, error) {
+	ks, err := CurrentKeySet()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	return ks.Sign(claims)
 }
 
-// storeJWTInDB stores the generated JWT in the database
-func StoreJWTInDB(userID primitive.ObjectID, token string, expirationTime time.Time) error {
-	connection, err := mongodb.Connect()
+// ParseJWT checks the signature and expiry of a token and returns its claims.
+// It does not check whether the session is still active; see ValidateToken.
+func ParseJWT(tokenString string) (*UserClaims, error) {
+	ks, err := CurrentKeySet()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer connection.Disconnect(context.Background())
 
-	collection := connection.Database("wallet").Collection("tokens")
+	claims := &UserClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
 
-	tokenData := models.Token{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: expirationTime,
-		IsActive:  true,
+	if claims.Id == "" || claims.Subject == "" || claims.Audience != "" {
+		return nil, ErrInvalidToken
 	}
 
-	_, err = collection.InsertOne(context.Background(), tokenData)
-	if err != nil {
-		return err
+	return claims, nil
+}
+
+// StoreJWTInDB records a session in the tokens collection
+func (s *Service) StoreJWTInDB(session *models.Token) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
 	}
 
-	return nil
+	return s.tokens.Create(context.Background(), *session)
 }
 
-// generateAndStoreToken generates a new token and stores it in the database
-func GenerateAndStoreToken(userID primitive.ObjectID) (string, error) {
-	// Generate a new JWT
-	expirationTime := time.Now().Add(15 * time.Minute)
-	token, err := GenerateJWT(userID.Hex(), expirationTime)
+// GenerateAndStoreToken starts a session for the user and returns its
+// access and refresh tokens
+func (s *Service) GenerateAndStoreToken(userData models.User, meta SessionMeta) (TokenPair, models.Token, error) {
+	now := time.Now()
+	session := models.Token{
+		UserID:           userData.ID,
+		TokenID:          newTokenID(),
+		Device:           meta.Device,
+		IP:               meta.IP,
+		UserAgent:        meta.UserAgent,
+		IssuedAt:         now,
+		ExpiresAt:        now.Add(AccessTokenTTL),
+		RefreshExpiresAt: now.Add(RefreshTokenTTL),
+		LastSeen:         now,
+		IsActive:         true,
+	}
+
+	token, err := GenerateJWT(sessionClaims(userData.Email, UserRole(userData), session))
 	if err != nil {
-		return "", err
+		return TokenPair{}, models.Token{}, err
+	}
+
+	// Store the session in the database
+	if err := s.StoreJWTInDB(&session); err != nil {
+		return TokenPair{}, models.Token{}, err
 	}
 
-	// Store the token in the database
-	err = StoreJWTInDB(userID, token, expirationTime)
+	refreshToken, err := s.storeRefreshToken(session)
 	if err != nil {
-		return "", err
+		return TokenPair{}, models.Token{}, err
 	}
 
-	return token, nil
+	return TokenPair{AccessToken: token, RefreshToken: refreshToken, ExpiresAt: session.ExpiresAt}, session, nil
 }
 
-// validateAndRefreshToken validates the JWT and refreshes it if near expiration
-func ValidateAndRefreshToken(userID primitive.ObjectID, token string) (string, error) {
-	connection, err := mongodb.Connect()
+// ValidateAndRefreshToken exchanges a refresh token for a new access token
+// and a new refresh token. Each refresh token can be used once: presenting
+// one that was already used revokes the whole session, since either the
+// client or an attacker holds a stolen copy.
+func (s *Service) ValidateAndRefreshToken(refreshToken string) (TokenPair, error) {
+	ctx := context.Background()
+
+	record, err := s.useRefreshToken(ctx, refreshToken)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		log.Printf("Refresh token reuse detected, revoking session %s", record.SessionID.Hex())
+		if _, revokeErr := s.tokens.Revoke(ctx, repository.SessionFilter{ID: record.SessionID}, time.Now()); revokeErr != nil {
+			log.Printf("Failed to revoke session %s: %v", record.SessionID.Hex(), revokeErr)
+		}
+		return TokenPair{}, err
+	}
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
-	defer connection.Disconnect(context.Background())
 
-	collection := connection.Database("wallet").Collection("tokens")
+	session, err := s.tokens.FindByID(ctx, record.SessionID)
+	if err == nil && !session.IsActive {
+		err = repository.ErrNotFound
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPair{}, ErrSessionRevoked
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
 
-	var tokenData models.Token
-	err = collection.FindOne(context.Background(), bson.M{"token": token, "user_id": userID, "is_active": true}).Decode(&tokenData)
+	userData, err := s.users.FindByID(ctx, session.UserID)
+	if err == nil && !userData.Active {
+		err = repository.ErrNotFound
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPair{}, ErrSessionRevoked
+	}
 	if err != nil {
-		return "", errors.New("invalid token")
+		return TokenPair{}, err
 	}
 
-	// If the token is near expiration, refresh it
-	if time.Until(tokenData.ExpiresAt) < 5*time.Minute {
-		newToken, err := GenerateAndStoreToken(userID)
-		if err != nil {
-			return "", err
-		}
+	// Give the session a new token ID so the previous access token stops working
+	now := time.Now()
+	session.TokenID = newTokenID()
+	session.ExpiresAt = now.Add(AccessTokenTTL)
+	if session.ExpiresAt.After(session.RefreshExpiresAt) {
+		session.ExpiresAt = session.RefreshExpiresAt
+	}
+	err = s.tokens.Rotate(ctx, session.ID, session.TokenID, session.ExpiresAt, now)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPair{}, ErrSessionRevoked
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
 
-		// Mark old token as inactive
-		_, err = collection.UpdateOne(context.Background(), bson.M{"_id": tokenData.ID}, bson.M{"$set": bson.M{"is_active": false}})
-		if err != nil {
-			return "", err
-		}
+	token, err := GenerateJWT(sessionClaims(userData.Email, UserRole(userData), session))
+	if err != nil {
+		return TokenPair{}, err
+	}
 
-		return newToken, nil
+	next, err := s.storeRefreshToken(session)
+	if err != nil {
+		return TokenPair{}, err
 	}
 
-	return token, nil
+	return TokenPair{AccessToken: token, RefreshToken: next, ExpiresAt: session.ExpiresAt}, nil
 }
 
-// validateToken validates the token by checking the database and its expiration
-func ValidateToken(token string) (primitive.ObjectID, error) {
-	connection, err := mongodb.Connect()
+// ValidateToken checks a token and that its session is still active
+func (s *Service) ValidateToken(tokenString string) (*UserClaims, models.Token, error) {
+	claims, err := ParseJWT(tokenString)
 	if err != nil {
-		return primitive.NilObjectID, err
+		return nil, models.Token{}, err
 	}
-	defer connection.Disconnect(context.Background())
 
-	collection := connection.Database("wallet").Collection("tokens")
+	ctx := context.Background()
 
-	var tokenData models.Token
-	err = collection.FindOne(context.Background(), bson.M{"token": token, "is_active": true}).Decode(&tokenData)
+	session, err := s.tokens.FindByTokenID(ctx, claims.Id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, models.Token{}, ErrInvalidToken
+	}
 	if err != nil {
-		return primitive.NilObjectID, errors.New("invalid token")
+		return nil, models.Token{}, err
+	}
+
+	if !session.IsActive || session.UserID.Hex() != claims.Subject {
+		return nil, models.Token{}, ErrSessionRevoked
 	}
 
-	// Check if the token is expired
-	if tokenData.ExpiresAt.Before(time.Now()) {
-		return primitive.NilObjectID, errors.New("token expired")
+	// Check if the session is expired
+	if session.ExpiresAt.Before(time.Now()) {
+		return nil, models.Token{}, ErrInvalidToken
+	}
+
+	// Record activity at most once a minute
+	if time.Since(session.LastSeen) > time.Minute {
+		session.LastSeen = time.Now()
+		if err := s.tokens.Touch(ctx, session.ID, session.LastSeen); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
+		}
 	}
 
-	return tokenData.UserID, nil
+	return claims, session, nil
 }
 
-// expireOldTokens invalidates expired tokens in the database
-func ExpireOldTokens() {
-	connection, err := mongodb.Connect()
+// ListSessions returns the active sessions of a user that can still be
+// refreshed, most recently used first
+func (s *Service) ListSessions(userID primitive.ObjectID) ([]models.Token, error) {
+	return s.tokens.ListActive(context.Background(), userID, time.Now())
+}
+
+// RevokeSession ends one session of a user
+func (s *Service) RevokeSession(userID primitive.ObjectID, sessionID primitive.ObjectID) error {
+	revoked, err := s.revokeSessions(repository.SessionFilter{ID: sessionID, UserID: userID})
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
-		return
+		return err
+	}
+	if revoked == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllSessions ends every session of a user and returns how many were
+// active
+func (s *Service) RevokeAllSessions(userID primitive.ObjectID) (int64, error) {
+	return s.revokeSessions(repository.SessionFilter{UserID: userID})
+}
+
+// RevokeOtherSessions ends every session of a user except keep and returns
+// how many were active
+func (s *Service) RevokeOtherSessions(userID primitive.ObjectID, keep primitive.ObjectID) (int64, error) {
+	return s.revokeSessions(repository.SessionFilter{UserID: userID, Except: keep})
+}
+
+// revokeSessions deactivates the active sessions matching filter
+func (s *Service) revokeSessions(filter repository.SessionFilter) (int64, error) {
+	return s.tokens.Revoke(context.Background(), filter, time.Now())
+}
+
+// ExpireOldTokens invalidates expired sessions in the database
+func (s *Service) ExpireOldTokens() error {
+	// Find and invalidate expired sessions
+	if _, err := s.tokens.Expire(context.Background(), time.Now()); err != nil {
+		return err
 	}
-	defer connection.Disconnect(context.Background())
 
-	collection := connection.Database("wallet").Collection("tokens")
+	log.Println("Expired tokens invalidated")
+	return nil
+}
 
-	// Find and invalidate expired tokens
-	_, err = collection.UpdateMany(
-		context.Background(),
-		bson.M{"expires_at": bson.M{"$lt": time.Now()}},
-		bson.M{"$set": bson.M{"is_active": false}},
-	)
+// sessionClaims builds the token claims of a session
+func sessionClaims(email string, role string, session models.Token) *UserClaims {
+	return &UserClaims{
+		Email: email,
+		Role:  role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        session.TokenID,
+			Subject:   session.UserID.Hex(),
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: session.ExpiresAt.Unix(),
+		},
+	}
+}
+
+// newTokenID returns a random JWT ID
+func newTokenID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// jwtSecret returns the HMAC key of verification tokens
+func jwtSecret() ([]byte, error) {
+	cfg, err := config.Get()
 	if err != nil {
-		log.Fatalf("Error expiring tokens: %v", err)
-		return
+		return nil, err
+	}
+	if cfg.JWT.Secret == "" {
+		return nil, errors.New("jwtSecret is not configured")
 	}
 
-	log.Println("Expired tokens invalidated")
+	return []byte(cfg.JWT.Secret), nil
 }
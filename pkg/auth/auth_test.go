@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/models"
+)
+
+func testSession() models.Token {
+	return models.Token{
+		UserID:    primitive.NewObjectID(),
+		TokenID:   newTokenID(),
+		ExpiresAt: time.Now().Add(AccessTokenTTL),
+	}
+}
+
+// useKeySet makes tests sign and verify access tokens with a fresh key
+func useKeySet(t *testing.T, keyType string) *KeySet {
+	t.Helper()
+
+	data, err := GenerateKeyPEM(keyType)
+	if err != nil {
+		t.Fatalf("GenerateKeyPEM failed: %v", err)
+	}
+	ks, err := NewKeySet("test", map[string][]byte{"test": data})
+	if err != nil {
+		t.Fatalf("NewKeySet failed: %v", err)
+	}
+
+	keysMu.Lock()
+	previous := keys
+	keys = ks
+	keysMu.Unlock()
+	t.Cleanup(func() {
+		keysMu.Lock()
+		keys = previous
+		keysMu.Unlock()
+	})
+
+	return ks
+}
+
+func TestJWTRoundTrip(t *testing.T) {
+	useKeySet(t, "ed25519")
+
+	session := testSession()
+	token, err := GenerateJWT(sessionClaims("alice@example.com", models.RoleUser, session))
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	claims, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT failed: %v", err)
+	}
+	if claims.Email != "alice@example.com" || claims.Id != session.TokenID || claims.Subject != session.UserID.Hex() {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestParseJWTRejects(t *testing.T) {
+	t.Setenv("jwtSecret", "test-secret")
+	useKeySet(t, "ed25519")
+
+	expired := testSession()
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	expiredToken, _ := GenerateJWT(sessionClaims("alice@example.com", models.RoleUser, expired))
+
+	noID := testSession()
+	noID.TokenID = ""
+	noIDToken, _ := GenerateJWT(sessionClaims("alice@example.com", models.RoleUser, noID))
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, sessionClaims("alice@example.com", models.RoleUser, testSession())).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	verification, _ := GenerateVerificationToken(primitive.NewObjectID(), "alice@example.com", time.Hour)
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionClaims("alice@example.com", models.RoleUser, testSession())).
+		SignedString([]byte("test-secret"))
+
+	other := useKeySet(t, "ed25519")
+	otherKey, _ := other.Sign(sessionClaims("alice@example.com", models.RoleUser, testSession()))
+	useKeySet(t, "ed25519")
+
+	tests := map[string]string{
+		"expired":            expiredToken,
+		"without a JWT ID":   noIDToken,
+		"unsigned":           unsigned,
+		"verification token": verification,
+		"HMAC signed":        hmac,
+		"other key":          otherKey,
+		"garbage":            "not.a.token",
+	}
+	for name, token := range tests {
+		if _, err := ParseJWT(token); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestNewTokenIDIsUnique(t *testing.T) {
+	if a, b := newTokenID(), newTokenID(); a == b || len(a) != 32 {
+		t.Errorf("unexpected token IDs %q and %q", a, b)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	a, err := newRefreshToken()
+	if err != nil {
+		t.Fatalf("newRefreshToken failed: %v", err)
+	}
+	b, _ := newRefreshToken()
+	if a == b || len(a) != 43 {
+		t.Errorf("unexpected refresh tokens %q and %q", a, b)
+	}
+
+	if hashRefreshToken(a) != hashRefreshToken(a) || hashRefreshToken(a) == hashRefreshToken(b) {
+		t.Error("refresh token hashes must be deterministic and distinct")
+	}
+	if hashRefreshToken(a) == a {
+		t.Error("refresh tokens must not be stored verbatim")
+	}
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+
+	config "wallet/pkg/config"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrNoSigningKey   = errors.New("active JWT key has no private key")
+	ErrUnsupportedKey = errors.New("unsupported key type, expected RSA or Ed25519")
+	ErrNoKeys         = errors.New("no JWT keys configured, set jwtKeys or ephemeralJWTKey")
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing keys
+const minRSABits = 2048
+
+// SigningMethodEdDSA signs tokens with Ed25519 (RFC 8037), which jwt-go v3
+// does not implement
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEd25519 struct{}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+// signingKey is one key of a KeySet. Keys being rotated out only have a
+// public key and are kept so tokens they signed stay valid until expiry.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet holds the keys access tokens are signed and verified with
+type KeySet struct {
+	active string
+	keys   map[string]signingKey
+	order  []string
+}
+
+// NewKeySet builds a key set from PEM encoded keys indexed by key ID. Each
+// key is a PKCS#8 private key or, for keys that only verify, a PKIX public
+// key. The active key signs new tokens and must be a private key.
+func NewKeySet(active string, pemKeys map[string][]byte) (*KeySet, error) {
+	ks := &KeySet{active: active, keys: map[string]signingKey{}}
+
+	for id, data := range pemKeys {
+		key, err := parseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", id, err)
+		}
+		ks.keys[id] = key
+		ks.order = append(ks.order, id)
+	}
+	sort.Strings(ks.order)
+
+	key, ok := ks.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+	if key.private == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return ks, nil
+}
+
+// LoadKeySet reads the PEM files of the configured JWT keys. The active key
+// signs tokens, by default the first one listed. Without keys an ephemeral
+// Ed25519 key is generated if the configuration allows it, so tokens do not
+// survive a restart.
+func LoadKeySet() (*KeySet, error) {
+	all, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	cfg := all.JWT
+
+	if len(cfg.Keys) == 0 {
+		if !cfg.EphemeralKey {
+			return nil, ErrNoKeys
+		}
+		log.Println("No jwtKeys configured, signing tokens with an ephemeral key")
+		data, err := GenerateKeyPEM("ed25519")
+		if err != nil {
+			return nil, err
+		}
+		return NewKeySet("ephemeral", map[string][]byte{"ephemeral": data})
+	}
+
+	active := cfg.ActiveKey
+	if active == "" {
+		active = cfg.Keys[0].ID
+	}
+
+	pemKeys := map[string][]byte{}
+	for _, key := range cfg.Keys {
+		data, err := os.ReadFile(key.Value)
+		if err != nil {
+			return nil, err
+		}
+		pemKeys[key.ID] = data
+	}
+
+	return NewKeySet(active, pemKeys)
+}
+
+// Sign signs claims with the active key and names it in the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.active]
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns the verification key named by a token's kid header. The
+// token's algorithm must be the one of that key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], id)
+	}
+
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys of the set, for /.well-known/jwks.json
+func (ks *KeySet) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0, len(ks.order))
+	for _, id := range ks.order {
+		key := ks.keys[id]
+		jwk := JWK{KeyID: id, Use: "sig", Alg: key.method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return map[string][]JWK{"keys": keys}
+}
+
+// GenerateKeyPEM generates an "rsa" or "ed25519" private key and returns it
+// PEM encoded in PKCS#8 form, ready to be listed in jwtKeys
+func GenerateKeyPEM(keyType string) ([]byte, error) {
+	var key crypto.Signer
+	var err error
+
+	switch keyType {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedKey, keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// KeyFingerprint returns a short identifier of a PEM key's public half,
+// useful as a key ID
+func KeyFingerprint(data []byte) (string, error) {
+	key, err := parseKey("", data)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// parseKey parses a PEM encoded PKCS#8 private key or PKIX public key
+func parseKey(id string, data []byte) (signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errors.New("no PEM data found")
+	}
+
+	key := signingKey{id: id}
+	var public interface{}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return signingKey{}, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return signingKey{}, ErrUnsupportedKey
+		}
+		key.private = signer
+		public = signer.Public()
+	case "PUBLIC KEY":
+		var err error
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return signingKey{}, err
+		}
+	default:
+		return signingKey{}, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSABits {
+			return signingKey{}, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = public
+	case ed25519.PublicKey:
+		key.method = SigningMethodEdDSA
+		key.public = public
+	default:
+		return signingKey{}, ErrUnsupportedKey
+	}
+
+	return key, nil
+}
+
+var (
+	keysMu sync.Mutex
+	keys   *KeySet
+)
+
+// CurrentKeySet returns the key set access tokens are signed with, loading
+// it from the configuration on first use
+func CurrentKeySet() (*KeySet, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	if keys == nil {
+		loaded, err := LoadKeySet()
+		if err != nil {
+			return nil, err
+		}
+		keys = loaded
+	}
+
+	return keys, nil
+}
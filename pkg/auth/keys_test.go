@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"wallet/pkg/models"
+)
+
+func TestKeySetSignsAndVerifies(t *testing.T) {
+	for _, keyType := range []string{"rsa", "ed25519"} {
+		data, err := GenerateKeyPEM(keyType)
+		if err != nil {
+			t.Fatalf("GenerateKeyPEM(%s) failed: %v", keyType, err)
+		}
+		ks, err := NewKeySet("k1", map[string][]byte{"k1": data})
+		if err != nil {
+			t.Fatalf("NewKeySet(%s) failed: %v", keyType, err)
+		}
+
+		signed, err := ks.Sign(sessionClaims("alice@example.com", models.RoleUser, testSession()))
+		if err != nil {
+			t.Fatalf("Sign(%s) failed: %v", keyType, err)
+		}
+
+		token, err := jwt.ParseWithClaims(signed, &UserClaims{}, ks.Keyfunc)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s token did not verify: %v", keyType, err)
+		}
+		if token.Header["kid"] != "k1" {
+			t.Errorf("%s token has kid %v", keyType, token.Header["kid"])
+		}
+
+		want := map[string]string{"rsa": "RS256", "ed25519": "EdDSA"}[keyType]
+		if token.Method.Alg() != want {
+			t.Errorf("expected %s, got %s", want, token.Method.Alg())
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, _ := GenerateKeyPEM("ed25519")
+	newKey, _ := GenerateKeyPEM("rsa")
+
+	before, err := NewKeySet("old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatalf("NewKeySet failed: %v", err)
+	}
+	oldToken, _ := before.Sign(sessionClaims("alice@example.com", models.RoleUser, testSession()))
+
+	// After rotation the old key is only kept as a public key
+	after, err := NewKeySet("new", map[string][]byte{"old": publicPEM(t, oldKey), "new": newKey})
+	if err != nil {
+		t.Fatalf("NewKeySet failed: %v", err)
+	}
+	if _, err := jwt.ParseWithClaims(oldToken, &UserClaims{}, after.Keyfunc); err != nil {
+		t.Errorf("token signed with the retired key should still verify: %v", err)
+	}
+
+	newToken, _ := after.Sign(sessionClaims("alice@example.com", models.RoleUser, testSession()))
+	if _, err := jwt.ParseWithClaims(newToken, &UserClaims{}, before.Keyfunc); err == nil {
+		t.Error("token signed with an unknown kid must not verify")
+	}
+
+	if _, err := NewKeySet("old", map[string][]byte{"old": publicPEM(t, oldKey)}); err != ErrNoSigningKey {
+		t.Errorf("expected ErrNoSigningKey for a public-only active key, got %v", err)
+	}
+
+	jwks := after.JWKS()["keys"]
+	if len(jwks) != 2 || jwks[0].KeyID != "new" || jwks[0].KeyType != "RSA" || jwks[0].E != "AQAB" ||
+		jwks[1].KeyID != "old" || jwks[1].KeyType != "OKP" || jwks[1].Curve != "Ed25519" || len(jwks[1].X) != 43 {
+		t.Errorf("unexpected JWKS %+v", jwks)
+	}
+}
+
+func TestKeyfuncRejectsAlgorithmConfusion(t *testing.T) {
+	data, _ := GenerateKeyPEM("rsa")
+	ks, _ := NewKeySet("k1", map[string][]byte{"k1": data})
+
+	// HS256 signed with the public key, hoping the verifier uses it as a secret
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionClaims("alice@example.com", models.RoleUser, testSession()))
+	token.Header["kid"] = "k1"
+	forged, _ := token.SignedString(publicPEM(t, data))
+
+	if _, err := jwt.ParseWithClaims(forged, &UserClaims{}, ks.Keyfunc); err == nil {
+		t.Error("HS256 token accepted for an RSA key")
+	}
+}
+
+func TestLoadKeySetRequiresKeys(t *testing.T) {
+	t.Setenv("jwtKeys", "")
+	t.Setenv("ephemeralJWTKey", "")
+	if _, err := LoadKeySet(); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("expected ErrNoKeys, got %v", err)
+	}
+
+	t.Setenv("ephemeralJWTKey", "true")
+	if _, err := LoadKeySet(); err != nil {
+		t.Errorf("expected an ephemeral key, got %v", err)
+	}
+}
+
+// publicPEM returns the PEM encoded public half of a private key
+func publicPEM(t *testing.T, privatePEM []byte) []byte {
+	t.Helper()
+
+	key, err := parseKey("", privatePEM)
+	if err != nil {
+		t.Fatalf("parseKey failed: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
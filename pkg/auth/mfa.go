@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/models"
+	"wallet/pkg/repository"
+	"wallet/pkg/vault"
+)
+
+const (
+	// MFATokenTTL is how long a pre-auth token waits for the second factor
+	MFATokenTTL = 5 * time.Minute
+	// StepUpTTL is how long a step-up verification authorises sensitive actions
+	StepUpTTL = 5 * time.Minute
+
+	// mfaAudience marks pre-auth tokens, which ParseJWT never accepts as
+	// access tokens
+	mfaAudience = "mfa"
+
+	recoveryCodeCount = 10
+	maxMFAFailures    = 5
+	mfaLockout        = 15 * time.Minute
+)
+
+var (
+	ErrTOTPEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidCode     = errors.New("invalid verification code")
+	ErrMFALocked       = errors.New("too many invalid codes, try again later")
+	ErrStepUpRequired  = errors.New("recent verification required")
+)
+
+// LoginResult is the outcome of a password login. Users with two-factor
+// authentication get an MFAToken to complete the login with instead of
+// session tokens.
+type LoginResult struct {
+	*TokenPair
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// EnrollTOTP generates a TOTP secret for the user, replacing any enrollment
+// that was not confirmed yet, and returns its otpauth:// URI
+func (s *Service) EnrollTOTP(userData models.User) (string, error) {
+	if userData.TOTP != nil && userData.TOTP.Enabled {
+		return "", ErrTOTPEnabled
+	}
+
+	keyring, err := vault.LoadKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := keyring.Seal([]byte(secret), totpAAD(userData.ID))
+	if err != nil {
+		return "", err
+	}
+
+	err = s.users.EnrollTOTP(context.Background(), userData.ID, sealed)
+	if errors.Is(err, repository.ErrConflict) {
+		return "", ErrTOTPEnabled
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return TOTPURI(secret, userData.Email), nil
+}
+
+// TOTPEnrollmentURI returns the otpauth:// URI of an unconfirmed enrollment
+func TOTPEnrollmentURI(userData models.User) (string, error) {
+	if userData.TOTP == nil {
+		return "", ErrTOTPNotEnrolled
+	}
+	if userData.TOTP.Enabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret, err := openTOTPSecret(userData)
+	if err != nil {
+		return "", err
+	}
+
+	return TOTPURI(secret, userData.Email), nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their
+// authenticator works. It returns recovery codes, which are only stored
+// hashed and cannot be shown again.
+func (s *Service) ConfirmTOTP(userData models.User, code string) ([]string, error) {
+	if userData.TOTP == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if userData.TOTP.Enabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	secret, err := openTOTPSecret(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now(), userData.TOTP.LastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.users.EnableTOTP(context.Background(), userData.ID, userData.TOTP.Secret, step, hashes, time.Now())
+	if err := secondFactorError(err); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a code
+func (s *Service) DisableTOTP(userData models.User, code string) error {
+	if err := s.VerifySecondFactor(userData, code); err != nil {
+		return err
+	}
+
+	return s.users.DisableTOTP(context.Background(), userData.ID)
+}
+
+// VerifySecondFactor checks a TOTP code or a recovery code. Recovery codes
+// are spent on use and TOTP codes cannot be replayed. Repeated failures lock
+// the second factor for a while.
+func (s *Service) VerifySecondFactor(userData models.User, code string) error {
+	if userData.TOTP == nil || !userData.TOTP.Enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	totp := userData.TOTP
+	if mfaLocked(totp) {
+		return ErrMFALocked
+	}
+
+	secret, err := openTOTPSecret(userData)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if step, ok := ValidateTOTP(secret, code, time.Now(), totp.LastStep); ok {
+		// Recording the step only if it advances stops concurrent replays
+		return secondFactorError(s.users.AdvanceTOTPStep(ctx, userData.ID, step))
+	}
+
+	if hash := hashRecoveryCode(code); hash != "" {
+		err := secondFactorError(s.users.UseRecoveryCode(ctx, userData.ID, hash))
+		if !errors.Is(err, ErrInvalidCode) {
+			return err
+		}
+	}
+
+	if err := s.recordMFAFailure(userData); err != nil {
+		return err
+	}
+
+	return ErrInvalidCode
+}
+
+// CompleteMFALogin finishes a login with the pre-auth token from UserLogin
+// and a TOTP or recovery code, and starts the session
+func (s *Service) CompleteMFALogin(mfaToken string, code string, meta SessionMeta) (TokenPair, error) {
+	userID, err := parseMFAToken(mfaToken)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	userData, err := s.findActiveUser(userID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.VerifySecondFactor(userData, code); err != nil {
+		return TokenPair{}, err
+	}
+
+	tokens, session, err := s.GenerateAndStoreToken(userData, meta)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// The login itself counts as a fresh verification
+	if err := s.markStepUp(session.ID); err != nil {
+		return TokenPair{}, err
+	}
+
+	return tokens, nil
+}
+
+// StepUp re-verifies the user of a session before a sensitive action. Users
+// with two-factor authentication must give a code; others their password.
+func (s *Service) StepUp(session models.Token, code string, password string) error {
+	userData, err := s.findActiveUser(session.UserID)
+	if err != nil {
+		return err
+	}
+
+	if userData.TOTP != nil && userData.TOTP.Enabled {
+		if err := s.VerifySecondFactor(userData, code); err != nil {
+			return err
+		}
+	} else {
+		if err := s.verifyStepUpPassword(userData, password); err != nil {
+			return err
+		}
+	}
+
+	return s.markStepUp(session.ID)
+}
+
+// CheckStepUp reports whether a session was re-verified recently enough for
+// a sensitive action
+func CheckStepUp(session models.Token) error {
+	if session.StepUpAt == nil || time.Since(*session.StepUpAt) > StepUpTTL {
+		return ErrStepUpRequired
+	}
+
+	return nil
+}
+
+// newMFAToken signs a pre-auth token for a user who passed the password check
+func newMFAToken(userID primitive.ObjectID) (string, error) {
+	ks, err := CurrentKeySet()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return ks.Sign(&jwt.StandardClaims{
+		Id:        newTokenID(),
+		Subject:   userID.Hex(),
+		Audience:  mfaAudience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(MFATokenTTL).Unix(),
+	})
+}
+
+// parseMFAToken checks a pre-auth token and returns its user
+func parseMFAToken(tokenString string) (primitive.ObjectID, error) {
+	ks, err := CurrentKeySet()
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.Keyfunc)
+	if err != nil || !token.Valid || !claims.VerifyAudience(mfaAudience, true) {
+		return primitive.NilObjectID, ErrInvalidToken
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// markStepUp records a successful re-verification on a session
+func (s *Service) markStepUp(sessionID primitive.ObjectID) error {
+	return s.tokens.MarkStepUp(context.Background(), sessionID, time.Now())
+}
+
+// secondFactorError reports the conditional update of a successful
+// verification that no longer applies, e.g. because the code was used
+// concurrently, as ErrInvalidCode
+func secondFactorError(err error) error {
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrInvalidCode
+	}
+
+	return err
+}
+
+// verifyStepUpPassword checks the password of a user without a second
+// factor. Wrong passwords count and lock like invalid codes, so a stolen
+// session cannot guess the password.
+func (s *Service) verifyStepUpPassword(userData models.User, password string) error {
+	if mfaLocked(userData.TOTP) {
+		return ErrMFALocked
+	}
+
+	ok, _, err := VerifyPassword(userData.Password, password)
+	if err != nil || !ok {
+		if err := s.recordMFAFailure(userData); err != nil {
+			return err
+		}
+		return ErrInvalidCode
+	}
+
+	if userData.TOTP != nil && userData.TOTP.Failures > 0 {
+		return s.users.ClearTOTPFailures(context.Background(), userData.ID)
+	}
+
+	return nil
+}
+
+// mfaLocked reports whether too many invalid codes or passwords locked the
+// second factor
+func mfaLocked(totp *models.TOTPConfig) bool {
+	return totp != nil && totp.LockedUntil != nil && totp.LockedUntil.After(time.Now())
+}
+
+// recordMFAFailure counts an invalid code or step-up password. The
+// repository locks the second factor in the same write once there are too
+// many.
+func (s *Service) recordMFAFailure(userData models.User) error {
+	_, err := s.users.RecordTOTPFailure(context.Background(), userData.ID, maxMFAFailures, time.Now().Add(mfaLockout))
+	return err
+}
+
+// openTOTPSecret decrypts the user's TOTP secret
+func openTOTPSecret(userData models.User) (string, error) {
+	keyring, err := vault.LoadKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := keyring.Open(userData.TOTP.Secret, totpAAD(userData.ID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// totpAAD binds a TOTP secret to its user, so it cannot be copied to another
+// user or mistaken for a wallet secret
+func totpAAD(userID primitive.ObjectID) []byte {
+	return append(append([]byte(nil), userID[:]...), "totp"...)
+}
+
+// newRecoveryCodes returns recovery codes such as "k3f9a-2mxq7" and their
+// hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalises and hashes a recovery code. Recovery codes are
+// random enough that a fast hash does not make them guessable. It returns ""
+// for input that cannot be a recovery code.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// findActiveUser returns an active user, or ErrInvalidToken if there is
+// none with userID
+func (s *Service) findActiveUser(userID primitive.ObjectID) (models.User, error) {
+	return activeUser(s.users.FindByID(context.Background(), userID))
+}
+
+// activeUser turns the result of a user lookup into ErrInvalidToken unless
+// it found an active user
+func activeUser(userData models.User, err error) (models.User, error) {
+	if err == nil && !userData.Active {
+		err = repository.ErrNotFound
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return userData, nil
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	config "wallet/pkg/config"
+	"wallet/pkg/models"
+	"wallet/pkg/repository"
+	"wallet/pkg/webauthn"
+)
+
+const (
+	ceremonyCreate = "create"
+	ceremonyGet    = "get"
+)
+
+var (
+	ErrChallengeNotFound = errors.New("unknown or expired WebAuthn challenge")
+	ErrPasskeyNotFound   = errors.New("passkey not found")
+	ErrPasskeyExists     = errors.New("passkey is already registered")
+)
+
+// WebAuthnConfig returns the relying party settings. The RP ID defaults to
+// "localhost" and the origins to the app URL.
+func WebAuthnConfig() (webauthn.Config, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return webauthn.Config{}, err
+	}
+
+	rp := webauthn.Config{RPID: cfg.WebAuthn.RPID, RPName: cfg.WebAuthn.RPName}
+	if rp.RPID == "" {
+		rp.RPID = "localhost"
+	}
+	if rp.RPName == "" {
+		rp.RPName = totpIssuer
+	}
+	for _, origin := range cfg.WebAuthn.Origins {
+		rp.Origins = append(rp.Origins, strings.TrimRight(origin, "/"))
+	}
+	if len(rp.Origins) == 0 {
+		origin := strings.TrimRight(cfg.AppURL, "/")
+		if origin == "" {
+			origin = "http://localhost:8080"
+		}
+		rp.Origins = []string{origin}
+	}
+
+	return rp, nil
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the user and
+// returns the options for navigator.credentials.create with the ID of the
+// ceremony
+func (s *Service) BeginPasskeyRegistration(userData models.User) (webauthn.CreationOptions, string, error) {
+	credentials, err := s.ListPasskeys(userData.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+
+	existing := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		existing = append(existing, credential.CredentialID)
+	}
+
+	rp, err := WebAuthnConfig()
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+
+	challenge, challengeID, err := s.newChallenge(ceremonyCreate, userData.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, "", err
+	}
+
+	options := rp.NewCreationOptions(challenge, webauthn.User{
+		ID:          userData.ID[:],
+		Name:        userData.Email,
+		DisplayName: userData.Username,
+	}, existing)
+
+	return options, challengeID, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores
+// the new passkey
+func (s *Service) FinishPasskeyRegistration(userData models.User, challengeID string, response webauthn.AttestationResponse, name string) (models.WebAuthnCredential, error) {
+	pending, err := s.takeChallenge(challengeID, ceremonyCreate)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	if pending.UserID != userData.ID {
+		return models.WebAuthnCredential{}, ErrChallengeNotFound
+	}
+
+	rp, err := WebAuthnConfig()
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	verified, err := rp.VerifyRegistration(pending.Challenge, response)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+	credential := models.WebAuthnCredential{
+		ID:           primitive.NewObjectID(),
+		UserID:       userData.ID,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		Algorithm:    verified.Algorithm,
+		SignCount:    verified.SignCount,
+		AAGUID:       verified.AAGUID,
+		Name:         name,
+		CreatedAt:    time.Now(),
+	}
+
+	err = s.credentials.Create(context.Background(), credential)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return models.WebAuthnCredential{}, ErrPasskeyExists
+	}
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	return credential, nil
+}
+
+// BeginPasskeyLogin starts a passkey login. The authenticator offers its
+// discoverable credentials for this site, so the options are the same for
+// everyone and do not reveal which accounts exist or have passkeys.
+func (s *Service) BeginPasskeyLogin() (webauthn.RequestOptions, string, error) {
+	rp, err := WebAuthnConfig()
+	if err != nil {
+		return webauthn.RequestOptions{}, "", err
+	}
+
+	challenge, challengeID, err := s.newChallenge(ceremonyGet, primitive.NilObjectID)
+	if err != nil {
+		return webauthn.RequestOptions{}, "", err
+	}
+
+	return rp.NewRequestOptions(challenge, nil), challengeID, nil
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and starts a session. A
+// passkey with user verification is two factors on its own, so the session
+// counts as freshly verified for step-up.
+func (s *Service) FinishPasskeyLogin(challengeID string, response webauthn.AssertionResponse, meta SessionMeta) (TokenPair, error) {
+	pending, err := s.takeChallenge(challengeID, ceremonyGet)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	ctx := context.Background()
+
+	credential, err := s.credentials.FindByCredentialID(ctx, response.RawID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPair{}, ErrPasskeyNotFound
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if len(response.Response.UserHandle) > 0 && !bytes.Equal(response.Response.UserHandle, credential.UserID[:]) {
+		return TokenPair{}, ErrPasskeyNotFound
+	}
+
+	rp, err := WebAuthnConfig()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	signCount, err := rp.VerifyAssertion(pending.Challenge, webauthn.Credential{
+		ID:        credential.CredentialID,
+		PublicKey: credential.PublicKey,
+		Algorithm: credential.Algorithm,
+		SignCount: credential.SignCount,
+	}, response)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// Store the counter only if no concurrent login moved it meanwhile
+	err = s.credentials.UpdateSignCount(ctx, credential.ID, credential.SignCount, signCount, time.Now())
+	if errors.Is(err, repository.ErrConflict) {
+		return TokenPair{}, webauthn.ErrCounterRegressed
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	userData, err := s.findActiveUser(credential.UserID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	tokens, session, err := s.GenerateAndStoreToken(userData, meta)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.markStepUp(session.ID); err != nil {
+		return TokenPair{}, err
+	}
+
+	return tokens, nil
+}
+
+// ListPasskeys returns the passkeys of a user
+func (s *Service) ListPasskeys(userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	return s.credentials.ListByUser(context.Background(), userID)
+}
+
+// DeletePasskey removes one of the user's passkeys
+func (s *Service) DeletePasskey(userID primitive.ObjectID, id primitive.ObjectID) error {
+	err := s.credentials.Delete(context.Background(), userID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrPasskeyNotFound
+	}
+
+	return err
+}
+
+// newChallenge stores a challenge for a ceremony and returns it with its ID
+func (s *Service) newChallenge(ceremony string, userID primitive.ObjectID) ([]byte, string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, "", err
+	}
+
+	pending := models.WebAuthnChallenge{
+		ID:        primitive.NewObjectID(),
+		Challenge: challenge,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(webauthn.ChallengeTTL),
+	}
+
+	if err := s.challenges.Create(context.Background(), pending); err != nil {
+		return nil, "", err
+	}
+
+	return challenge, pending.ID.Hex(), nil
+}
+
+// takeChallenge removes and returns an unexpired challenge, so each one can
+// only be answered once
+func (s *Service) takeChallenge(challengeID string, ceremony string) (models.WebAuthnChallenge, error) {
+	id, err := primitive.ObjectIDFromHex(challengeID)
+	if err != nil {
+		return models.WebAuthnChallenge{}, ErrChallengeNotFound
+	}
+
+	pending, err := s.challenges.Take(context.Background(), id, ceremony, time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.WebAuthnChallenge{}, ErrChallengeNotFound
+	}
+
+	return pending, err
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWebAuthnConfig(t *testing.T) {
+	t.Setenv("webauthnRPID", "")
+	t.Setenv("webauthnRPName", "")
+	t.Setenv("webauthnOrigins", "")
+	t.Setenv("appURL", "")
+
+	rp, err := WebAuthnConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.RPID != "localhost" || rp.RPName != "Wallet" || !reflect.DeepEqual(rp.Origins, []string{"http://localhost:8080"}) {
+		t.Errorf("unexpected defaults %+v", rp)
+	}
+
+	t.Setenv("appURL", "https://wallet.example.com/")
+	if rp, _ := WebAuthnConfig(); !reflect.DeepEqual(rp.Origins, []string{"https://wallet.example.com"}) {
+		t.Errorf("expected the app URL as origin, got %v", rp.Origins)
+	}
+
+	t.Setenv("webauthnRPID", "example.com")
+	t.Setenv("webauthnOrigins", "https://wallet.example.com, https://app.example.com/")
+	rp, _ = WebAuthnConfig()
+	if rp.RPID != "example.com" || !reflect.DeepEqual(rp.Origins, []string{"https://wallet.example.com", "https://app.example.com"}) {
+		t.Errorf("unexpected config %+v", rp)
+	}
+}
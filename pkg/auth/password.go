@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmptyPassword       = errors.New("password is required")
+	ErrUnknownPasswordHash = errors.New("unrecognised password hash")
+)
+
+// PasswordParams are the Argon2id parameters used for new password hashes
+type PasswordParams struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultPasswordParams follow the OWASP recommendation for Argon2id
+var DefaultPasswordParams = PasswordParams{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32}
+
+// Upper bounds on the parameters accepted from a stored hash, so a tampered
+// record cannot make a login allocate unbounded memory
+const (
+	maxPasswordMemory  = 1024 * 1024
+	maxPasswordTime    = 16
+	maxPasswordThreads = 16
+)
+
+// dummyHash is verified when a login names an unknown user, so the response
+// time does not reveal which emails are registered
+var dummyHash, _ = HashPassword("not a real password")
+
+// HashPassword hashes a password with Argon2id and returns it in the PHC
+// string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	return hashPasswordWith(password, DefaultPasswordParams)
+}
+
+func hashPasswordWith(password string, params PasswordParams) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+
+	salt := make([]byte, params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword checks a password against an Argon2id or bcrypt hash.
+// needsRehash is true when the password matches but the hash is bcrypt or
+// uses parameters other than DefaultPasswordParams.
+func VerifyPassword(hash string, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+
+		params.SaltLen = uint32(len(salt))
+		params.KeyLen = uint32(len(key))
+		return true, params != DefaultPasswordParams, nil
+
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	return false, false, ErrUnknownPasswordHash
+}
+
+// IsPasswordHash reports whether a stored password is an Argon2id or bcrypt
+// hash rather than a legacy plaintext password
+func IsPasswordHash(value string) bool {
+	if strings.HasPrefix(value, "$argon2id$") {
+		_, _, _, err := decodeArgon2id(value)
+		return err == nil
+	}
+
+	return isBcryptHash(value)
+}
+
+// isBcryptHash reports whether a value is a well formed bcrypt hash
+func isBcryptHash(value string) bool {
+	if !strings.HasPrefix(value, "$2a$") && !strings.HasPrefix(value, "$2b$") && !strings.HasPrefix(value, "$2y$") {
+		return false
+	}
+
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil && len(value) == 60
+}
+
+// decodeArgon2id parses a PHC formatted Argon2id hash
+func decodeArgon2id(hash string) (PasswordParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return PasswordParams{}, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return PasswordParams{}, nil, nil, fmt.Errorf("%w: unsupported argon2 version", ErrUnknownPasswordHash)
+	}
+
+	var params PasswordParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return PasswordParams{}, nil, nil, fmt.Errorf("%w: invalid parameters", ErrUnknownPasswordHash)
+	}
+	if params.Memory == 0 || params.Memory > maxPasswordMemory ||
+		params.Time == 0 || params.Time > maxPasswordTime ||
+		params.Threads == 0 || params.Threads > maxPasswordThreads {
+		return PasswordParams{}, nil, nil, fmt.Errorf("%w: parameters out of range", ErrUnknownPasswordHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < 8 {
+		return PasswordParams{}, nil, nil, fmt.Errorf("%w: invalid salt", ErrUnknownPasswordHash)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 16 {
+		return PasswordParams{}, nil, nil, fmt.Errorf("%w: invalid hash", ErrUnknownPasswordHash)
+	}
+
+	return params, salt, key, nil
+}
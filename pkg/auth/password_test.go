@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+
+	ok, needsRehash, err := VerifyPassword(hash, "correct horse battery staple")
+	if err != nil || !ok || needsRehash {
+		t.Errorf("expected a match without rehash, got ok=%v rehash=%v err=%v", ok, needsRehash, err)
+	}
+
+	ok, _, err = VerifyPassword(hash, "wrong password")
+	if err != nil || ok {
+		t.Errorf("expected a mismatch, got ok=%v err=%v", ok, err)
+	}
+
+	other, _ := HashPassword("correct horse battery staple")
+	if other == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestVerifyPasswordRequestsRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt failed: %v", err)
+	}
+
+	ok, needsRehash, err := VerifyPassword(string(legacy), "secret")
+	if err != nil || !ok || !needsRehash {
+		t.Errorf("bcrypt hash: got ok=%v rehash=%v err=%v", ok, needsRehash, err)
+	}
+
+	weak := DefaultPasswordParams
+	weak.Time = 1
+	hash, err := hashPasswordWith("secret", weak)
+	if err != nil {
+		t.Fatalf("hashPasswordWith failed: %v", err)
+	}
+
+	ok, needsRehash, err = VerifyPassword(hash, "secret")
+	if err != nil || !ok || !needsRehash {
+		t.Errorf("outdated argon2id hash: got ok=%v rehash=%v err=%v", ok, needsRehash, err)
+	}
+}
+
+func TestIsPasswordHash(t *testing.T) {
+	hash, _ := HashPassword("secret")
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	tests := map[string]bool{
+		hash:                   true,
+		string(legacy):         true,
+		"secret":               false,
+		"$2a$10$tooShort":      false,
+		"$argon2id$v=19$bogus": false,
+		"$argon2id$v=19$m=99999999,t=3,p=2$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNoaGFzaA": false,
+	}
+	for value, want := range tests {
+		if got := IsPasswordHash(value); got != want {
+			t.Errorf("IsPasswordHash(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	if _, _, err := VerifyPassword("secret", "secret"); err == nil {
+		t.Error("plaintext stored password must not verify")
+	}
+	if _, err := HashPassword(""); err != ErrEmptyPassword {
+		t.Errorf("expected ErrEmptyPassword, got %v", err)
+	}
+}
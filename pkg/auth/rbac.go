@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+
+	"wallet/pkg/models"
+)
+
+// Permission is an action on a kind of resource
+type Permission string
+
+const (
+	PermReadUsers       Permission = "users:read"
+	PermCreateUsers     Permission = "users:create"
+	PermUpdateUsers     Permission = "users:update"
+	PermDeleteUsers     Permission = "users:delete"
+	PermManageRoles     Permission = "users:roles"
+	PermRefreshBalances Permission = "balances:refresh"
+	PermReadDeposits    Permission = "deposits:read"
+)
+
+// Scope is the set of resources a permission is granted on
+type Scope int
+
+const (
+	// ScopeNone denies the permission
+	ScopeNone Scope = iota
+	// ScopeOwn grants the permission on the user's own records only
+	ScopeOwn
+	// ScopeAny grants the permission on every user's records
+	ScopeAny
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+// rolePermissions lists what each role may do. Permissions missing from a
+// role are denied.
+var rolePermissions = map[string]map[Permission]Scope{
+	models.RoleUser: {
+		PermReadUsers:       ScopeOwn,
+		PermUpdateUsers:     ScopeOwn,
+		PermDeleteUsers:     ScopeOwn,
+		PermRefreshBalances: ScopeOwn,
+		PermReadDeposits:    ScopeOwn,
+	},
+	models.RoleSupport: {
+		PermReadUsers:       ScopeAny,
+		PermUpdateUsers:     ScopeOwn,
+		PermDeleteUsers:     ScopeOwn,
+		PermRefreshBalances: ScopeAny,
+		PermReadDeposits:    ScopeAny,
+	},
+	models.RoleAdmin: {
+		PermReadUsers:       ScopeAny,
+		PermCreateUsers:     ScopeAny,
+		PermUpdateUsers:     ScopeAny,
+		PermDeleteUsers:     ScopeAny,
+		PermManageRoles:     ScopeAny,
+		PermRefreshBalances: ScopeAny,
+		PermReadDeposits:    ScopeAny,
+	},
+}
+
+// PermissionScope returns the scope a role holds a permission on. An empty
+// role is RoleUser, unknown roles hold nothing.
+func PermissionScope(role string, permission Permission) Scope {
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	return rolePermissions[role][permission]
+}
+
+// ValidRole reports whether role is one of the defined roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// UserRole returns the role of a user, RoleUser if none was assigned
+func UserRole(userData models.User) string {
+	if userData.Role == "" {
+		return models.RoleUser
+	}
+
+	return userData.Role
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"testing"
+
+	"wallet/pkg/models"
+)
+
+func TestPermissionScope(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission Permission
+		want       Scope
+	}{
+		{models.RoleUser, PermReadUsers, ScopeOwn},
+		{"", PermUpdateUsers, ScopeOwn},
+		{models.RoleUser, PermCreateUsers, ScopeNone},
+		{models.RoleUser, PermManageRoles, ScopeNone},
+		{models.RoleSupport, PermReadUsers, ScopeAny},
+		{models.RoleSupport, PermUpdateUsers, ScopeOwn},
+		{models.RoleSupport, PermManageRoles, ScopeNone},
+		{models.RoleAdmin, PermDeleteUsers, ScopeAny},
+		{models.RoleAdmin, PermManageRoles, ScopeAny},
+		{"superuser", PermReadUsers, ScopeNone},
+	}
+
+	for _, test := range tests {
+		if got := PermissionScope(test.role, test.permission); got != test.want {
+			t.Errorf("PermissionScope(%q, %s) = %d, want %d", test.role, test.permission, got, test.want)
+		}
+	}
+}
+
+func TestRoleInClaims(t *testing.T) {
+	useKeySet(t, "ed25519")
+
+	token, err := GenerateJWT(sessionClaims("alice@example.com", UserRole(models.User{Role: models.RoleSupport}), testSession()))
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	claims, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT failed: %v", err)
+	}
+	if claims.Role != models.RoleSupport {
+		t.Errorf("expected role %q, got %q", models.RoleSupport, claims.Role)
+	}
+
+	if role := UserRole(models.User{}); role != models.RoleUser {
+		t.Errorf("expected users without a role to be %q, got %q", models.RoleUser, role)
+	}
+}
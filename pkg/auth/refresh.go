@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"wallet/pkg/models"
+	"wallet/pkg/repository"
+)
+
+// RefreshTokenTTL is the lifetime of a session. Refreshing does not extend it.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var ErrRefreshTokenReused = errors.New("refresh token was already used, session revoked")
+
+// TokenPair is an access token together with the refresh token that renews it
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// storeRefreshToken issues a refresh token for a session and stores its hash
+func (s *Service) storeRefreshToken(session models.Token) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.refreshTokens.Create(context.Background(), models.RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		Hash:      hashRefreshToken(token),
+		ExpiresAt: session.RefreshExpiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useRefreshToken marks a refresh token as used and returns it. A token that
+// was used before is returned with ErrRefreshTokenReused so its session can
+// be revoked.
+func (s *Service) useRefreshToken(ctx context.Context, token string) (models.RefreshToken, error) {
+	hash := hashRefreshToken(token)
+
+	// Claiming the token in a single update makes concurrent uses detectable
+	record, err := s.refreshTokens.Claim(ctx, hash, time.Now())
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return models.RefreshToken{}, err
+	}
+
+	record, err = s.refreshTokens.FindByHash(ctx, hash)
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.RefreshToken{}, ErrInvalidToken
+	}
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	if record.Used {
+		return record, ErrRefreshTokenReused
+	}
+
+	// Expired
+	return models.RefreshToken{}, ErrInvalidToken
+}
+
+// newRefreshToken returns a random opaque refresh token
+func newRefreshToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashRefreshToken returns the hex SHA-256 hash under which a refresh token
+// is stored
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/models"
+	"wallet/pkg/repository"
+)
+
+// newTestService returns a Service on in-memory repositories holding one
+// active user with password "correct horse"
+func newTestService(t *testing.T) (*Service, models.User) {
+	t.Helper()
+	useKeySet(t, "ed25519")
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	userData := models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: hash, Active: true}
+
+	repos := repository.NewMemory()
+	if err := repos.Users.Create(context.Background(), userData); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	return NewService(repos), userData
+}
+
+func TestLoginRefreshRevoke(t *testing.T) {
+	s, userData := newTestService(t)
+
+	if _, err := s.UserLogin(userData.Email, "wrong", SessionMeta{}); err == nil {
+		t.Fatal("expected a wrong password to be refused")
+	}
+
+	login, err := s.UserLogin(userData.Email, "correct horse", SessionMeta{Device: "laptop"})
+	if err != nil {
+		t.Fatalf("UserLogin failed: %v", err)
+	}
+	if login.TokenPair == nil || login.MFARequired {
+		t.Fatalf("expected session tokens, got %+v", login)
+	}
+
+	_, session, err := s.ValidateToken(login.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if session.UserID != userData.ID || session.Device != "laptop" {
+		t.Errorf("unexpected session %+v", session)
+	}
+
+	refreshed, err := s.ValidateAndRefreshToken(login.RefreshToken)
+	if err != nil {
+		t.Fatalf("ValidateAndRefreshToken failed: %v", err)
+	}
+	if _, _, err := s.ValidateToken(login.AccessToken); err == nil {
+		t.Error("expected the access token replaced by a refresh to be refused")
+	}
+	if _, _, err := s.ValidateToken(refreshed.AccessToken); err != nil {
+		t.Errorf("ValidateToken of the refreshed token failed: %v", err)
+	}
+
+	// Presenting a used refresh token again revokes the session
+	if _, err := s.ValidateAndRefreshToken(login.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, _, err := s.ValidateToken(refreshed.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("expected ErrSessionRevoked after reuse, got %v", err)
+	}
+
+	sessions, err := s.ListSessions(userData.ID)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("expected no active sessions, got %v, %v", sessions, err)
+	}
+}
+
+func TestLoginIgnoresEmailCase(t *testing.T) {
+	// Stored emails are lowercase, as the normalize-emails migration leaves
+	// them, while users keep typing the spelling they registered with
+	s, _ := newTestService(t)
+
+	for _, email := range []string{"Alice@Example.com", " ALICE@example.com "} {
+		if _, err := s.UserLogin(email, "correct horse", SessionMeta{}); err != nil {
+			t.Errorf("UserLogin(%q) failed: %v", email, err)
+		}
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	s, userData := newTestService(t)
+
+	first, _ := s.UserLogin(userData.Email, "correct horse", SessionMeta{})
+	second, _ := s.UserLogin(userData.Email, "correct horse", SessionMeta{})
+	_, keep, err := s.ValidateToken(second.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+
+	revoked, err := s.RevokeOtherSessions(userData.ID, keep.ID)
+	if err != nil || revoked != 1 {
+		t.Fatalf("expected one session revoked, got %d, %v", revoked, err)
+	}
+	if _, _, err := s.ValidateToken(first.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("expected ErrSessionRevoked, got %v", err)
+	}
+	if err := s.RevokeSession(userData.ID, keep.ID); err != nil {
+		t.Errorf("RevokeSession failed: %v", err)
+	}
+	if err := s.RevokeSession(userData.ID, keep.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for a revoked session, got %v", err)
+	}
+}
+
+func TestStepUpPasswordLocks(t *testing.T) {
+	s, userData := newTestService(t)
+
+	login, err := s.UserLogin(userData.Email, "correct horse", SessionMeta{})
+	if err != nil {
+		t.Fatalf("UserLogin failed: %v", err)
+	}
+	_, session, err := s.ValidateToken(login.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+
+	for i := 0; i < maxMFAFailures; i++ {
+		if err := s.StepUp(session, "", "wrong password"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: expected ErrInvalidCode, got %v", i+1, err)
+		}
+	}
+	if err := s.StepUp(session, "", "correct horse"); !errors.Is(err, ErrMFALocked) {
+		t.Errorf("expected ErrMFALocked after %d wrong passwords, got %v", maxMFAFailures, err)
+	}
+}
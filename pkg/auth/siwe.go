@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	config "wallet/pkg/config"
+	"wallet/pkg/models"
+	"wallet/pkg/repository"
+	"wallet/pkg/siwe"
+)
+
+// SIWENonceTTL is how long a Sign-In with Ethereum nonce can be used
+const SIWENonceTTL = 10 * time.Minute
+
+var (
+	ErrInvalidNonce    = errors.New("unknown or expired SIWE nonce")
+	ErrUnknownAddress  = errors.New("no user is linked to this Ethereum address")
+	ErrAddressLinked   = errors.New("Ethereum address is already linked to a user")
+	ErrAddressNotFound = errors.New("Ethereum address is not linked")
+)
+
+// ChainCaller returns the RPC client used to verify EIP-1271 signatures on a
+// chain
+type ChainCaller func(chainID int64) (siwe.ContractCaller, error)
+
+// SIWEDomain returns the domain SIWE messages must be issued for: the
+// configured domain, or the host of the app URL (default "localhost:8080")
+func SIWEDomain() (string, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return "", err
+	}
+	if cfg.SIWE.Domain != "" {
+		return cfg.SIWE.Domain, nil
+	}
+	if appURL, err := url.Parse(cfg.AppURL); err == nil && appURL.Host != "" {
+		return appURL.Host, nil
+	}
+	return "localhost:8080", nil
+}
+
+// NewSIWENonce issues a nonce for one SIWE message
+func (s *Service) NewSIWENonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	nonce := models.SIWENonce{
+		ID:        primitive.NewObjectID(),
+		Nonce:     hex.EncodeToString(buf),
+		ExpiresAt: time.Now().Add(SIWENonceTTL),
+	}
+
+	if err := s.nonces.Create(context.Background(), nonce); err != nil {
+		return "", err
+	}
+
+	return nonce.Nonce, nil
+}
+
+// SIWELogin signs in the user owning the address that signed an EIP-4361
+// message. The address can be one of the user's wallet accounts or an
+// address they linked. Users with two-factor authentication get an MFA
+// token, as with a password login.
+func (s *Service) SIWELogin(text string, signature []byte, chainIDs []int64, callers ChainCaller, meta SessionMeta) (LoginResult, error) {
+	message, err := s.verifySIWE(text, signature, chainIDs, callers)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	userData, err := activeUser(s.users.FindByAddress(context.Background(), message.Address.Hex()))
+	if errors.Is(err, ErrInvalidToken) {
+		return LoginResult{}, ErrUnknownAddress
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	if userData.TOTP != nil && userData.TOTP.Enabled {
+		mfaToken, err := newMFAToken(userData.ID)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	tokens, _, err := s.GenerateAndStoreToken(userData, meta)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{TokenPair: &tokens}, nil
+}
+
+// LinkEthereumAddress links the address that signed an EIP-4361 message to
+// the user, so it can be used to sign in
+func (s *Service) LinkEthereumAddress(userData models.User, text string, signature []byte, chainIDs []int64, callers ChainCaller) (common.Address, error) {
+	message, err := s.verifySIWE(text, signature, chainIDs, callers)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	err = s.users.LinkAddress(context.Background(), userData.ID, message.Address.Hex())
+	if errors.Is(err, repository.ErrDuplicate) {
+		return common.Address{}, ErrAddressLinked
+	}
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return message.Address, nil
+}
+
+// UnlinkEthereumAddress removes an address linked by the user
+func (s *Service) UnlinkEthereumAddress(userID primitive.ObjectID, address common.Address) error {
+	err := s.users.UnlinkAddress(context.Background(), userID, address.Hex())
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrAddressNotFound
+	}
+
+	return err
+}
+
+// verifySIWE parses and validates a message, spends its nonce and checks
+// the signature
+func (s *Service) verifySIWE(text string, signature []byte, chainIDs []int64, callers ChainCaller) (siwe.Message, error) {
+	message, err := siwe.ParseMessage(text)
+	if err != nil {
+		return siwe.Message{}, err
+	}
+
+	domain, err := SIWEDomain()
+	if err != nil {
+		return siwe.Message{}, err
+	}
+	if err := message.Validate(siwe.Options{Domain: domain, ChainIDs: chainIDs}); err != nil {
+		return siwe.Message{}, err
+	}
+
+	// The nonce is spent before the signature is checked, so a message can
+	// only be tried once
+	_, err = s.nonces.Take(context.Background(), message.Nonce, time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return siwe.Message{}, ErrInvalidNonce
+	}
+	if err != nil {
+		return siwe.Message{}, err
+	}
+
+	// Without an RPC client only account key signatures can be checked
+	caller, err := callers(message.ChainID)
+	if err != nil {
+		log.Printf("EIP-1271 verification unavailable on chain %d: %v", message.ChainID, err)
+		caller = nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := siwe.VerifySignature(ctx, caller, message.Address, text, signature); err != nil {
+		return siwe.Message{}, err
+	}
+
+	return message, nil
+}
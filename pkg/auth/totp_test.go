@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/models"
+)
+
+// rfc6238Secret is the SHA-1 test key of RFC 6238 appendix B, base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("T=%d: got %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret failed: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	step := TOTPStep(now)
+	code, _ := TOTPCode(secret, step)
+
+	got, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok || got != step {
+		t.Fatalf("current code rejected")
+	}
+
+	// One step of clock drift is tolerated, two are not
+	if _, ok := ValidateTOTP(secret, code, now.Add(30*time.Second), 0); !ok {
+		t.Error("code from the previous step rejected")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(90*time.Second), 0); ok {
+		t.Error("code from three steps ago accepted")
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("replayed code accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "000000", now, 0); ok && code != "000000" {
+		t.Error("wrong code accepted")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("JBSWY3DPEHPK3PXP", "alice@example.com")
+
+	for _, want := range []string{"otpauth://totp/Wallet:alice@example.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=Wallet", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI %q is missing %q", uri, want)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes", recoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("unexpected recovery code %q", code)
+		}
+		seen[code] = true
+
+		// Codes are accepted without the dash and in any case
+		if hashRecoveryCode(strings.ToUpper(strings.Replace(code, "-", "", 1))) != hashes[i] {
+			t.Errorf("normalised code %q does not match its hash", code)
+		}
+	}
+
+	if hashRecoveryCode("123456") != "" {
+		t.Error("a TOTP code must not be treated as a recovery code")
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	useKeySet(t, "ed25519")
+	userID := primitive.NewObjectID()
+
+	mfaToken, err := newMFAToken(userID)
+	if err != nil {
+		t.Fatalf("newMFAToken failed: %v", err)
+	}
+
+	got, err := parseMFAToken(mfaToken)
+	if err != nil || got != userID {
+		t.Fatalf("parseMFAToken failed: %v", err)
+	}
+
+	if _, err := ParseJWT(mfaToken); err != ErrInvalidToken {
+		t.Errorf("pre-auth token accepted as an access token: %v", err)
+	}
+
+	access, _ := GenerateJWT(sessionClaims("alice@example.com", models.RoleUser, testSession()))
+	if _, err := parseMFAToken(access); err != ErrInvalidToken {
+		t.Errorf("access token accepted as a pre-auth token: %v", err)
+	}
+}
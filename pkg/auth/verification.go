@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VerificationTTL is how long an email verification link stays valid
+const VerificationTTL = 24 * time.Hour
+
+// verificationAudience keeps verification tokens from being accepted as
+// access tokens and the other way round
+const verificationAudience = "email-verification"
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+// VerificationClaims identify the user and the email address being verified
+type VerificationClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// GenerateVerificationToken signs a token confirming that userID owns email
+func GenerateVerificationToken(userID primitive.ObjectID, email string, ttl time.Duration) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &VerificationClaims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID.Hex(),
+			Audience:  verificationAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseVerificationToken checks a verification token and returns the user
+// and email it was issued for
+func ParseVerificationToken(tokenString string) (primitive.ObjectID, string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+
+	claims := &VerificationClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(verificationAudience, true) {
+		return primitive.NilObjectID, "", ErrInvalidVerificationToken
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil || claims.Email == "" {
+		return primitive.NilObjectID, "", ErrInvalidVerificationToken
+	}
+
+	return userID, claims.Email, nil
+}
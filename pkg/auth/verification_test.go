@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVerificationTokenRoundTrip(t *testing.T) {
+	t.Setenv("jwtSecret", "test-secret")
+
+	userID := primitive.NewObjectID()
+	token, err := GenerateVerificationToken(userID, "alice@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateVerificationToken failed: %v", err)
+	}
+
+	gotID, email, err := ParseVerificationToken(token)
+	if err != nil {
+		t.Fatalf("ParseVerificationToken failed: %v", err)
+	}
+	if gotID != userID || email != "alice@example.com" {
+		t.Errorf("unexpected claims %s %s", gotID.Hex(), email)
+	}
+}
+
+func TestVerificationTokenRejected(t *testing.T) {
+	t.Setenv("jwtSecret", "test-secret")
+
+	expired, _ := GenerateVerificationToken(primitive.NewObjectID(), "alice@example.com", -time.Minute)
+	if _, _, err := ParseVerificationToken(expired); err != ErrInvalidVerificationToken {
+		t.Errorf("expired token: expected ErrInvalidVerificationToken, got %v", err)
+	}
+
+	valid, _ := GenerateVerificationToken(primitive.NewObjectID(), "alice@example.com", time.Hour)
+	if _, _, err := ParseVerificationToken(valid[:len(valid)-2] + "xx"); err != ErrInvalidVerificationToken {
+		t.Errorf("tampered token: expected ErrInvalidVerificationToken, got %v", err)
+	}
+
+	t.Setenv("jwtSecret", "another-secret")
+	if _, _, err := ParseVerificationToken(valid); err != ErrInvalidVerificationToken {
+		t.Errorf("token signed with another secret: expected ErrInvalidVerificationToken, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package chains
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	config "wallet/pkg/config"
+	models "wallet/pkg/models"
+)
+
+var (
+	ErrUnknownChain = errors.New("unknown chain")
+	ErrNoRPCURL     = errors.New("no RPC URL configured for chain")
+)
+
+// defaultChains is used when no chains file is configured. RPC URLs are left
+// empty on purpose: they are deployment specific.
+var defaultChains = []models.Chain{
+	{ID: 1, Name: "mainnet", NativeSymbol: "ETH", NativeDecimals: 18, Explorer: "https://etherscan.io", Confirmations: 12, EIP1559: true},
+	{ID: 11155111, Name: "sepolia", NativeSymbol: "ETH", NativeDecimals: 18, Explorer: "https://sepolia.etherscan.io", Confirmations: 6, EIP1559: true},
+	{ID: 10, Name: "optimism", NativeSymbol: "ETH", NativeDecimals: 18, Explorer: "https://optimistic.etherscan.io", Confirmations: 10, EIP1559: true},
+	{ID: 42161, Name: "arbitrum", NativeSymbol: "ETH", NativeDecimals: 18, Explorer: "https://arbiscan.io", Confirmations: 10, EIP1559: true},
+	{ID: 8453, Name: "base", NativeSymbol: "ETH", NativeDecimals: 18, Explorer: "https://basescan.org", Confirmations: 10, EIP1559: true},
+}
+
+// Registry holds the chains the wallet can operate on, indexed by chain ID
+type Registry struct {
+	chains    map[int64]models.Chain
+	order     []int64
+	defaultID int64
+}
+
+// NewRegistry validates the chains and builds a registry
+func NewRegistry(chains []models.Chain, defaultID int64) (*Registry, error) {
+	r := &Registry{chains: map[int64]models.Chain{}, defaultID: defaultID}
+	names := map[string]bool{}
+
+	for _, chain := range chains {
+		if chain.ID <= 0 {
+			return nil, fmt.Errorf("chain %q: chain ID must be positive", chain.Name)
+		}
+		if chain.Name == "" {
+			return nil, fmt.Errorf("chain %d: name is required", chain.ID)
+		}
+		if chain.NativeSymbol == "" {
+			return nil, fmt.Errorf("chain %q: native symbol is required", chain.Name)
+		}
+		if _, ok := r.chains[chain.ID]; ok {
+			return nil, fmt.Errorf("chain %d is configured twice", chain.ID)
+		}
+		name := strings.ToLower(chain.Name)
+		if names[name] {
+			return nil, fmt.Errorf("chain name %q is configured twice", chain.Name)
+		}
+		for _, token := range chain.Tokens {
+			if token.Symbol == "" || !common.IsHexAddress(token.Address) {
+				return nil, fmt.Errorf("chain %q: invalid token %q", chain.Name, token.Symbol)
+			}
+		}
+		if err := checkPool(chain.Pool); err != nil {
+			return nil, fmt.Errorf("chain %q: %w", chain.Name, err)
+		}
+		if chain.NativeDecimals == 0 {
+			chain.NativeDecimals = 18
+		}
+
+		names[name] = true
+		r.chains[chain.ID] = chain
+		r.order = append(r.order, chain.ID)
+	}
+
+	if _, ok := r.chains[defaultID]; !ok {
+		return nil, fmt.Errorf("%w: default chain %d", ErrUnknownChain, defaultID)
+	}
+
+	return r, nil
+}
+
+// Load builds the registry from the configured chains file, or from the
+// built-in chains. The RPC URL and ERC-20 tokens settings apply to the
+// default chain, selected by ID or name (mainnet if unset). The pool
+// settings apply to every chain, except those its own entry overrides.
+func Load() (*Registry, error) {
+	all, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+	cfg := all.Chains
+
+	chains := append([]models.Chain(nil), defaultChains...)
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+
+		chains = nil
+		if err := json.Unmarshal(data, &chains); err != nil {
+			return nil, fmt.Errorf("invalid chains file %s: %w", cfg.File, err)
+		}
+	}
+
+	defaultID, err := findID(chains, cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range chains {
+		chains[i].Pool = poolSettings(cfg.Pool, chains[i].Pool)
+
+		if chains[i].ID != defaultID {
+			continue
+		}
+		if len(chains[i].RPCURLs) == 0 && cfg.RPCURL != "" {
+			chains[i].RPCURLs = []string{cfg.RPCURL}
+		}
+		if len(chains[i].Tokens) == 0 {
+			for _, token := range cfg.ERC20Tokens {
+				chains[i].Tokens = append(chains[i].Tokens, models.ChainToken{Symbol: token.ID, Address: token.Value})
+			}
+		}
+	}
+
+	return NewRegistry(chains, defaultID)
+}
+
+// poolSettings returns the pool settings of a chain: its own, with the
+// unset ones taken from the configured defaults
+func poolSettings(defaults config.RPCPoolConfig, own *models.ChainPool) *models.ChainPool {
+	pool := models.ChainPool{
+		MaxAttempts:      int(defaults.MaxAttempts),
+		RequestTimeout:   models.Duration(defaults.RequestTimeout),
+		RateLimit:        defaults.RateLimit,
+		Burst:            int(defaults.Burst),
+		MaxHeadLag:       defaults.MaxHeadLag,
+		FailureThreshold: int(defaults.FailureThreshold),
+		Cooldown:         models.Duration(defaults.Cooldown),
+		HealthInterval:   models.Duration(defaults.HealthInterval),
+	}
+	if own == nil {
+		return &pool
+	}
+
+	if own.MaxAttempts != 0 {
+		pool.MaxAttempts = own.MaxAttempts
+	}
+	if own.RequestTimeout != 0 {
+		pool.RequestTimeout = own.RequestTimeout
+	}
+	if own.RateLimit != 0 {
+		pool.RateLimit = own.RateLimit
+	}
+	if own.Burst != 0 {
+		pool.Burst = own.Burst
+	}
+	if own.MaxHeadLag != 0 {
+		pool.MaxHeadLag = own.MaxHeadLag
+	}
+	if own.FailureThreshold != 0 {
+		pool.FailureThreshold = own.FailureThreshold
+	}
+	if own.Cooldown != 0 {
+		pool.Cooldown = own.Cooldown
+	}
+	if own.HealthInterval != 0 {
+		pool.HealthInterval = own.HealthInterval
+	}
+
+	return &pool
+}
+
+// checkPool rejects negative pool settings. Zero ones take the defaults.
+func checkPool(pool *models.ChainPool) error {
+	switch {
+	case pool == nil:
+		return nil
+	case pool.MaxAttempts < 0, pool.Burst < 0, pool.FailureThreshold < 0:
+		return errors.New("pool max_attempts, burst and failure_threshold must not be negative")
+	case pool.RateLimit < 0:
+		return errors.New("pool rate_limit must not be negative")
+	case pool.RequestTimeout < 0, pool.Cooldown < 0, pool.HealthInterval < 0:
+		return errors.New("pool request_timeout, cooldown and health_interval must not be negative")
+	}
+
+	return nil
+}
+
+// Get returns the chain with the given ID
+func (r *Registry) Get(id int64) (models.Chain, error) {
+	chain, ok := r.chains[id]
+	if !ok {
+		return models.Chain{}, fmt.Errorf("%w: %d", ErrUnknownChain, id)
+	}
+
+	return chain, nil
+}
+
+// Resolve returns the chain named by an API parameter, which may be a chain
+// ID or a chain name. An empty parameter selects the default chain.
+func (r *Registry) Resolve(param string) (models.Chain, error) {
+	if param == "" {
+		return r.Default(), nil
+	}
+
+	id, err := findID(r.All(), param)
+	if err != nil {
+		return models.Chain{}, err
+	}
+
+	return r.chains[id], nil
+}
+
+// Default returns the default chain
+func (r *Registry) Default() models.Chain {
+	return r.chains[r.defaultID]
+}
+
+// All returns every chain in configuration order
+func (r *Registry) All() []models.Chain {
+	chains := make([]models.Chain, 0, len(r.order))
+	for _, id := range r.order {
+		chains = append(chains, r.chains[id])
+	}
+
+	return chains
+}
+
+// IDs returns the ID of every chain in configuration order
+func (r *Registry) IDs() []int64 {
+	return append([]int64(nil), r.order...)
+}
+
+// TxURL returns the block explorer page of a transaction, if the chain has one
+func TxURL(chain models.Chain, hash string) string {
+	if chain.Explorer == "" {
+		return ""
+	}
+
+	return strings.TrimRight(chain.Explorer, "/") + "/tx/" + hash
+}
+
+// findID returns the ID of the chain matching a chain ID or name. An empty
+// value selects mainnet, or the first chain if mainnet is not configured.
+func findID(chains []models.Chain, value string) (int64, error) {
+	if len(chains) == 0 {
+		return 0, errors.New("no chains configured")
+	}
+
+	if value == "" {
+		for _, chain := range chains {
+			if chain.ID == 1 {
+				return 1, nil
+			}
+		}
+		return chains[0].ID, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	for _, chain := range chains {
+		if (err == nil && chain.ID == id) || strings.EqualFold(chain.Name, value) {
+			return chain.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownChain, value)
+}
@@ -0,0 +1,89 @@
+package chains
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wallet/pkg/config/configtest"
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+)
+
+func TestResolve(t *testing.T) {
+	registry, err := NewRegistry(defaultChains, 1)
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+
+	for param, want := range map[string]int64{"": 1, "1": 1, "sepolia": 11155111, "Base": 8453, "42161": 42161} {
+		chain, err := registry.Resolve(param)
+		if err != nil {
+			t.Fatalf("Resolve(%q) failed: %v", param, err)
+		}
+		if chain.ID != want {
+			t.Errorf("Resolve(%q) = %d, want %d", param, chain.ID, want)
+		}
+	}
+
+	if _, err := registry.Resolve("goerli"); !errors.Is(err, ErrUnknownChain) {
+		t.Errorf("expected ErrUnknownChain, got %v", err)
+	}
+}
+
+func TestNewRegistryValidation(t *testing.T) {
+	invalid := [][]models.Chain{
+		{{ID: 1, Name: "mainnet", NativeSymbol: "ETH"}, {ID: 1, Name: "other", NativeSymbol: "ETH"}},
+		{{ID: 1, Name: "mainnet", NativeSymbol: "ETH"}, {ID: 2, Name: "Mainnet", NativeSymbol: "ETH"}},
+		{{ID: 1, Name: "mainnet"}},
+		{{ID: 1, Name: "mainnet", NativeSymbol: "ETH", Tokens: []models.ChainToken{{Symbol: "USDC", Address: "nope"}}}},
+		{{ID: 1, Name: "mainnet", NativeSymbol: "ETH", Pool: &models.ChainPool{Cooldown: models.Duration(-time.Second)}}},
+	}
+
+	for i, chains := range invalid {
+		if _, err := NewRegistry(chains, 1); err == nil {
+			t.Errorf("case %d: expected a validation error", i)
+		}
+	}
+
+	if _, err := NewRegistry(defaultChains, 5); !errors.Is(err, ErrUnknownChain) {
+		t.Errorf("expected ErrUnknownChain for a missing default, got %v", err)
+	}
+}
+
+func TestLoadPoolSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chains.json")
+	chains := `[
+		{"chain_id": 1, "name": "mainnet", "native_symbol": "ETH"},
+		{"chain_id": 10, "name": "optimism", "native_symbol": "ETH", "pool": {"rate_limit": 2.5, "cooldown": "1m"}}
+	]`
+	if err := os.WriteFile(file, []byte(chains), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := configtest.Config()
+	cfg.Chains.File = file
+	cfg.Chains.Pool.MaxAttempts = 4
+	configtest.Use(t, cfg)
+
+	registry, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	mainnet, _ := registry.Get(1)
+	if got := poolConfig(mainnet); got.MaxAttempts != 4 || got.RateLimit != ethereum.DefaultPoolConfig.RateLimit {
+		t.Errorf("expected the configured pool settings on mainnet, got %+v", got)
+	}
+
+	optimism, _ := registry.Get(10)
+	got := poolConfig(optimism)
+	if got.RateLimit != 2.5 || got.Cooldown != time.Minute {
+		t.Errorf("expected the chain to override the pool settings, got %+v", got)
+	}
+	if got.MaxAttempts != 4 || got.HealthInterval != ethereum.DefaultPoolConfig.HealthInterval {
+		t.Errorf("expected the settings the chain leaves unset to be configured, got %+v", got)
+	}
+}
@@ -0,0 +1,79 @@
+package chains
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+)
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[int64]*ethereum.Client{}
+	stopPools = map[int64]context.CancelFunc{}
+)
+
+// Client returns the shared RPC client of a chain, creating its endpoint pool
+// and starting its health checks on first use
+func Client(chain models.Chain) (*ethereum.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if client, ok := clients[chain.ID]; ok {
+		return client, nil
+	}
+
+	if len(chain.RPCURLs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoRPCURL, chain.Name)
+	}
+
+	pool, err := ethereum.NewPool(chain.RPCURLs, poolConfig(chain))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go pool.Run(ctx)
+
+	client := ethereum.NewClient(pool)
+	clients[chain.ID] = client
+	stopPools[chain.ID] = cancel
+
+	return client, nil
+}
+
+// poolConfig returns the pool settings of a chain. Chains not built by Load
+// have none and take ethereum.DefaultPoolConfig.
+func poolConfig(chain models.Chain) ethereum.PoolConfig {
+	if chain.Pool == nil {
+		return ethereum.PoolConfig{}
+	}
+
+	return ethereum.PoolConfig{
+		MaxAttempts:      chain.Pool.MaxAttempts,
+		RequestTimeout:   time.Duration(chain.Pool.RequestTimeout),
+		RateLimit:        chain.Pool.RateLimit,
+		Burst:            chain.Pool.Burst,
+		MaxHeadLag:       chain.Pool.MaxHeadLag,
+		FailureThreshold: chain.Pool.FailureThreshold,
+		Cooldown:         time.Duration(chain.Pool.Cooldown),
+		HealthInterval:   time.Duration(chain.Pool.HealthInterval),
+	}
+}
+
+// CloseClients stops the health checks and closes the connections of every
+// client created by Client
+func CloseClients() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	for id, client := range clients {
+		stopPools[id]()
+		client.Pool().Close()
+		delete(clients, id)
+		delete(stopPools, id)
+	}
+}
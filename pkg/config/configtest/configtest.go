@@ -0,0 +1,34 @@
+// Package configtest provides the configuration tests of other packages run
+// with, instead of reading it from the environment
+package configtest
+
+import (
+	"testing"
+
+	config "wallet/pkg/config"
+)
+
+// Config returns a valid configuration with a JWT secret, an ephemeral JWT
+// key, one master key and the built-in chains
+func Config() config.Config {
+	cfg := config.Default()
+	cfg.Database.URI = "mongodb://localhost:27017"
+	cfg.JWT.Secret = "test-secret"
+	cfg.JWT.EphemeralKey = true
+	cfg.Encryption.MasterKeys = config.Pairs{{ID: "k1", Value: "first master passphrase"}}
+	cfg.Encryption.ActiveMasterKey = "k1"
+
+	return cfg
+}
+
+// Use makes cfg the configuration until the end of the test
+func Use(t testing.TB, cfg config.Config) {
+	t.Helper()
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test configuration: %v", err)
+	}
+
+	config.Use(cfg)
+	t.Cleanup(config.Reset)
+}
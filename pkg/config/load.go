@@ -0,0 +1,356 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Pair is one "id:value" entry of a list setting
+type Pair struct {
+	ID    string
+	Value string
+}
+
+// Pairs is a list setting of "id:value" entries, such as key IDs and keys.
+// In the environment it is written "id:value,id:value". In a file it is a
+// list of "id:value" strings or a table of values by ID.
+type Pairs []Pair
+
+// Lookup returns the value of the entry with the given ID
+func (p Pairs) Lookup(id string) (string, bool) {
+	for _, pair := range p {
+		if pair.ID == id {
+			return pair.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// IDs returns the ID of every entry in order
+func (p Pairs) IDs() []string {
+	ids := make([]string, len(p))
+	for i, pair := range p {
+		ids[i] = pair.ID
+	}
+
+	return ids
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	pairsType    = reflect.TypeOf(Pairs(nil))
+)
+
+// loadFile applies the settings of a YAML or TOML file, chosen by its
+// extension, and returns the problems found
+func loadFile(cfg *Config, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("configFile: %v", err)}
+	}
+
+	settings := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return []string{fmt.Sprintf("configFile: unsupported format %q, expected .yaml, .yml or .toml", filepath.Ext(path))}
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("configFile: invalid %s: %v", path, err)}
+	}
+
+	return applyFile(reflect.ValueOf(cfg).Elem(), settings, "", path)
+}
+
+// applyFile sets the fields of section from the settings of a file,
+// reporting settings the section does not have
+func applyFile(section reflect.Value, settings map[string]any, prefix, path string) []string {
+	var problems []string
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := prefix + key
+		field, info, ok := fieldByKey(section, key)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s (%s): unknown setting", name, path))
+			continue
+		}
+
+		value := settings[key]
+		if isSection(field.Type()) {
+			nested, ok := value.(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s (%s): must be a table of settings", name, path))
+				continue
+			}
+			problems = append(problems, applyFile(field, nested, name+".", path)...)
+			continue
+		}
+
+		if err := setValue(field, info.Tag.Get("format"), value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", name, path, err))
+		}
+	}
+
+	return problems
+}
+
+// applyEnv sets every field with an env tag from its environment variable,
+// or from the file named by the variable with a _FILE suffix
+func applyEnv(cfg *Config) []string {
+	var problems []string
+
+	walk(reflect.ValueOf(cfg).Elem(), "", func(field reflect.Value, info reflect.StructField, name string) {
+		env := info.Tag.Get("env")
+		if env == "" {
+			return
+		}
+
+		value, source, err := lookupEnv(env)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", name, source, err))
+			return
+		}
+		if value == "" {
+			return
+		}
+
+		if err := setString(field, info.Tag.Get("format"), value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", name, source, err))
+		}
+	})
+
+	return problems
+}
+
+// lookupEnv returns the value of an environment variable, or the content of
+// the file named by <name>_FILE, and where the value came from. Empty
+// variables count as unset.
+func lookupEnv(name string) (value, source string, err error) {
+	value = os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+
+	switch {
+	case path == "":
+		return value, "env " + name, nil
+	case value != "":
+		return "", "env " + name, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+
+	source = fmt.Sprintf("file %s from %s_FILE", path, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", source, err
+	}
+
+	// Secret files usually end with a newline that is not part of the secret
+	value = strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", source, errors.New("file is empty")
+	}
+
+	return value, source, nil
+}
+
+// walk calls fn with every setting of section and its dotted name
+func walk(section reflect.Value, prefix string, fn func(field reflect.Value, info reflect.StructField, name string)) {
+	for i := 0; i < section.NumField(); i++ {
+		info := section.Type().Field(i)
+		name := prefix + info.Tag.Get("key")
+
+		if isSection(info.Type) {
+			walk(section.Field(i), name+".", fn)
+			continue
+		}
+		fn(section.Field(i), info, name)
+	}
+}
+
+// fieldByKey returns the field of section with the given key tag
+func fieldByKey(section reflect.Value, key string) (reflect.Value, reflect.StructField, bool) {
+	for i := 0; i < section.NumField(); i++ {
+		if info := section.Type().Field(i); info.Tag.Get("key") == key {
+			return section.Field(i), info, true
+		}
+	}
+
+	return reflect.Value{}, reflect.StructField{}, false
+}
+
+// isSection reports whether a field holds a group of settings
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+// setValue sets a field from a value decoded from a file. format describes
+// the entries of Pairs settings in errors.
+func setValue(field reflect.Value, format string, value any) error {
+	switch v := value.(type) {
+	case string:
+		return setString(field, format, v)
+	case bool:
+		return setString(field, format, strconv.FormatBool(v))
+	case int:
+		return setString(field, format, strconv.Itoa(v))
+	case int64:
+		return setString(field, format, strconv.FormatInt(v, 10))
+	case uint64:
+		return setString(field, format, strconv.FormatUint(v, 10))
+	case float64:
+		return setString(field, format, strconv.FormatFloat(v, 'f', -1, 64))
+	case []any:
+		return setList(field, format, v)
+	case map[string]any:
+		return setTable(field, v)
+	}
+
+	return fmt.Errorf("unsupported value %v", value)
+}
+
+// setList sets a list field from a list decoded from a file
+func setList(field reflect.Value, format string, values []any) error {
+	entries := make([]string, len(values))
+	for i, value := range values {
+		entry, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("entry %d must be a string", i+1)
+		}
+		entries[i] = entry
+	}
+
+	switch field.Type() {
+	case pairsType:
+		pairs, err := parsePairs(entries, format)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(pairs))
+	default:
+		if field.Kind() != reflect.Slice {
+			return errors.New("must be a single value, not a list")
+		}
+		field.Set(reflect.ValueOf(entries))
+	}
+
+	return nil
+}
+
+// setTable sets a Pairs field from a table of values by ID. IDs are sorted,
+// since tables have no order.
+func setTable(field reflect.Value, table map[string]any) error {
+	if field.Type() != pairsType {
+		return errors.New("must be a single value, not a table")
+	}
+
+	pairs := make(Pairs, 0, len(table))
+	for id, value := range table {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("entry %q must be a string", id)
+		}
+		pairs = append(pairs, Pair{ID: id, Value: s})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].ID < pairs[j].ID })
+
+	field.Set(reflect.ValueOf(pairs))
+	return nil
+}
+
+// setString parses value into a field. Lists are comma separated.
+func setString(field reflect.Value, format, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected a value such as 30s or 5m", value)
+		}
+		field.SetInt(int64(d))
+	case field.Type() == pairsType:
+		pairs, err := parsePairs(strings.Split(value, ","), format)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(pairs))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, expected true or false", value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q, expected a whole number of at least 0", value)
+		}
+		field.SetUint(n)
+	case field.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q, expected a number of at least 0", value)
+		}
+		field.SetFloat(n)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var entries []string
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+		field.Set(reflect.ValueOf(entries))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
+
+// parsePairs parses "id:value" entries, skipping blank ones. Values may
+// contain colons, IDs may not.
+func parsePairs(entries []string, format string) (Pairs, error) {
+	if format == "" {
+		format = "id:value"
+	}
+
+	var pairs Pairs
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, value, ok := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || value == "" {
+			// The entry may hold a secret, so only its position is reported
+			return nil, fmt.Errorf("entry %d is not of the form %s", len(pairs)+1, format)
+		}
+		if _, ok := pairs.Lookup(id); ok {
+			return nil, fmt.Errorf("%q is listed twice", id)
+		}
+
+		pairs = append(pairs, Pair{ID: id, Value: value})
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// redacted replaces the value of secret settings that are set
+const redacted = "[redacted]"
+
+// String lists every setting as path=value with secrets redacted, so the
+// configuration can be logged
+func (c Config) String() string {
+	return describe(c)
+}
+
+// GoString keeps %#v from printing secrets
+func (c Config) GoString() string {
+	return describe(c)
+}
+
+// String lists the settings with the URI redacted
+func (d DatabaseConfig) String() string {
+	return describe(d)
+}
+
+// String lists the settings with the secret redacted
+func (j JWTConfig) String() string {
+	return describe(j)
+}
+
+// String lists the settings with the keys redacted
+func (e EncryptionConfig) String() string {
+	return describe(e)
+}
+
+// String lists the settings with the RPC URL redacted
+func (c ChainsConfig) String() string {
+	return describe(c)
+}
+
+// String lists the settings with the mailer URL redacted
+func (m MailConfig) String() string {
+	return describe(m)
+}
+
+// describe formats the settings of section. Secret Pairs keep their IDs so
+// the log still shows which keys are configured.
+func describe(section any) string {
+	var settings []string
+	walk(reflect.ValueOf(section), "", func(field reflect.Value, info reflect.StructField, name string) {
+		secret := info.Tag.Get("secret") == "true"
+
+		var value string
+		switch v := field.Interface().(type) {
+		case Pairs:
+			entries := make([]string, len(v))
+			for i, pair := range v {
+				if secret {
+					entries[i] = pair.ID + ":" + redacted
+				} else {
+					entries[i] = pair.ID + ":" + pair.Value
+				}
+			}
+			value = "[" + strings.Join(entries, " ") + "]"
+		default:
+			value = fmt.Sprint(v)
+			if secret && !field.IsZero() {
+				value = redacted
+			}
+		}
+
+		settings = append(settings, name+"="+value)
+	})
+
+	return strings.Join(settings, " ")
+}
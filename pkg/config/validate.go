@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultMasterKey is the ID of the master key given as the legacy
+// encriptKey, and the active master key unless another is configured
+const DefaultMasterKey = "default"
+
+// minMasterKeyLength is the shortest master passphrase accepted
+const minMasterKeyLength = 16
+
+// Validate reports every setting the wallet cannot start with, naming each
+// by its path and environment variable
+func (c Config) Validate() error {
+	var problems []string
+	problem := func(path, format string, args ...any) {
+		problems = append(problems, settingName(path)+": "+fmt.Sprintf(format, args...))
+	}
+
+	if c.AppURL != "" {
+		if err := checkURL(c.AppURL, "http", "https"); err != nil {
+			problem("app_url", "%v", err)
+		}
+	}
+
+	if c.Server.Addr == "" {
+		problem("server.addr", "is required")
+	}
+	positive := map[string]time.Duration{
+		"server.read_timeout":         c.Server.ReadTimeout,
+		"server.write_timeout":        c.Server.WriteTimeout,
+		"server.idle_timeout":         c.Server.IdleTimeout,
+		"server.shutdown_timeout":     c.Server.ShutdownTimeout,
+		"database.max_conn_idle_time": c.Database.MaxConnIdleTime,
+		"database.connect_timeout":    c.Database.ConnectTimeout,
+		"database.timeout":            c.Database.Timeout,
+		"chains.pool.request_timeout": c.Chains.Pool.RequestTimeout,
+		"chains.pool.cooldown":        c.Chains.Pool.Cooldown,
+		"chains.pool.health_interval": c.Chains.Pool.HealthInterval,
+	}
+	for _, path := range sortedKeys(positive) {
+		if positive[path] <= 0 {
+			problem(path, "must be positive")
+		}
+	}
+
+	if c.Database.URI == "" {
+		problem("database.uri", "is required")
+	} else if err := checkURL(c.Database.URI, "mongodb", "mongodb+srv"); err != nil {
+		problem("database.uri", "%v", err)
+	}
+	if c.Database.Name == "" {
+		problem("database.name", "is required")
+	}
+	if c.Database.MinPoolSize > c.Database.MaxPoolSize {
+		problem("database.min_pool_size", "%d is larger than database.max_pool_size %d", c.Database.MinPoolSize, c.Database.MaxPoolSize)
+	}
+
+	if c.JWT.Secret == "" {
+		problem("jwt.secret", "is required")
+	}
+	if len(c.JWT.Keys) == 0 && !c.JWT.EphemeralKey {
+		problem("jwt.keys", "is required unless jwt.ephemeral_key is set")
+	}
+	if c.JWT.ActiveKey != "" {
+		if _, ok := c.JWT.Keys.Lookup(c.JWT.ActiveKey); !ok {
+			problem("jwt.active_key", "%q is not one of jwt.keys", c.JWT.ActiveKey)
+		}
+	}
+
+	masterKeys := c.Encryption.Keys()
+	if len(masterKeys) == 0 {
+		problem("encryption.master_keys", "is required")
+	} else if _, ok := masterKeys.Lookup(c.Encryption.ActiveKeyID()); !ok {
+		problem("encryption.active_master_key", "%q is not one of encryption.master_keys", c.Encryption.ActiveKeyID())
+	}
+	if _, ok := c.Encryption.MasterKeys.Lookup(DefaultMasterKey); ok && c.Encryption.LegacyKey != "" {
+		problem("encryption.legacy_key", "conflicts with master key %q", DefaultMasterKey)
+	}
+	for _, key := range masterKeys {
+		if len(key.Value) < minMasterKeyLength {
+			problem("encryption.master_keys", "key %q must be at least %d characters", key.ID, minMasterKeyLength)
+		}
+	}
+
+	for _, token := range c.Chains.ERC20Tokens {
+		if !common.IsHexAddress(token.Value) {
+			problem("chains.erc20_tokens", "token %q has invalid address %q", token.ID, token.Value)
+		}
+	}
+
+	counts := map[string]uint64{
+		"chains.pool.max_attempts":      c.Chains.Pool.MaxAttempts,
+		"chains.pool.burst":             c.Chains.Pool.Burst,
+		"chains.pool.failure_threshold": c.Chains.Pool.FailureThreshold,
+	}
+	for _, path := range sortedKeys(counts) {
+		if counts[path] == 0 {
+			problem(path, "must be at least 1")
+		}
+	}
+	if c.Chains.Pool.RateLimit <= 0 {
+		problem("chains.pool.rate_limit", "must be positive")
+	}
+
+	intervals := map[string]time.Duration{
+		"workers.balance_interval":        c.Workers.BalanceInterval,
+		"workers.deposit_interval":        c.Workers.DepositInterval,
+		"workers.session_expiry_interval": c.Workers.SessionExpiryInterval,
+	}
+	for _, path := range sortedKeys(intervals) {
+		if intervals[path] < 0 {
+			problem(path, "must not be negative")
+		}
+	}
+
+	if c.Mail.URL != "" {
+		// The URL may hold a password, so it is not quoted
+		if err := checkURL(c.Mail.URL, "smtp", "file"); err != nil {
+			problem("mail.url", "%v", err)
+		} else if u, _ := url.Parse(c.Mail.URL); u.Scheme == "smtp" && u.Port() == "" {
+			problem("mail.url", "smtp URL must include a port")
+		}
+	}
+
+	for _, origin := range c.WebAuthn.Origins {
+		if err := checkURL(origin, "http", "https"); err != nil {
+			problem("webauthn.origins", "%q: %v", origin, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	return nil
+}
+
+// Keys returns the master keys, with the legacy key as DefaultMasterKey
+func (e EncryptionConfig) Keys() Pairs {
+	keys := append(Pairs(nil), e.MasterKeys...)
+	if e.LegacyKey != "" {
+		keys = append(Pairs{{ID: DefaultMasterKey, Value: e.LegacyKey}}, keys...)
+	}
+
+	return keys
+}
+
+// ActiveKeyID returns the ID of the master key wrapping new data keys
+func (e EncryptionConfig) ActiveKeyID() string {
+	if e.ActiveMasterKey == "" {
+		return DefaultMasterKey
+	}
+
+	return e.ActiveMasterKey
+}
+
+// checkURL checks that value is an absolute URL with one of the schemes,
+// without repeating the URL, which may hold credentials
+func checkURL(value string, schemes ...string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("URL must start with %s://", strings.Join(schemes, ":// or "))
+}
+
+// settingName names a setting by its path and environment variable, such
+// as "database.uri (mongoURI)"
+func settingName(path string) string {
+	name := path
+	walk(reflect.ValueOf(&Config{}).Elem(), "", func(_ reflect.Value, info reflect.StructField, p string) {
+		if env := info.Tag.Get("env"); p == path && env != "" {
+			name = fmt.Sprintf("%s (%s)", path, env)
+		}
+	})
+
+	return name
+}
+
+// sortedKeys returns the keys of m in order, so problems are reported in
+// the same order every time
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
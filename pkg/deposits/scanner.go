@@ -0,0 +1,368 @@
+package deposits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+)
+
+// transferTopic is keccak256("Transfer(address,address,uint256)")
+var transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// ErrReorgTooDeep is returned when a reorg goes past every block the scanner
+// remembers, so the fork point cannot be found
+var ErrReorgTooDeep = errors.New("chain reorganisation deeper than the scanner history")
+
+const (
+	// maxBlocksPerScan bounds the blocks processed by one Scan call
+	maxBlocksPerScan = 100
+	// minHistory is the minimum number of block hashes kept for reorg detection
+	minHistory = 64
+)
+
+// ChainReader is the part of an Ethereum client the scanner needs
+type ChainReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	FilterLogs(ctx context.Context, query geth.FilterQuery) ([]types.Log, error)
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+	CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Store persists the scanner cursor and the deposits it finds
+type Store interface {
+	// LoadCursor returns the cursor of a chain, or false if it never ran
+	LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error)
+	SaveCursor(ctx context.Context, cursor models.ScanCursor) error
+	// Owners maps every user wallet address to its user
+	Owners(ctx context.Context) (map[common.Address]primitive.ObjectID, error)
+	// SaveDeposit inserts a deposit, or updates the one with the same
+	// chain, transaction hash and log index
+	SaveDeposit(ctx context.Context, deposit models.Deposit) error
+	// RollBack marks the unconfirmed deposits of a chain at or above a block
+	// as reorged
+	RollBack(ctx context.Context, chainID int64, fromBlock uint64) error
+	// Confirm updates the confirmation count of the pending deposits of a
+	// chain and confirms those with at least the required number
+	Confirm(ctx context.Context, chainID int64, head uint64, required uint64) error
+}
+
+// Scanner follows the head of one chain and records deposits to user addresses
+type Scanner struct {
+	chain  models.Chain
+	reader ChainReader
+	store  Store
+	signer types.Signer
+	// decimals caches the decimals of the chain's tokens
+	decimals map[common.Address]uint8
+}
+
+// NewScanner creates a scanner for a chain
+func NewScanner(chain models.Chain, reader ChainReader, store Store) *Scanner {
+	return &Scanner{
+		chain:    chain,
+		reader:   reader,
+		store:    store,
+		signer:   types.LatestSignerForChainID(big.NewInt(chain.ID)),
+		decimals: map[common.Address]uint8{},
+	}
+}
+
+// Scan processes the blocks between the cursor and the chain head, up to
+// maxBlocksPerScan of them, and updates deposit confirmations. On its first
+// run the scanner starts at the current head. It returns the number of
+// blocks processed.
+func (s *Scanner) Scan(ctx context.Context) (int, error) {
+	head, err := s.reader.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, found, err := s.store.LoadCursor(ctx, s.chain.ID)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		cursor = models.ScanCursor{ChainID: s.chain.ID, NextBlock: head}
+	}
+
+	owners, err := s.store.Owners(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for cursor.NextBlock <= head && processed < maxBlocksPerScan {
+		block, err := s.reader.BlockByNumber(ctx, new(big.Int).SetUint64(cursor.NextBlock))
+		if err != nil {
+			return processed, err
+		}
+
+		if parent, ok := lastRef(cursor); ok && block.ParentHash().Hex() != parent.Hash {
+			if err := s.rollBack(ctx, &cursor); err != nil {
+				return processed, err
+			}
+			continue
+		}
+
+		if err := s.scanBlock(ctx, block, owners); err != nil {
+			return processed, err
+		}
+
+		cursor.Recent = append(cursor.Recent, models.BlockRef{Number: block.NumberU64(), Hash: block.Hash().Hex()})
+		if keep := s.history(); len(cursor.Recent) > keep {
+			cursor.Recent = cursor.Recent[len(cursor.Recent)-keep:]
+		}
+		cursor.NextBlock = block.NumberU64() + 1
+		cursor.UpdatedAt = time.Now()
+
+		if err := s.store.SaveCursor(ctx, cursor); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	return processed, s.store.Confirm(ctx, s.chain.ID, head, s.chain.Confirmations)
+}
+
+// rollBack finds the last remembered block still on the canonical chain,
+// rolls back the deposits after it and rewinds the cursor
+func (s *Scanner) rollBack(ctx context.Context, cursor *models.ScanCursor) error {
+	for len(cursor.Recent) > 0 {
+		ref := cursor.Recent[len(cursor.Recent)-1]
+
+		block, err := s.reader.BlockByNumber(ctx, new(big.Int).SetUint64(ref.Number))
+		if err != nil {
+			return err
+		}
+		if block.Hash().Hex() == ref.Hash {
+			break
+		}
+		cursor.Recent = cursor.Recent[:len(cursor.Recent)-1]
+	}
+
+	if len(cursor.Recent) == 0 {
+		return fmt.Errorf("%w on chain %d", ErrReorgTooDeep, s.chain.ID)
+	}
+
+	forkPoint := cursor.Recent[len(cursor.Recent)-1].Number
+	log.Printf("Deposit scanner detected a reorg on %s, rolling back to block %d", s.chain.Name, forkPoint)
+
+	if err := s.store.RollBack(ctx, s.chain.ID, forkPoint+1); err != nil {
+		return err
+	}
+
+	cursor.NextBlock = forkPoint + 1
+	cursor.UpdatedAt = time.Now()
+
+	return s.store.SaveCursor(ctx, *cursor)
+}
+
+// scanBlock records the native and token deposits of a block
+func (s *Scanner) scanBlock(ctx context.Context, block *types.Block, owners map[common.Address]primitive.ObjectID) error {
+	if len(owners) == 0 {
+		return nil
+	}
+
+	for _, tx := range block.Transactions() {
+		if tx.To() == nil || tx.Value().Sign() == 0 {
+			continue
+		}
+		userID, ok := owners[*tx.To()]
+		if !ok {
+			continue
+		}
+
+		// A reverted transaction does not move its value
+		receipt, err := s.reader.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+
+		from, err := types.Sender(s.signer, tx)
+		if err != nil {
+			return err
+		}
+
+		deposit := s.newDeposit(block, userID, *tx.To(), from, tx.Hash(), -1, tx.Value())
+		deposit.Symbol = s.chain.NativeSymbol
+		deposit.Decimals = s.chain.NativeDecimals
+		if err := s.store.SaveDeposit(ctx, deposit); err != nil {
+			return err
+		}
+	}
+
+	return s.scanTokenLogs(ctx, block, owners)
+}
+
+// scanTokenLogs records the Transfer logs of the chain's tokens to user
+// addresses. Tokens outside the chain configuration are ignored, so
+// unsolicited airdrops are not reported as deposits.
+func (s *Scanner) scanTokenLogs(ctx context.Context, block *types.Block, owners map[common.Address]primitive.ObjectID) error {
+	if len(s.chain.Tokens) == 0 {
+		return nil
+	}
+
+	symbols := make(map[common.Address]string, len(s.chain.Tokens))
+	addresses := make([]common.Address, 0, len(s.chain.Tokens))
+	for _, token := range s.chain.Tokens {
+		address := common.HexToAddress(token.Address)
+		symbols[address] = token.Symbol
+		addresses = append(addresses, address)
+	}
+
+	hash := block.Hash()
+	logs, err := s.reader.FilterLogs(ctx, geth.FilterQuery{
+		BlockHash: &hash,
+		Addresses: addresses,
+		Topics:    [][]common.Hash{{transferTopic}},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range logs {
+		if entry.Removed || len(entry.Topics) != 3 || len(entry.Data) != 32 {
+			continue
+		}
+
+		to := common.BytesToAddress(entry.Topics[2].Bytes())
+		userID, ok := owners[to]
+		if !ok {
+			continue
+		}
+
+		value := new(big.Int).SetBytes(entry.Data)
+		if value.Sign() == 0 {
+			continue
+		}
+
+		decimals, err := s.tokenDecimals(ctx, entry.Address, block.Number())
+		if err != nil {
+			return fmt.Errorf("failed to read decimals of %s: %w", symbols[entry.Address], err)
+		}
+
+		from := common.BytesToAddress(entry.Topics[1].Bytes())
+		deposit := s.newDeposit(block, userID, to, from, entry.TxHash, int(entry.Index), value)
+		deposit.Token = entry.Address.Hex()
+		deposit.Symbol = symbols[entry.Address]
+		deposit.Decimals = decimals
+		if err := s.store.SaveDeposit(ctx, deposit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tokenDecimals returns the decimals of a token, calling decimals() on the
+// contract the first time
+func (s *Scanner) tokenDecimals(ctx context.Context, token common.Address, blockNumber *big.Int) (uint8, error) {
+	if decimals, ok := s.decimals[token]; ok {
+		return decimals, nil
+	}
+
+	decimals, err := ethereum.TokenDecimals(ctx, s.reader, token, blockNumber)
+	if err != nil {
+		return 0, err
+	}
+	s.decimals[token] = decimals
+
+	return decimals, nil
+}
+
+// newDeposit builds a pending deposit found in a block
+func (s *Scanner) newDeposit(block *types.Block, userID primitive.ObjectID, to, from common.Address, txHash common.Hash, logIndex int, value *big.Int) models.Deposit {
+	now := time.Now()
+
+	return models.Deposit{
+		ChainID:     s.chain.ID,
+		UserID:      userID,
+		Address:     to.Hex(),
+		From:        from.Hex(),
+		TxHash:      txHash.Hex(),
+		LogIndex:    logIndex,
+		Amount:      models.NewAmount(value),
+		BlockNumber: block.NumberU64(),
+		BlockHash:   block.Hash().Hex(),
+		Status:      models.DepositStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// history returns the number of block hashes kept for reorg detection
+func (s *Scanner) history() int {
+	if n := int(s.chain.Confirmations) * 2; n > minHistory {
+		return n
+	}
+
+	return minHistory
+}
+
+// lastRef returns the last block the cursor scanned
+func lastRef(cursor models.ScanCursor) (models.BlockRef, bool) {
+	if len(cursor.Recent) == 0 {
+		return models.BlockRef{}, false
+	}
+
+	return cursor.Recent[len(cursor.Recent)-1], true
+}
+
+// Run scans every chain with an RPC URL on each tick, until ctx is cancelled
+func Run(ctx context.Context, registry *chains.Registry, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	scanners := map[int64]*Scanner{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, chain := range registry.All() {
+			if len(chain.RPCURLs) == 0 {
+				continue
+			}
+
+			scanner, ok := scanners[chain.ID]
+			if !ok {
+				client, err := chains.Client(chain)
+				if err != nil {
+					log.Printf("Deposit scanner cannot connect to %s: %v", chain.Name, err)
+					continue
+				}
+				scanner = NewScanner(chain, client, store)
+				scanners[chain.ID] = scanner
+			}
+
+			scanCtx, cancel := context.WithTimeout(ctx, interval*4)
+			if _, err := scanner.Scan(scanCtx); err != nil {
+				log.Printf("Deposit scanner failed on %s: %v", chain.Name, err)
+			}
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package deposits
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/params"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+)
+
+// memoryStore is an in-memory Store
+type memoryStore struct {
+	cursor   *models.ScanCursor
+	owners   map[common.Address]primitive.ObjectID
+	deposits map[string]models.Deposit
+}
+
+func newMemoryStore(owners map[common.Address]primitive.ObjectID) *memoryStore {
+	return &memoryStore{owners: owners, deposits: map[string]models.Deposit{}}
+}
+
+func (s *memoryStore) LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error) {
+	if s.cursor == nil {
+		return models.ScanCursor{}, false, nil
+	}
+	cursor := *s.cursor
+	cursor.Recent = append([]models.BlockRef(nil), s.cursor.Recent...)
+	return cursor, true, nil
+}
+
+func (s *memoryStore) SaveCursor(ctx context.Context, cursor models.ScanCursor) error {
+	s.cursor = &cursor
+	return nil
+}
+
+func (s *memoryStore) Owners(ctx context.Context) (map[common.Address]primitive.ObjectID, error) {
+	return s.owners, nil
+}
+
+func (s *memoryStore) SaveDeposit(ctx context.Context, deposit models.Deposit) error {
+	s.deposits[deposit.TxHash] = deposit
+	return nil
+}
+
+func (s *memoryStore) RollBack(ctx context.Context, chainID int64, fromBlock uint64) error {
+	for hash, deposit := range s.deposits {
+		if deposit.BlockNumber >= fromBlock && deposit.Status == models.DepositStatusPending {
+			deposit.Status = models.DepositStatusReorged
+			s.deposits[hash] = deposit
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Confirm(ctx context.Context, chainID int64, head uint64, required uint64) error {
+	for hash, deposit := range s.deposits {
+		if deposit.Status != models.DepositStatusPending {
+			continue
+		}
+		deposit.Confirmations = head - deposit.BlockNumber + 1
+		if deposit.Confirmations >= required {
+			deposit.Status = models.DepositStatusConfirmed
+		}
+		s.deposits[hash] = deposit
+	}
+	return nil
+}
+
+func newTestChain(t *testing.T) (*simulated.Backend, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Mul(big.NewInt(10), big.NewInt(params.Ether))},
+	})
+	t.Cleanup(func() { backend.Close() })
+
+	return backend, key
+}
+
+var testChain = models.Chain{ID: 1337, Name: "simulated", NativeSymbol: "ETH", NativeDecimals: 18, Confirmations: 3, EIP1559: true}
+
+func TestScannerRecordsAndConfirmsNativeDeposits(t *testing.T) {
+	backend, key := newTestChain(t)
+	client := backend.Client()
+	ctx := context.Background()
+
+	userID := primitive.NewObjectID()
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	store := newMemoryStore(map[common.Address]primitive.ObjectID{recipient: userID})
+	scanner := NewScanner(testChain, client, store)
+
+	// The first scan only positions the cursor at the head
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	// A transfer to an address nobody owns is ignored
+	if _, err := ethereum.SendTransfer(ctx, client, key, common.HexToAddress("0x00000000000000000000000000000000000000bb"), big.NewInt(1)); err != nil {
+		t.Fatalf("SendTransfer failed: %v", err)
+	}
+	backend.Commit()
+
+	tx, err := ethereum.SendTransfer(ctx, client, key, recipient, big.NewInt(params.Ether))
+	if err != nil {
+		t.Fatalf("SendTransfer failed: %v", err)
+	}
+	backend.Commit()
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(store.deposits) != 1 {
+		t.Fatalf("expected 1 deposit, got %d", len(store.deposits))
+	}
+
+	deposit := store.deposits[tx.Hash().Hex()]
+	if deposit.UserID != userID || deposit.Address != recipient.Hex() {
+		t.Errorf("deposit recorded for the wrong user: %+v", deposit)
+	}
+	if deposit.From != crypto.PubkeyToAddress(key.PublicKey).Hex() {
+		t.Errorf("unexpected sender %s", deposit.From)
+	}
+	if deposit.Amount.String() != "1000000000000000000" || deposit.LogIndex != -1 {
+		t.Errorf("unexpected amount %s or log index %d", deposit.Amount, deposit.LogIndex)
+	}
+	if deposit.Status != models.DepositStatusPending || deposit.Confirmations != 1 {
+		t.Errorf("expected a pending deposit with 1 confirmation, got %s with %d", deposit.Status, deposit.Confirmations)
+	}
+
+	backend.Commit()
+	backend.Commit()
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	deposit = store.deposits[tx.Hash().Hex()]
+	if deposit.Status != models.DepositStatusConfirmed || deposit.Confirmations != 3 {
+		t.Errorf("expected a confirmed deposit with 3 confirmations, got %s with %d", deposit.Status, deposit.Confirmations)
+	}
+}
+
+func TestScannerRollsBackReorgedDeposits(t *testing.T) {
+	backend, key := newTestChain(t)
+	client := backend.Client()
+	ctx := context.Background()
+
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	store := newMemoryStore(map[common.Address]primitive.ObjectID{recipient: primitive.NewObjectID()})
+	scanner := NewScanner(testChain, client, store)
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	fork, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatalf("HeaderByNumber failed: %v", err)
+	}
+
+	tx, err := ethereum.SendTransfer(ctx, client, key, recipient, big.NewInt(params.Ether))
+	if err != nil {
+		t.Fatalf("SendTransfer failed: %v", err)
+	}
+	backend.Commit()
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	original := store.deposits[tx.Hash().Hex()]
+	if original.Status != models.DepositStatusPending {
+		t.Fatalf("expected a pending deposit, got %q", original.Status)
+	}
+
+	// Replace the deposit block with a longer side chain
+	if err := backend.Fork(fork.Hash()); err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	backend.Commit()
+	backend.Commit()
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	// The reorged transaction is either dropped or mined again in a new block
+	deposit := store.deposits[tx.Hash().Hex()]
+	switch deposit.Status {
+	case models.DepositStatusReorged:
+	case models.DepositStatusPending:
+		if deposit.BlockHash == original.BlockHash {
+			t.Errorf("deposit still points at the orphaned block %s", deposit.BlockHash)
+		}
+	default:
+		t.Errorf("unexpected deposit status %q after reorg", deposit.Status)
+	}
+
+	head, _ := client.HeaderByNumber(ctx, nil)
+	if last, _ := lastRef(*store.cursor); last.Hash != head.Hash().Hex() {
+		t.Errorf("cursor did not follow the new head")
+	}
+}
+
+// tokenRuntime is a minimal token contract: decimals() returns 6 and any
+// other call emits Transfer(caller, to, value) for transfer(to, value)
+// calldata
+var tokenRuntime = common.FromHex(
+	"600035" + "60e01c" + "63313ce567" + "14" + "604057" + // jump to decimals() if selected
+		"602435" + "600052" + // store value as log data
+		"600435" + "33" + "7f" + transferTopic.Hex()[2:] + // topics: to, caller, Transfer
+		"60206000" + "a3" + "00" + // LOG3 and stop
+		"5b" + "6006600052" + "60206000f3", // decimals(): return 6
+)
+
+// deployToken deploys tokenRuntime and returns its address
+func deployToken(t *testing.T, backend *simulated.Backend, key *ecdsa.PrivateKey) common.Address {
+	t.Helper()
+
+	size := byte(len(tokenRuntime))
+	initCode := append([]byte{0x60, size, 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, size, 0x60, 0x00, 0xf3}, tokenRuntime...)
+
+	receipt := sendCall(t, backend, key, nil, initCode)
+	if receipt.ContractAddress == (common.Address{}) {
+		t.Fatal("token was not deployed")
+	}
+
+	return receipt.ContractAddress
+}
+
+// sendCall mines a transaction from key to a contract, or a contract
+// creation if to is nil, and returns its receipt
+func sendCall(t *testing.T, backend *simulated.Backend, key *ecdsa.PrivateKey, to *common.Address, data []byte) *types.Receipt {
+	t.Helper()
+
+	ctx := context.Background()
+	client := backend.Client()
+
+	nonce, err := client.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+	if err != nil {
+		t.Fatalf("PendingNonceAt failed: %v", err)
+	}
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(testChain.ID)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(testChain.ID),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(params.GWei),
+		GasFeeCap: big.NewInt(100 * params.GWei),
+		Gas:       200000,
+		To:        to,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatalf("SignNewTx failed: %v", err)
+	}
+	if err := client.SendTransaction(ctx, tx); err != nil {
+		t.Fatalf("SendTransaction failed: %v", err)
+	}
+	backend.Commit()
+
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		t.Fatalf("TransactionReceipt failed: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatal("transaction reverted")
+	}
+
+	return receipt
+}
+
+func TestScannerRecordsTokenDeposits(t *testing.T) {
+	backend, key := newTestChain(t)
+	client := backend.Client()
+	ctx := context.Background()
+
+	token := deployToken(t, backend, key)
+	chain := testChain
+	chain.Tokens = []models.ChainToken{{Symbol: "USDC", Address: token.Hex()}}
+
+	userID := primitive.NewObjectID()
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	store := newMemoryStore(map[common.Address]primitive.ObjectID{recipient: userID})
+	scanner := NewScanner(chain, client, store)
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	// transfer(recipient, 2.5 USDC)
+	data := append(common.FromHex("a9059cbb"), common.LeftPadBytes(recipient.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(2500000).Bytes(), 32)...)
+	receipt := sendCall(t, backend, key, &token, data)
+	if len(receipt.Logs) != 1 {
+		t.Fatalf("expected one Transfer log, got %d", len(receipt.Logs))
+	}
+
+	if _, err := scanner.Scan(ctx); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	deposit, ok := store.deposits[receipt.TxHash.Hex()]
+	if !ok {
+		t.Fatalf("expected a token deposit, got %v", store.deposits)
+	}
+	if deposit.UserID != userID || deposit.Token != token.Hex() || deposit.Symbol != "USDC" {
+		t.Errorf("unexpected deposit %+v", deposit)
+	}
+	if deposit.Decimals != 6 || deposit.Amount.String() != "2500000" || deposit.Amount.Format(deposit.Decimals) != "2.5" {
+		t.Errorf("expected 2.5 USDC with 6 decimals, got %s with %d decimals", deposit.Amount, deposit.Decimals)
+	}
+	if deposit.From != crypto.PubkeyToAddress(key.PublicKey).Hex() || deposit.LogIndex != 0 {
+		t.Errorf("unexpected sender %s or log index %d", deposit.From, deposit.LogIndex)
+	}
+}
@@ -0,0 +1,68 @@
+package deposits
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	models "wallet/pkg/models"
+	"wallet/pkg/repository"
+)
+
+// listLimit is the number of deposits ListDeposits returns
+const listLimit = 100
+
+// RepositoryStore is the Store keeping cursors and deposits in the deposit
+// repository, and taking wallet owners from the user repository
+type RepositoryStore struct {
+	deposits repository.DepositRepository
+	wallets  repository.WalletRepository
+}
+
+// NewRepositoryStore returns a RepositoryStore on repos
+func NewRepositoryStore(repos repository.Repositories) *RepositoryStore {
+	return &RepositoryStore{deposits: repos.Deposits, wallets: repos.Users}
+}
+
+func (s *RepositoryStore) LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error) {
+	return s.deposits.LoadCursor(ctx, chainID)
+}
+
+func (s *RepositoryStore) SaveCursor(ctx context.Context, cursor models.ScanCursor) error {
+	return s.deposits.SaveCursor(ctx, cursor)
+}
+
+func (s *RepositoryStore) Owners(ctx context.Context) (map[common.Address]primitive.ObjectID, error) {
+	wallets, err := s.wallets.WalletOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[common.Address]primitive.ObjectID{}
+	for address, userID := range wallets {
+		if common.IsHexAddress(address) {
+			owners[common.HexToAddress(address)] = userID
+		}
+	}
+
+	return owners, nil
+}
+
+func (s *RepositoryStore) SaveDeposit(ctx context.Context, deposit models.Deposit) error {
+	return s.deposits.SaveDeposit(ctx, deposit)
+}
+
+func (s *RepositoryStore) RollBack(ctx context.Context, chainID int64, fromBlock uint64) error {
+	return s.deposits.RollBack(ctx, chainID, fromBlock, time.Now())
+}
+
+func (s *RepositoryStore) Confirm(ctx context.Context, chainID int64, head uint64, required uint64) error {
+	return s.deposits.Confirm(ctx, chainID, head, required, time.Now())
+}
+
+// ListDeposits returns the most recent deposits of a user, newest first
+func (s *RepositoryStore) ListDeposits(ctx context.Context, userID primitive.ObjectID) ([]models.Deposit, error) {
+	return s.deposits.ListByUser(ctx, userID, listLimit)
+}
@@ -0,0 +1,63 @@
+package ethereum
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	decimalsSelector  = crypto.Keccak256([]byte("decimals()"))[:4]
+)
+
+var ErrInvalidTokenResponse = errors.New("unexpected ERC-20 call result")
+
+// BalanceReader is the subset of a JSON-RPC client needed to read balances
+type BalanceReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// NativeBalance returns the ETH balance in wei of account at blockNumber
+func NativeBalance(ctx context.Context, reader BalanceReader, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return reader.BalanceAt(ctx, account, blockNumber)
+}
+
+// TokenBalance calls balanceOf(account) on an ERC-20 contract at blockNumber
+func TokenBalance(ctx context.Context, reader geth.ContractCaller, token common.Address, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	data := append(append([]byte{}, balanceOfSelector...), common.LeftPadBytes(account.Bytes(), 32)...)
+
+	result, err := reader.CallContract(ctx, geth.CallMsg{To: &token, Data: data}, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 32 {
+		return nil, ErrInvalidTokenResponse
+	}
+
+	return new(big.Int).SetBytes(result), nil
+}
+
+// TokenDecimals calls decimals() on an ERC-20 contract at blockNumber
+func TokenDecimals(ctx context.Context, reader geth.ContractCaller, token common.Address, blockNumber *big.Int) (uint8, error) {
+	result, err := reader.CallContract(ctx, geth.CallMsg{To: &token, Data: decimalsSelector}, blockNumber)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) != 32 {
+		return 0, ErrInvalidTokenResponse
+	}
+
+	decimals := new(big.Int).SetBytes(result)
+	if !decimals.IsUint64() || decimals.Uint64() > 255 {
+		return 0, ErrInvalidTokenResponse
+	}
+
+	return uint8(decimals.Uint64()), nil
+}
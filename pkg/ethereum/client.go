@@ -0,0 +1,118 @@
+package ethereum
+
+import (
+	"context"
+	"math/big"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Client exposes the typed ethclient API over a Pool. Reads fail over to
+// other endpoints; transactions are broadcast once, on the best endpoint.
+// It satisfies TxBackend and BalanceReader.
+type Client struct {
+	pool *Pool
+}
+
+// NewClient wraps a pool
+func NewClient(pool *Pool) *Client {
+	return &Client{pool: pool}
+}
+
+// Pool returns the underlying pool
+func (c *Client) Pool() *Pool {
+	return c.pool
+}
+
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.ChainID(ctx)
+	})
+}
+
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.BlockNumber(ctx)
+	})
+}
+
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*types.Header, error) {
+		return client.HeaderByNumber(ctx, number)
+	})
+}
+
+func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*types.Block, error) {
+		return client.BlockByNumber(ctx, number)
+	})
+}
+
+func (c *Client) FilterLogs(ctx context.Context, query geth.FilterQuery) ([]types.Log, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) ([]types.Log, error) {
+		return client.FilterLogs(ctx, query)
+	})
+}
+
+func (c *Client) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*types.Receipt, error) {
+		return client.TransactionReceipt(ctx, hash)
+	})
+}
+
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.BalanceAt(ctx, account, blockNumber)
+	})
+}
+
+func (c *Client) CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CallContract(ctx, call, blockNumber)
+	})
+}
+
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.PendingNonceAt(ctx, account)
+	})
+}
+
+func (c *Client) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasTipCap(ctx)
+	})
+}
+
+func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasPrice(ctx)
+	})
+}
+
+func (c *Client) EstimateGas(ctx context.Context, call geth.CallMsg) (uint64, error) {
+	return read(ctx, c.pool, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.EstimateGas(ctx, call)
+	})
+}
+
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return c.pool.Do(ctx, false, func(ctx context.Context, client *ethclient.Client) error {
+		return client.SendTransaction(ctx, tx)
+	})
+}
+
+// read runs an idempotent typed call through the pool
+func read[T any](ctx context.Context, pool *Pool, fn func(ctx context.Context, client *ethclient.Client) (T, error)) (T, error) {
+	var result T
+	err := pool.Do(ctx, true, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		result, err = fn(ctx, client)
+		return err
+	})
+
+	return result, err
+}
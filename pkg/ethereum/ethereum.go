@@ -1,51 +1,52 @@
 package ethereum
 
 import (
-	"crypto/ecdsa"
-	"log"
-
-	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/tyler-smith/go-bip39"
-	"golang.org/x/crypto/sha3"
 
 	models "wallet/pkg/models"
 )
 
-func generateAddress() (models.WalletKey, error) {
-	privateKey, err := crypto.GenerateKey()
+// GenerateWallet creates a new mnemonic and derives its first account.
+// Wallets use no BIP-39 passphrase, so only the mnemonic needs to be kept:
+// every account key is re-derived from it.
+func GenerateWallet() (models.WalletKey, error) {
+	entropy, err := bip39.NewEntropy(128)
 	if err != nil {
 		return models.WalletKey{}, err
 	}
-
-	privateKeyBytes := crypto.FromECDSA(privateKey)
-
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Fatal("Error casting public key to ECDSA")
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
 		return models.WalletKey{}, err
 	}
 
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(publicKeyBytes[1:])
-
-	// Generate mnemonic
-	entropy, err := bip39.NewEntropy(128)
+	wallet, err := NewHDWallet(mnemonic, "")
 	if err != nil {
 		return models.WalletKey{}, err
 	}
-	mnemonic, err := bip39.NewMnemonic(entropy)
+
+	account, _, err := wallet.DeriveAccount(0)
 	if err != nil {
 		return models.WalletKey{}, err
 	}
 
 	return models.WalletKey{
-		PublicKey:  address,
-		PrivateKey: hexutil.Encode(privateKeyBytes)[2:],
-		Mnemonic:   mnemonic,
+		PublicKey: account.Address,
+		Mnemonic:  mnemonic,
+		Accounts:  []models.WalletAccount{account},
+		NextIndex: 1,
 	}, nil
 }
+
+// NextAccount derives the next unused account of walletKey from its opened
+// wallet and appends it
+func NextAccount(walletKey *models.WalletKey, wallet *HDWallet) (models.WalletAccount, error) {
+	account, _, err := wallet.DeriveAccount(walletKey.NextIndex)
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	walletKey.Accounts = append(walletKey.Accounts, account)
+	walletKey.NextIndex++
+
+	return account, nil
+}
@@ -0,0 +1,152 @@
+package ethereum
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+
+	models "wallet/pkg/models"
+)
+
+// hardenedOffset is the first BIP-32 hardened child index.
+const hardenedOffset = 0x80000000
+
+var (
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	ErrInvalidSeed     = errors.New("seed must be between 16 and 64 bytes")
+	ErrInvalidKey      = errors.New("derived key is invalid")
+	ErrAccountMismatch = errors.New("derived address does not match stored account")
+)
+
+// extendedKey is a BIP-32 extended private key.
+type extendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// HDWallet is a BIP-32 hierarchical deterministic wallet rooted at a BIP-39 seed.
+type HDWallet struct {
+	master *extendedKey
+}
+
+// NewHDWallet builds a wallet from a BIP-39 mnemonic and an optional passphrase
+func NewHDWallet(mnemonic string, passphrase string) (*HDWallet, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+
+	return NewHDWalletFromSeed(bip39.NewSeed(mnemonic, passphrase))
+}
+
+// NewHDWalletFromSeed builds a wallet from a raw BIP-32 seed
+func NewHDWalletFromSeed(seed []byte) (*HDWallet, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeed
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(crypto.S256().Params().N) >= 0 {
+		return nil, ErrInvalidKey
+	}
+
+	return &HDWallet{master: &extendedKey{key: sum[:32], chainCode: sum[32:]}}, nil
+}
+
+// Derive returns the private key at the given derivation path
+func (w *HDWallet) Derive(path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	key := w.master
+	for _, index := range path {
+		child, err := key.child(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+
+	return crypto.ToECDSA(key.key)
+}
+
+// DeriveAccount returns the account and private key at m/44'/60'/0'/0/index
+func (w *HDWallet) DeriveAccount(index uint32) (models.WalletAccount, *ecdsa.PrivateKey, error) {
+	path := AccountPath(index)
+
+	privateKey, err := w.Derive(path)
+	if err != nil {
+		return models.WalletAccount{}, nil, err
+	}
+
+	return models.WalletAccount{
+		Index:   index,
+		Path:    path.String(),
+		Address: crypto.PubkeyToAddress(privateKey.PublicKey).Hex(),
+	}, privateKey, nil
+}
+
+// VerifyAccount re-derives an account and checks it matches the stored address
+func (w *HDWallet) VerifyAccount(account models.WalletAccount) error {
+	derived, _, err := w.DeriveAccount(account.Index)
+	if err != nil {
+		return err
+	}
+
+	if derived.Address != account.Address {
+		return fmt.Errorf("%w: index %d", ErrAccountMismatch, account.Index)
+	}
+
+	return nil
+}
+
+// AccountPath returns the BIP-44 Ethereum path for the given account index
+func AccountPath(index uint32) accounts.DerivationPath {
+	path := make(accounts.DerivationPath, len(accounts.DefaultBaseDerivationPath))
+	copy(path, accounts.DefaultBaseDerivationPath)
+	path[len(path)-1] = index
+
+	return path
+}
+
+// child derives the BIP-32 child private key at index
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	var data []byte
+	if index >= hardenedOffset {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		privateKey, err := crypto.ToECDSA(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = crypto.CompressPubkey(&privateKey.PublicKey)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidKey
+	}
+
+	childKey := il.Add(il, new(big.Int).SetBytes(k.key))
+	childKey.Mod(childKey, n)
+	if childKey.Sign() == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	return &extendedKey{key: math.PaddedBigBytes(childKey, 32), chainCode: sum[32:]}, nil
+}
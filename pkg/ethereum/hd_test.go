@@ -0,0 +1,116 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestBIP39Seed(t *testing.T) {
+	seed := bip39.NewSeed(testMnemonic, "TREZOR")
+	want := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+
+	if got := hex.EncodeToString(seed); got != want {
+		t.Errorf("seed mismatch. got: %s, want: %s", got, want)
+	}
+}
+
+func TestBIP32Vector1(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	wallet, err := NewHDWalletFromSeed(seed)
+	if err != nil {
+		t.Fatalf("NewHDWalletFromSeed failed: %v", err)
+	}
+
+	vectors := []struct {
+		path string
+		key  string
+	}{
+		{"m/0'", "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{"m/0'/1", "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{"m/0'/1/2'", "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+		{"m/0'/1/2'/2", "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+		{"m/0'/1/2'/2/1000000000", "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+	}
+
+	for _, v := range vectors {
+		path, err := accounts.ParseDerivationPath(v.path)
+		if err != nil {
+			t.Fatalf("ParseDerivationPath(%s) failed: %v", v.path, err)
+		}
+
+		key, err := wallet.Derive(path)
+		if err != nil {
+			t.Fatalf("Derive(%s) failed: %v", v.path, err)
+		}
+
+		if got := hex.EncodeToString(crypto.FromECDSA(key)); got != v.key {
+			t.Errorf("%s: got: %s, want: %s", v.path, got, v.key)
+		}
+	}
+}
+
+func TestDeriveAccount(t *testing.T) {
+	wallet, err := NewHDWallet(testMnemonic, "")
+	if err != nil {
+		t.Fatalf("NewHDWallet failed: %v", err)
+	}
+
+	account, _, err := wallet.DeriveAccount(0)
+	if err != nil {
+		t.Fatalf("DeriveAccount failed: %v", err)
+	}
+
+	if account.Address != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Errorf("unexpected address: %s", account.Address)
+	}
+	if account.Path != "m/44'/60'/0'/0/0" {
+		t.Errorf("unexpected path: %s", account.Path)
+	}
+
+	if err := wallet.VerifyAccount(account); err != nil {
+		t.Errorf("VerifyAccount failed: %v", err)
+	}
+
+	account.Index = 1
+	if err := wallet.VerifyAccount(account); err == nil {
+		t.Error("VerifyAccount accepted an account with the wrong index")
+	}
+}
+
+func TestGenerateWalletIsRecoverable(t *testing.T) {
+	walletKey, err := GenerateWallet()
+	if err != nil {
+		t.Fatalf("GenerateWallet failed: %v", err)
+	}
+
+	wallet, err := NewHDWallet(walletKey.Mnemonic, "")
+	if err != nil {
+		t.Fatalf("NewHDWallet failed: %v", err)
+	}
+
+	account, err := NextAccount(&walletKey, wallet)
+	if err != nil {
+		t.Fatalf("NextAccount failed: %v", err)
+	}
+	if account.Index != 1 || walletKey.NextIndex != 2 {
+		t.Errorf("unexpected account index %d, next %d", account.Index, walletKey.NextIndex)
+	}
+
+	for _, a := range walletKey.Accounts {
+		if err := wallet.VerifyAccount(a); err != nil {
+			t.Errorf("VerifyAccount(%d) failed: %v", a.Index, err)
+		}
+	}
+}
+
+func TestNewHDWalletRejectsInvalidMnemonic(t *testing.T) {
+	if _, err := NewHDWallet("abandon abandon abandon", ""); err != ErrInvalidMnemonic {
+		t.Errorf("expected ErrInvalidMnemonic, got %v", err)
+	}
+}
@@ -0,0 +1,554 @@
+package ethereum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	ErrNoEndpoints        = errors.New("no RPC endpoints configured")
+	ErrNoHealthyEndpoints = errors.New("no healthy RPC endpoint available")
+)
+
+// readOnlyMethods are the JSON-RPC methods that are safe to retry on another
+// endpoint. Anything else, notably eth_sendRawTransaction, is sent once.
+var readOnlyMethods = map[string]bool{
+	"eth_blockNumber":           true,
+	"eth_chainId":               true,
+	"eth_call":                  true,
+	"eth_estimateGas":           true,
+	"eth_feeHistory":            true,
+	"eth_gasPrice":              true,
+	"eth_getBalance":            true,
+	"eth_getBlockByHash":        true,
+	"eth_getBlockByNumber":      true,
+	"eth_getCode":               true,
+	"eth_getLogs":               true,
+	"eth_getTransactionByHash":  true,
+	"eth_getTransactionCount":   true,
+	"eth_getTransactionReceipt": true,
+	"eth_maxPriorityFeePerGas":  true,
+	"net_version":               true,
+}
+
+// PoolConfig tunes a Pool. Zero fields take the values of DefaultPoolConfig.
+type PoolConfig struct {
+	// MaxAttempts bounds how many endpoints an idempotent call is tried on
+	MaxAttempts int
+	// RequestTimeout bounds a single attempt
+	RequestTimeout time.Duration
+	// RateLimit is the sustained requests per second allowed per endpoint
+	RateLimit float64
+	// Burst is the number of requests an idle endpoint may receive at once
+	Burst int
+	// MaxHeadLag is how many blocks an endpoint may trail the best one
+	// before it is treated as unhealthy
+	MaxHeadLag uint64
+	// FailureThreshold consecutive failures take an endpoint out of rotation
+	// for Cooldown
+	FailureThreshold int
+	Cooldown         time.Duration
+	// HealthInterval is how often Run probes every endpoint's head block
+	HealthInterval time.Duration
+}
+
+// DefaultPoolConfig is used for fields left zero in a PoolConfig
+var DefaultPoolConfig = PoolConfig{
+	MaxAttempts:      3,
+	RequestTimeout:   10 * time.Second,
+	RateLimit:        25,
+	Burst:            50,
+	MaxHeadLag:       5,
+	FailureThreshold: 3,
+	Cooldown:         30 * time.Second,
+	HealthInterval:   15 * time.Second,
+}
+
+// EndpointStats is a snapshot of an endpoint's health
+type EndpointStats struct {
+	URL       string        `json:"url"`
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"latency"`
+	ErrorRate float64       `json:"error_rate"`
+	Head      uint64        `json:"head"`
+	HeadLag   uint64        `json:"head_lag"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// Pool spreads JSON-RPC calls for one chain over several endpoints. It ranks
+// endpoints by latency, error rate and head block lag, fails over when one
+// misbehaves, retries idempotent calls and rate limits each endpoint.
+type Pool struct {
+	config    PoolConfig
+	endpoints []*endpoint
+}
+
+type endpoint struct {
+	url     string
+	client  *rpc.Client
+	eth     *ethclient.Client
+	limiter *rateLimiter
+
+	mu                  sync.Mutex
+	latency             time.Duration // exponentially weighted moving average
+	errorRate           float64       // exponentially weighted moving average
+	head                uint64
+	consecutiveFailures int
+	cooldownUntil       time.Time
+	lastErr             error
+}
+
+// ewmaWeight is the weight of the newest sample in the moving averages
+const ewmaWeight = 0.2
+
+// NewPool creates a pool over the given endpoint URLs. Connections are made
+// lazily, so an unreachable endpoint does not prevent the pool from starting.
+func NewPool(urls []string, config PoolConfig) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	config = withDefaults(config)
+	p := &Pool{config: config}
+
+	for _, url := range urls {
+		client, err := rpc.DialOptions(context.Background(), url, rpc.WithHTTPClient(&http.Client{Timeout: config.RequestTimeout}))
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("invalid RPC endpoint %s: %w", redactURL(url), err)
+		}
+
+		p.endpoints = append(p.endpoints, &endpoint{
+			url:     url,
+			client:  client,
+			eth:     ethclient.NewClient(client),
+			limiter: newRateLimiter(config.RateLimit, config.Burst),
+		})
+	}
+
+	return p, nil
+}
+
+// Close closes every endpoint connection
+func (p *Pool) Close() {
+	for _, e := range p.endpoints {
+		e.client.Close()
+	}
+}
+
+// CallContext performs a JSON-RPC call, failing over to other endpoints if
+// the method is read-only
+func (p *Pool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return p.do(ctx, readOnlyMethods[method], func(ctx context.Context, e *endpoint) error {
+		return e.client.CallContext(ctx, result, method, args...)
+	})
+}
+
+// BatchCallContext sends all elements in one JSON-RPC batch. The batch is
+// retried on another endpoint only if every method in it is read-only.
+// Per-element errors are reported in the elements, as with rpc.Client.
+func (p *Pool) BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error {
+	idempotent := true
+	for _, elem := range batch {
+		idempotent = idempotent && readOnlyMethods[elem.Method]
+	}
+
+	return p.do(ctx, idempotent, func(ctx context.Context, e *endpoint) error {
+		for i := range batch {
+			batch[i].Error = nil
+		}
+		return e.client.BatchCallContext(ctx, batch)
+	})
+}
+
+// Do runs fn against the best endpoint's client. If idempotent is true and
+// the endpoint fails, fn is retried on the next best endpoint.
+func (p *Pool) Do(ctx context.Context, idempotent bool, fn func(ctx context.Context, client *ethclient.Client) error) error {
+	return p.do(ctx, idempotent, func(ctx context.Context, e *endpoint) error {
+		return fn(ctx, e.eth)
+	})
+}
+
+// ProbeResult is the outcome of probing one endpoint
+type ProbeResult struct {
+	URL     string        `json:"url"`
+	Head    uint64        `json:"head,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// CheckHealth probes the head block of every endpoint concurrently
+func (p *Pool) CheckHealth(ctx context.Context) {
+	p.Probe(ctx)
+}
+
+// Probe asks every endpoint for its head block concurrently and returns the
+// outcomes in endpoint order. The outcomes count towards endpoint health
+// like any other call.
+func (p *Pool) Probe(ctx context.Context) []ProbeResult {
+	results := make([]ProbeResult, len(p.endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+
+			attemptCtx, cancel := context.WithTimeout(ctx, p.config.RequestTimeout)
+			defer cancel()
+
+			var head hexutil.Uint64
+			start := time.Now()
+			err := e.client.CallContext(attemptCtx, &head, "eth_blockNumber")
+			latency := time.Since(start)
+			p.record(e, latency, err)
+
+			results[i] = ProbeResult{URL: redactURL(e.url), Latency: latency}
+			if err != nil {
+				results[i].Error = strings.ReplaceAll(err.Error(), e.url, results[i].URL)
+				return
+			}
+
+			results[i].Head = uint64(head)
+			e.mu.Lock()
+			e.head = uint64(head)
+			e.mu.Unlock()
+		}(i, e)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Run checks endpoint health every HealthInterval until ctx is cancelled
+func (p *Pool) Run(ctx context.Context) {
+	p.CheckHealth(ctx)
+
+	ticker := time.NewTicker(p.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.CheckHealth(ctx)
+		}
+	}
+}
+
+// Stats returns a snapshot of every endpoint's health, best first
+func (p *Pool) Stats() []EndpointStats {
+	best := p.bestHead()
+	now := time.Now()
+
+	var stats []EndpointStats
+	for _, e := range p.ranked() {
+		e.mu.Lock()
+		s := EndpointStats{
+			URL:       redactURL(e.url),
+			Healthy:   e.healthy(now, best, p.config.MaxHeadLag),
+			Latency:   e.latency,
+			ErrorRate: e.errorRate,
+			Head:      e.head,
+			HeadLag:   lag(best, e.head),
+		}
+		if e.lastErr != nil {
+			s.LastError = strings.ReplaceAll(e.lastErr.Error(), e.url, s.URL)
+		}
+		e.mu.Unlock()
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// do runs call against endpoints in rank order
+func (p *Pool) do(ctx context.Context, idempotent bool, call func(ctx context.Context, e *endpoint) error) error {
+	attempts := 1
+	if idempotent {
+		attempts = p.config.MaxAttempts
+	}
+
+	var lastErr error
+	tried := 0
+	for _, e := range p.ranked() {
+		if tried == attempts {
+			break
+		}
+
+		// Skip endpoints that are out of budget while others remain
+		if !e.limiter.allow() {
+			continue
+		}
+		tried++
+
+		err := p.attempt(ctx, e, call)
+		if err == nil || !isEndpointFailure(err) || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+	}
+
+	// Every endpoint was rate limited: wait for the best one
+	if tried == 0 {
+		e := p.ranked()[0]
+		if err := e.limiter.wait(ctx); err != nil {
+			return err
+		}
+		return p.attempt(ctx, e, call)
+	}
+
+	return fmt.Errorf("%w: %w", ErrNoHealthyEndpoints, lastErr)
+}
+
+// attempt runs one call against an endpoint and records the outcome
+func (p *Pool) attempt(ctx context.Context, e *endpoint, call func(ctx context.Context, e *endpoint) error) error {
+	attemptCtx, cancel := context.WithTimeout(ctx, p.config.RequestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := call(attemptCtx, e)
+
+	if isEndpointFailure(err) && ctx.Err() == nil {
+		p.record(e, time.Since(start), err)
+	} else {
+		p.record(e, time.Since(start), nil)
+	}
+
+	return err
+}
+
+// record updates an endpoint's moving averages with the outcome of a call
+func (p *Pool) record(e *endpoint, latency time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	failure := 0.0
+	if err != nil {
+		failure = 1
+		e.consecutiveFailures++
+		e.lastErr = err
+		if e.consecutiveFailures >= p.config.FailureThreshold {
+			e.cooldownUntil = time.Now().Add(p.config.Cooldown)
+		}
+	} else {
+		e.consecutiveFailures = 0
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = time.Duration(ewmaWeight*float64(latency) + (1-ewmaWeight)*float64(e.latency))
+		}
+	}
+	e.errorRate = ewmaWeight*failure + (1-ewmaWeight)*e.errorRate
+}
+
+// ranked returns the endpoints ordered best first. Healthy endpoints come
+// before unhealthy ones; within each group lower scores win.
+func (p *Pool) ranked() []*endpoint {
+	best := p.bestHead()
+	now := time.Now()
+
+	type candidate struct {
+		e       *endpoint
+		healthy bool
+		score   float64
+	}
+
+	candidates := make([]candidate, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		candidates[i] = candidate{
+			e:       e,
+			healthy: e.healthy(now, best, p.config.MaxHeadLag),
+			score:   e.score(best),
+		}
+		e.mu.Unlock()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].healthy != candidates[j].healthy {
+			return candidates[i].healthy
+		}
+		return candidates[i].score < candidates[j].score
+	})
+
+	ranked := make([]*endpoint, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = c.e
+	}
+
+	return ranked
+}
+
+// bestHead returns the highest head block seen on any endpoint
+func (p *Pool) bestHead() uint64 {
+	var best uint64
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		if e.head > best {
+			best = e.head
+		}
+		e.mu.Unlock()
+	}
+
+	return best
+}
+
+// healthy reports whether the endpoint should be used. Callers hold e.mu.
+func (e *endpoint) healthy(now time.Time, bestHead uint64, maxLag uint64) bool {
+	if now.Before(e.cooldownUntil) {
+		return false
+	}
+
+	return lag(bestHead, e.head) <= maxLag
+}
+
+// score ranks healthy endpoints: lower is better. Latency is penalised by the
+// recent error rate and by every block the endpoint trails. Callers hold e.mu.
+func (e *endpoint) score(bestHead uint64) float64 {
+	latency := float64(e.latency)
+	if latency == 0 {
+		latency = float64(100 * time.Millisecond)
+	}
+
+	return latency * (1 + 10*e.errorRate) * (1 + float64(lag(bestHead, e.head)))
+}
+
+// isEndpointFailure reports whether err was caused by the endpoint rather
+// than by the request, so that another endpoint may succeed. JSON-RPC errors
+// such as reverts are answers, except for rate limiting.
+func isEndpointFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == -32005 // limit exceeded
+	}
+
+	return true
+}
+
+func lag(best, head uint64) uint64 {
+	if head >= best {
+		return 0
+	}
+	return best - head
+}
+
+func withDefaults(config PoolConfig) PoolConfig {
+	d := DefaultPoolConfig
+	if config.MaxAttempts > 0 {
+		d.MaxAttempts = config.MaxAttempts
+	}
+	if config.RequestTimeout > 0 {
+		d.RequestTimeout = config.RequestTimeout
+	}
+	if config.RateLimit > 0 {
+		d.RateLimit = config.RateLimit
+	}
+	if config.Burst > 0 {
+		d.Burst = config.Burst
+	}
+	if config.MaxHeadLag > 0 {
+		d.MaxHeadLag = config.MaxHeadLag
+	}
+	if config.FailureThreshold > 0 {
+		d.FailureThreshold = config.FailureThreshold
+	}
+	if config.Cooldown > 0 {
+		d.Cooldown = config.Cooldown
+	}
+	if config.HealthInterval > 0 {
+		d.HealthInterval = config.HealthInterval
+	}
+
+	return d
+}
+
+// redactURL drops the path and query of an endpoint URL, where providers
+// usually put API keys
+func redactURL(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return "[redacted]"
+	}
+
+	host, _, _ := strings.Cut(rest, "/")
+	if _, after, ok := strings.Cut(host, "@"); ok {
+		host = after
+	}
+
+	return scheme + "://" + host
+}
+
+// rateLimiter is a token bucket
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token if one is available
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
+
+// wait blocks until a token is available or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		l.refill()
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration(math.Ceil((1 - l.tokens) / l.rate * float64(time.Second)))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// refill adds the tokens accrued since the last call. Callers hold l.mu.
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
@@ -0,0 +1,182 @@
+package ethereum
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrInvalidAmount = errors.New("amount must be greater than zero")
+	ErrNoBaseFee     = errors.New("chain does not support EIP-1559")
+)
+
+// TxBackend is the subset of a JSON-RPC client needed to build and broadcast
+// transactions. It is satisfied by *Client, *ethclient.Client and by
+// go-ethereum's simulated backend.
+type TxBackend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call geth.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// BuildTransfer prepares an unsigned EIP-1559 ETH transfer. The fee cap
+// leaves room for the base fee to double before the transaction is mined.
+func BuildTransfer(ctx context.Context, backend TxBackend, from common.Address, to common.Address, value *big.Int) (*types.DynamicFeeTx, error) {
+	if value == nil || value.Sign() <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.BaseFee == nil {
+		return nil, ErrNoBaseFee
+	}
+
+	nonce, err := backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	tipCap, err := backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feeCap := new(big.Int).Mul(head.BaseFee, big.NewInt(2))
+	feeCap.Add(feeCap, tipCap)
+
+	gas, err := backend.EstimateGas(ctx, geth.CallMsg{
+		From:      from,
+		To:        &to,
+		GasFeeCap: feeCap,
+		GasTipCap: tipCap,
+		Value:     value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        &to,
+		Value:     value,
+	}, nil
+}
+
+// BuildLegacyTransfer prepares an unsigned EIP-155 ETH transfer for chains
+// that do not support EIP-1559
+func BuildLegacyTransfer(ctx context.Context, backend TxBackend, from common.Address, to common.Address, value *big.Int) (*types.LegacyTx, error) {
+	if value == nil || value.Sign() <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	nonce, err := backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	gasPrice, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gas, err := backend.EstimateGas(ctx, geth.CallMsg{
+		From:     from,
+		To:       &to,
+		GasPrice: gasPrice,
+		Value:    value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: gasPrice,
+		Gas:      gas,
+		To:       &to,
+		Value:    value,
+	}, nil
+}
+
+// SignTransfer signs a transaction for the given chain
+func SignTransfer(tx types.TxData, chainID *big.Int, privateKey *ecdsa.PrivateKey) (*types.Transaction, error) {
+	return types.SignNewTx(privateKey, types.LatestSignerForChainID(chainID), tx)
+}
+
+// NewSignedTransfer builds and signs an EIP-1559 ETH transfer from the key's
+// address without broadcasting it
+func NewSignedTransfer(ctx context.Context, backend TxBackend, privateKey *ecdsa.PrivateKey, to common.Address, value *big.Int) (*types.Transaction, error) {
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	unsigned, err := BuildTransfer(ctx, backend, from, to, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignTransfer(unsigned, unsigned.ChainID, privateKey)
+}
+
+// NewSignedLegacyTransfer builds and signs a legacy ETH transfer from the
+// key's address without broadcasting it
+func NewSignedLegacyTransfer(ctx context.Context, backend TxBackend, privateKey *ecdsa.PrivateKey, to common.Address, value *big.Int) (*types.Transaction, error) {
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unsigned, err := BuildLegacyTransfer(ctx, backend, from, to, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignTransfer(unsigned, chainID, privateKey)
+}
+
+// SendTransfer builds, signs and broadcasts an EIP-1559 ETH transfer from the
+// key's address. The signed transaction is returned even if the broadcast
+// fails.
+func SendTransfer(ctx context.Context, backend TxBackend, privateKey *ecdsa.PrivateKey, to common.Address, value *big.Int) (*types.Transaction, error) {
+	signed, err := NewSignedTransfer(ctx, backend, privateKey, to, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return signed, backend.SendTransaction(ctx, signed)
+}
+
+// SendLegacyTransfer builds, signs and broadcasts a legacy ETH transfer from
+// the key's address. The signed transaction is returned even if the
+// broadcast fails.
+func SendLegacyTransfer(ctx context.Context, backend TxBackend, privateKey *ecdsa.PrivateKey, to common.Address, value *big.Int) (*types.Transaction, error) {
+	signed, err := NewSignedLegacyTransfer(ctx, backend, privateKey, to, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return signed, backend.SendTransaction(ctx, signed)
+}
@@ -0,0 +1,103 @@
+package ethereum
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestSendTransferOnSimulatedBackend(t *testing.T) {
+	wallet, err := NewHDWallet(testMnemonic, "")
+	if err != nil {
+		t.Fatalf("NewHDWallet failed: %v", err)
+	}
+
+	account, privateKey, err := wallet.DeriveAccount(0)
+	if err != nil {
+		t.Fatalf("DeriveAccount failed: %v", err)
+	}
+	from := common.HexToAddress(account.Address)
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		from: {Balance: new(big.Int).Mul(big.NewInt(10), big.NewInt(params.Ether))},
+	})
+	defer backend.Close()
+	client := backend.Client()
+
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	value := big.NewInt(params.Ether)
+
+	ctx := context.Background()
+	tx, err := SendTransfer(ctx, client, privateKey, to, value)
+	if err != nil {
+		t.Fatalf("SendTransfer failed: %v", err)
+	}
+	if tx.Type() != types.DynamicFeeTxType {
+		t.Errorf("expected a dynamic fee transaction, got type %d", tx.Type())
+	}
+
+	backend.Commit()
+
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		t.Fatalf("TransactionReceipt failed: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Errorf("transaction failed with status %d", receipt.Status)
+	}
+
+	balance, err := client.BalanceAt(ctx, to, nil)
+	if err != nil {
+		t.Fatalf("BalanceAt failed: %v", err)
+	}
+	if balance.Cmp(value) != 0 {
+		t.Errorf("unexpected recipient balance: %s", balance)
+	}
+
+	// A second transfer must pick up the next nonce
+	tx, err = SendTransfer(ctx, client, privateKey, to, value)
+	if err != nil {
+		t.Fatalf("second SendTransfer failed: %v", err)
+	}
+	if tx.Nonce() != 1 {
+		t.Errorf("expected nonce 1, got %d", tx.Nonce())
+	}
+}
+
+func TestSendLegacyTransferOnSimulatedBackend(t *testing.T) {
+	wallet, _ := NewHDWallet(testMnemonic, "")
+	account, privateKey, _ := wallet.DeriveAccount(0)
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		common.HexToAddress(account.Address): {Balance: big.NewInt(params.Ether)},
+	})
+	defer backend.Close()
+	client := backend.Client()
+
+	ctx := context.Background()
+	tx, err := SendLegacyTransfer(ctx, client, privateKey, common.HexToAddress("0x000000000000000000000000000000000000dEaD"), big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("SendLegacyTransfer failed: %v", err)
+	}
+	if tx.Type() != types.LegacyTxType || !tx.Protected() {
+		t.Errorf("expected a replay protected legacy transaction, got type %d", tx.Type())
+	}
+
+	backend.Commit()
+
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	if err != nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Errorf("legacy transfer was not mined successfully: %v", err)
+	}
+}
+
+func TestBuildTransferRejectsZeroValue(t *testing.T) {
+	if _, err := BuildTransfer(context.Background(), nil, common.Address{}, common.Address{}, big.NewInt(0)); err != ErrInvalidAmount {
+		t.Errorf("expected ErrInvalidAmount, got %v", err)
+	}
+}
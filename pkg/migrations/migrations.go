@@ -0,0 +1,178 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	mongodb "wallet/pkg/mongo"
+)
+
+const (
+	// collectionName holds a record of every applied migration and the lock
+	// of the instance applying them
+	collectionName = "migrations"
+	lockID         = "lock"
+	// lockLease is how long a lock is honoured if the instance holding it
+	// dies without releasing it
+	lockLease = 30 * time.Minute
+)
+
+// Migration is one forward step of the database schema or data. Steps must
+// be safe to run again, as an instance dying between applying a step and
+// recording it leaves it pending.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongodb.DB) error
+}
+
+// record is a migration applied to the database
+type record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Pending returns the migrations not applied to db yet, in version order
+func Pending(ctx context.Context, db *mongodb.DB) ([]Migration, error) {
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range all {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// Run applies the pending migrations in version order and returns those it
+// applied. Instances starting together wait for the one holding the lock
+// instead of migrating concurrently.
+func Run(ctx context.Context, db *mongodb.DB) ([]Migration, error) {
+	if err := validate(all); err != nil {
+		return nil, err
+	}
+
+	release, err := lock(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	pending, err := Pending(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := db.Collection(collectionName)
+
+	var applied []Migration
+	for _, m := range pending {
+		log.Printf("Applying migration %d %s", m.Version, m.Name)
+		if err := m.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		opCtx, cancel := db.Context(ctx)
+		_, err := collection.InsertOne(opCtx, record{Version: m.Version, Name: m.Name, AppliedAt: time.Now()})
+		cancel()
+		if err != nil {
+			return applied, fmt.Errorf("failed to record migration %d %s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// validate checks that versions strictly increase, so every database
+// applies the migrations in the same order
+func validate(migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Name == "" || m.Up == nil {
+			return fmt.Errorf("migration %d is incomplete", m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return fmt.Errorf("migration %d %s is out of order", m.Version, m.Name)
+		}
+	}
+
+	return nil
+}
+
+// appliedVersions returns the versions recorded as applied
+func appliedVersions(ctx context.Context, db *mongodb.DB) (map[int]bool, error) {
+	ctx, cancel := db.Context(ctx)
+	defer cancel()
+
+	cursor, err := db.Collection(collectionName).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]bool{}
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+
+	return applied, nil
+}
+
+// lock takes the migration lock, polling until ctx is done while another
+// instance holds it, and returns a function releasing it
+func lock(ctx context.Context, db *mongodb.DB) (func(), error) {
+	collection := db.Collection(collectionName)
+
+	for {
+		now := time.Now()
+
+		opCtx, cancel := db.Context(ctx)
+		err := collection.FindOneAndUpdate(opCtx,
+			bson.M{"_id": lockID, "locked_until": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"locked_until": now.Add(lockLease)}},
+			options.FindOneAndUpdate().SetUpsert(true),
+		).Err()
+		cancel()
+
+		// A duplicate key means the lock exists and has not expired
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to lock migrations: %w", err)
+		}
+
+		log.Println("Waiting for another instance to finish migrating")
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lock migrations: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	return func() {
+		ctx, cancel := db.Context(context.Background())
+		defer cancel()
+
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": lockID}); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}, nil
+}
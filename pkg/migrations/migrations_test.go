@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	mongodb "wallet/pkg/mongo"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	if err := validate(all); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	up := func(ctx context.Context, db *mongodb.DB) error { return nil }
+
+	for name, migrations := range map[string][]Migration{
+		"duplicate version": {{Version: 1, Name: "a", Up: up}, {Version: 1, Name: "b", Up: up}},
+		"out of order":      {{Version: 2, Name: "a", Up: up}, {Version: 1, Name: "b", Up: up}},
+		"missing step":      {{Version: 1, Name: "a"}},
+		"missing name":      {{Version: 1, Up: up}},
+	} {
+		if validate(migrations) == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	mongodb "wallet/pkg/mongo"
+	"wallet/pkg/wallet"
+)
+
+// all lists every migration in version order. Append new migrations and
+// never change or remove applied ones.
+var all = []Migration{
+	{Version: 1, Name: "normalize-emails", Up: normalizeEmails},
+	{Version: 2, Name: "user-indexes", Up: userIndexes},
+	{Version: 3, Name: "session-indexes", Up: sessionIndexes},
+	{Version: 4, Name: "login-ceremony-indexes", Up: loginCeremonyIndexes},
+	{Version: 5, Name: "float-balances", Up: floatBalances},
+	{Version: 6, Name: "username-index", Up: usernameIndex},
+	{Version: 7, Name: "deposit-index", Up: depositIndex},
+}
+
+// normalizeEmails lowercases and trims stored emails, as new ones are, so
+// the unique index also catches addresses differing only in case
+func normalizeEmails(ctx context.Context, db *mongodb.DB) error {
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"email": bson.M{"$type": "string"}},
+		bson.A{bson.M{"$set": bson.M{"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}}},
+	)
+	return err
+}
+
+// userIndexes makes emails, wallet addresses and linked Ethereum addresses
+// unique, which closes the race between checking for a user and inserting
+// one
+func userIndexes(ctx context.Context, db *mongodb.DB) error {
+	collection := db.Collection("users")
+
+	if err := checkDuplicates(ctx, collection, nil, "email"); err != nil {
+		return err
+	}
+
+	return createIndexes(ctx, collection,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "wallet.accounts.address", Value: 1}},
+			Options: options.Index().SetName("wallet_address_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"wallet.accounts.address": bson.M{"$type": "string"}}),
+		},
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "ethereum_addresses", Value: 1}},
+			Options: options.Index().SetName("ethereum_addresses_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"ethereum_addresses": bson.M{"$type": "string"}}),
+		},
+	)
+}
+
+// sessionIndexes indexes session lookups and lets MongoDB delete sessions and
+// refresh tokens once they can no longer be refreshed
+func sessionIndexes(ctx context.Context, db *mongodb.DB) error {
+	err := createIndexes(ctx, db.Collection("tokens"),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "token_id", Value: 1}},
+			Options: options.Index().SetName("token_id_unique").SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "is_active", Value: 1}},
+			Options: options.Index().SetName("user_sessions"),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "refresh_expires_at", Value: 1}},
+			Options: options.Index().SetName("refresh_expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return createIndexes(ctx, db.Collection("refresh_tokens"),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	)
+}
+
+// loginCeremonyIndexes indexes passkeys and expires WebAuthn challenges and
+// Sign-In with Ethereum nonces
+func loginCeremonyIndexes(ctx context.Context, db *mongodb.DB) error {
+	err := createIndexes(ctx, db.Collection("webauthn_credentials"),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "credential_id", Value: 1}},
+			Options: options.Index().SetName("credential_id_unique").SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_credentials"),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = createIndexes(ctx, db.Collection("webauthn_challenges"),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return createIndexes(ctx, db.Collection("siwe_nonces"),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "nonce", Value: 1}},
+			Options: options.Index().SetName("nonce_unique").SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	)
+}
+
+// usernameCollation compares usernames ignoring case, as UsernameTaken does
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// usernameIndex makes usernames unique ignoring case, which closes the race
+// between checking a username and storing it
+func usernameIndex(ctx context.Context, db *mongodb.DB) error {
+	collection := db.Collection("users")
+
+	if err := checkDuplicates(ctx, collection, usernameCollation, "username"); err != nil {
+		return err
+	}
+
+	return createIndexes(ctx, collection,
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true).SetCollation(usernameCollation).
+				SetPartialFilterExpression(bson.M{"username": bson.M{"$type": "string"}}),
+		},
+	)
+}
+
+// depositIndex makes a deposit unique by its log, so concurrent scanners
+// cannot record the same deposit twice
+func depositIndex(ctx context.Context, db *mongodb.DB) error {
+	collection := db.Collection("deposits")
+
+	if err := checkDuplicates(ctx, collection, nil, "chain_id", "tx_hash", "log_index"); err != nil {
+		return err
+	}
+
+	return createIndexes(ctx, collection,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "chain_id", Value: 1}, {Key: "tx_hash", Value: 1}, {Key: "log_index", Value: 1}},
+			Options: options.Index().SetName("deposit_log_unique").SetUnique(true),
+		},
+	)
+}
+
+// floatBalances converts balances stored as float64 whole units
+func floatBalances(ctx context.Context, db *mongodb.DB) error {
+	_, err := wallet.MigrateFloatBalances(db)
+	return err
+}
+
+// createIndexes creates indexes on a collection. Creating an index that
+// exists with the same options does nothing.
+func createIndexes(ctx context.Context, collection *mongo.Collection, indexes ...mongo.IndexModel) error {
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return fmt.Errorf("failed to index %s: %w", collection.Name(), err)
+	}
+
+	return nil
+}
+
+// checkDuplicates reports values of fields held by more than one document,
+// compared with collation if set, which would make creating a unique index
+// fail with a less helpful error
+func checkDuplicates(ctx context.Context, collection *mongo.Collection, collation *options.Collation, fields ...string) error {
+	match := bson.M{}
+	key := bson.A{}
+	for _, field := range fields {
+		match[field] = bson.M{"$ne": nil}
+		key = append(key, "$"+field)
+	}
+
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": key, "count": bson.M{"$sum": 1}}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+		bson.M{"$limit": 10},
+	}, options.Aggregate().SetCollation(collation))
+	if err != nil {
+		return err
+	}
+
+	var duplicates []struct {
+		Values []interface{} `bson:"_id"`
+		Count  int           `bson:"count"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(duplicates))
+	for i, d := range duplicates {
+		parts := make([]string, len(d.Values))
+		for j, v := range d.Values {
+			parts[j] = fmt.Sprint(v)
+		}
+		values[i] = fmt.Sprintf("%s (%d %s)", strings.Join(parts, "/"), d.Count, collection.Name())
+	}
+
+	return fmt.Errorf("duplicate %s values must be resolved first: %s", strings.Join(fields, "/"), strings.Join(values, ", "))
+}
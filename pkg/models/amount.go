@@ -0,0 +1,203 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Amount is an exact token amount in integer base units (wei for ETH).
+// It is encoded as a decimal string in both JSON and BSON so no precision is
+// lost to float64 or to Mongo's 64-bit integers. The zero value is zero.
+// Amounts are immutable: arithmetic returns new values.
+type Amount struct {
+	value *big.Int
+}
+
+// NewAmount returns an amount of base units
+func NewAmount(units *big.Int) Amount {
+	if units == nil {
+		return Amount{}
+	}
+	return Amount{value: new(big.Int).Set(units)}
+}
+
+// ParseAmount parses an integer amount of base units
+func ParseAmount(s string) (Amount, error) {
+	value, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return Amount{value: value}, nil
+}
+
+// ParseUnits parses a human readable amount such as "1.5" or "1.5 ETH" into
+// base units of the token with the given symbol and decimals. A unit, if
+// given, must be the symbol, ignoring case. More fractional digits than the
+// token supports are rejected rather than rounded.
+func ParseUnits(s string, symbol string, decimals uint8) (Amount, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(fields) == 2 && !strings.EqualFold(fields[1], symbol) {
+		return Amount{}, fmt.Errorf("%w: %q is not in %s", ErrInvalidAmount, s, symbol)
+	}
+	number := fields[0]
+
+	negative := strings.HasPrefix(number, "-")
+	number = strings.TrimPrefix(number, "-")
+
+	whole, fraction, _ := strings.Cut(number, ".")
+	if whole == "" && fraction == "" {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(fraction) > int(decimals) {
+		return Amount{}, fmt.Errorf("%w: %q has more than %d decimals", ErrInvalidAmount, s, decimals)
+	}
+
+	digits := whole + fraction + strings.Repeat("0", int(decimals)-len(fraction))
+	if strings.ContainsFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	a, err := ParseAmount(digits)
+	if err != nil {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if negative {
+		a.value.Neg(a.value)
+	}
+
+	return a, nil
+}
+
+// Int returns a copy of the amount in base units
+func (a Amount) Int() *big.Int {
+	if a.value == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.value)
+}
+
+// String returns the amount in base units
+func (a Amount) String() string {
+	return a.Int().String()
+}
+
+// Format returns the amount in whole units, e.g. "1.5" for 1.5e18 wei with 18
+// decimals. Trailing fractional zeros are dropped.
+func (a Amount) Format(decimals uint8) string {
+	abs := a.Int()
+	negative := abs.Sign() < 0
+	abs.Abs(abs)
+	digits := abs.String()
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-int(decimals)]
+	fraction := strings.TrimRight(digits[len(digits)-int(decimals):], "0")
+
+	formatted := whole
+	if fraction != "" {
+		formatted += "." + fraction
+	}
+	if negative {
+		formatted = "-" + formatted
+	}
+
+	return formatted
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{value: new(big.Int).Add(a.Int(), b.Int())}
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{value: new(big.Int).Sub(a.Int(), b.Int())}
+}
+
+// Cmp compares a and b, returning -1, 0 or +1
+func (a Amount) Cmp(b Amount) int {
+	return a.Int().Cmp(b.Int())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of the amount
+func (a Amount) Sign() int {
+	return a.Int().Sign()
+}
+
+// IsZero reports whether the amount is zero
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + a.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a decimal string of base units
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return fmt.Errorf("%w: amounts must be JSON strings", ErrInvalidAmount)
+	}
+
+	parsed, err := ParseAmount(s[1 : len(s)-1])
+	if err != nil {
+		return err
+	}
+	*a = parsed
+
+	return nil
+}
+
+// MarshalBSONValue encodes the amount as a decimal string
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(a.String())
+}
+
+// UnmarshalBSONValue accepts a decimal string of base units
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	var s string
+	if err := bson.UnmarshalValue(t, data, &s); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+
+	return nil
+}
+
+// FloatToAmount converts a legacy float balance in whole units to base units.
+// It is only meant for migrating data stored before amounts were exact. The
+// float is read in its shortest decimal form, so 1.1 becomes exactly 1.1e18
+// wei; digits beyond the token's decimals are truncated.
+func FloatToAmount(units float64, decimals uint8) (Amount, error) {
+	if math.IsNaN(units) || math.IsInf(units, 0) {
+		return Amount{}, fmt.Errorf("%w: %v", ErrInvalidAmount, units)
+	}
+
+	s := strconv.FormatFloat(units, 'f', -1, 64)
+	if whole, fraction, ok := strings.Cut(s, "."); ok && len(fraction) > int(decimals) {
+		s = whole + "." + fraction[:decimals]
+	}
+
+	return ParseUnits(s, "", decimals)
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseAndFormatUnits(t *testing.T) {
+	vectors := []struct {
+		in       string
+		symbol   string
+		decimals uint8
+		units    string
+		format   string
+	}{
+		{"1.5 ETH", "ETH", 18, "1500000000000000000", "1.5"},
+		{"1.5 eth", "ETH", 18, "1500000000000000000", "1.5"},
+		{"0.000000000000000001", "ETH", 18, "1", "0.000000000000000001"},
+		{"115792089237316195423570985008687907853269984665640564039457.584007913129639935", "ETH", 18, "115792089237316195423570985008687907853269984665640564039457584007913129639935", "115792089237316195423570985008687907853269984665640564039457.584007913129639935"},
+		{"2.5 USDC", "USDC", 6, "2500000", "2.5"},
+		{"-3", "", 0, "-3", "-3"},
+		{".25", "", 2, "25", "0.25"},
+	}
+
+	for _, v := range vectors {
+		a, err := ParseUnits(v.in, v.symbol, v.decimals)
+		if err != nil {
+			t.Fatalf("ParseUnits(%q) failed: %v", v.in, err)
+		}
+		if a.String() != v.units {
+			t.Errorf("ParseUnits(%q) = %s, want %s", v.in, a, v.units)
+		}
+		if got := a.Format(v.decimals); got != v.format {
+			t.Errorf("Format(%s) = %s, want %s", a, got, v.format)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.2.3", "1.0000001", "1e18", "1.5 USDC extra", "1.5 ETH", "1.5 USD"} {
+		if _, err := ParseUnits(in, "USDC", 6); err == nil {
+			t.Errorf("ParseUnits(%q) should fail", in)
+		}
+	}
+}
+
+func TestParseUnitsRejectsOtherUnits(t *testing.T) {
+	_, err := ParseUnits("1.5 USDC", "ETH", 18)
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected ErrInvalidAmount for an amount in another token, got %v", err)
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a := NewAmount(big.NewInt(7))
+	b, _ := ParseAmount("5")
+
+	if a.Add(b).String() != "12" || a.Sub(b).String() != "2" || b.Sub(a).Sign() != -1 {
+		t.Errorf("unexpected arithmetic results")
+	}
+	if a.Cmp(b) != 1 || !(Amount{}).IsZero() {
+		t.Errorf("unexpected comparison results")
+	}
+
+	// Arithmetic must not mutate its operands
+	if a.String() != "7" || b.String() != "5" {
+		t.Errorf("operands were mutated: %s, %s", a, b)
+	}
+}
+
+func TestAmountEncoding(t *testing.T) {
+	balance := Balance{Symbol: "ETH", Amount: mustParse(t, "123456789012345678901234567890"), Decimals: 18}
+
+	data, err := json.Marshal(balance)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var fromJSON Balance
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if fromJSON.Amount.Cmp(balance.Amount) != 0 {
+		t.Errorf("JSON round trip changed the amount: %s", fromJSON.Amount)
+	}
+
+	raw, err := bson.Marshal(balance)
+	if err != nil {
+		t.Fatalf("bson.Marshal failed: %v", err)
+	}
+	if got := bson.Raw(raw).Lookup("amount").StringValue(); got != "123456789012345678901234567890" {
+		t.Errorf("amount stored as %q", got)
+	}
+	var fromBSON Balance
+	if err := bson.Unmarshal(raw, &fromBSON); err != nil {
+		t.Fatalf("bson.Unmarshal failed: %v", err)
+	}
+	if fromBSON.Amount.Cmp(balance.Amount) != 0 {
+		t.Errorf("BSON round trip changed the amount: %s", fromBSON.Amount)
+	}
+}
+
+func TestFloatToAmount(t *testing.T) {
+	a, err := FloatToAmount(1.1, 18)
+	if err != nil || a.String() != "1100000000000000000" {
+		t.Errorf("FloatToAmount(1.1) = %s, %v", a, err)
+	}
+
+	a, err = FloatToAmount(0.1234567, 6)
+	if err != nil || a.String() != "123456" {
+		t.Errorf("FloatToAmount(0.1234567) = %s, %v", a, err)
+	}
+}
+
+func mustParse(t *testing.T, s string) Amount {
+	a, err := ParseAmount(s)
+	if err != nil {
+		t.Fatalf("ParseAmount(%q) failed: %v", s, err)
+	}
+	return a
+}
@@ -1,11 +1,19 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// NormalizeEmail returns the form emails are stored and looked up in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 type User struct {
 	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Username  string             `json:"username" bson:"username"`
@@ -16,37 +24,277 @@ type User struct {
 	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 	Active    bool               `json:"active" bson:"active"`
+	// PendingVerification is set on self-registered users until they confirm
+	// their email. Users created before registration existed never have it.
+	PendingVerification bool        `json:"pending_verification,omitempty" bson:"pending_verification,omitempty"`
+	EmailVerifiedAt     *time.Time  `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+	TOTP                *TOTPConfig `json:"-" bson:"totp,omitempty"`
+	// Role is one of the Role constants. Users created before roles existed
+	// have none and are treated as RoleUser.
+	Role string `json:"role,omitempty" bson:"role,omitempty"`
+	// EthereumAddresses are external accounts the user linked with Sign-In
+	// with Ethereum, as EIP-55 checksummed hex
+	EthereumAddresses []string `json:"ethereum_addresses,omitempty" bson:"ethereum_addresses,omitempty"`
+}
+
+// Roles a user can have, from least to most privileged
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// TOTPConfig is a user's authenticator app enrollment. The secret is sealed
+// by the vault and recovery codes are stored as SHA-256 hashes. LastStep is
+// the last accepted time step, so codes cannot be replayed.
+type TOTPConfig struct {
+	Secret        EncryptedSecret `bson:"secret"`
+	Enabled       bool            `bson:"enabled"`
+	EnabledAt     *time.Time      `bson:"enabled_at,omitempty"`
+	LastStep      int64           `bson:"last_step"`
+	RecoveryCodes []string        `bson:"recovery_codes,omitempty"`
+	Failures      int             `bson:"failures"`
+	LockedUntil   *time.Time      `bson:"locked_until,omitempty"`
 }
 
 type UserResponse struct {
 	ID       primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Username string             `json:"username" bson:"username"`
 	Email    string             `json:"email" bson:"email"`
+	Address  string             `json:"address" bson:"address"`
+	Role     string             `json:"role" bson:"role"`
 	Balances []Balance          `json:"balances,omitempty" bson:"balances,omitempty"`
 }
 
+// Balance is the balance of one wallet account in the native currency or an
+// ERC-20 token, as read at BlockNumber. Address is the token contract and is
+// empty for the native currency. Amount is in base units of Decimals.
 type Balance struct {
-	Name    string  `json:"name" bson:"name"`
-	Symbol  string  `json:"currency" bson:"currency"`
-	Address string  `json:"address" bson:"address"`
-	Amount  float64 `json:"amount" bson:"amount"`
+	ChainID     int64     `json:"chain_id" bson:"chain_id"`
+	Name        string    `json:"name" bson:"name"`
+	Symbol      string    `json:"currency" bson:"currency"`
+	Address     string    `json:"address" bson:"address"`
+	Account     string    `json:"account" bson:"account"`
+	Amount      Amount    `json:"amount" bson:"amount"`
+	Decimals    uint8     `json:"decimals" bson:"decimals"`
+	BlockNumber uint64    `json:"block_number" bson:"block_number"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
 }
 
+// WalletKey is the HD wallet root of a user. Accounts are derived from the
+// mnemonic along m/44'/60'/0'/0/index, so no private key is ever stored.
+// The mnemonic is only persisted encrypted, in Secret. The same addresses
+// are valid on every EVM chain; ChainIDs lists the chains the wallet is
+// enabled on.
 type WalletKey struct {
-	PrivateKey string `json:"private_key"`
-	PublicKey  string `json:"public_key"`
-	Mnemonic   string `json:"mnemonic"`
+	PublicKey string          `json:"public_key" bson:"public_key"`
+	ChainIDs  []int64         `json:"chain_ids" bson:"chain_ids"`
+	Mnemonic  string          `json:"-" bson:"-"`
+	Secret    EncryptedSecret `json:"-" bson:"secret"`
+	Accounts  []WalletAccount `json:"accounts" bson:"accounts"`
+	NextIndex uint32          `json:"next_index" bson:"next_index"`
 }
 
-type Config struct {
-	MongoURI  string `json:"mongo_uri"`
-	JWTSecret string
+type WalletAccount struct {
+	Index   uint32 `json:"index" bson:"index"`
+	Path    string `json:"path" bson:"path"`
+	Address string `json:"address" bson:"address"`
 }
 
-type Token struct {
+// EncryptedSecret is a secret encrypted under its own data key, which is in
+// turn wrapped by the master key named by KeyID.
+type EncryptedSecret struct {
+	KeyID      string `json:"key_id" bson:"key_id"`
+	WrappedKey string `json:"wrapped_key" bson:"wrapped_key"`
+	Ciphertext string `json:"ciphertext" bson:"ciphertext"`
+}
+
+// Chain describes an EIP-155 network the wallet can operate on
+type Chain struct {
+	ID             int64        `json:"chain_id"`
+	Name           string       `json:"name"`
+	NativeSymbol   string       `json:"native_symbol"`
+	NativeDecimals uint8        `json:"native_decimals"`
+	RPCURLs        []string     `json:"rpc_urls,omitempty"`
+	Explorer       string       `json:"explorer"`
+	Confirmations  uint64       `json:"confirmations"`
+	EIP1559        bool         `json:"eip1559"`
+	Tokens         []ChainToken `json:"tokens,omitempty"`
+	// Pool overrides the configured RPC pool settings for this chain
+	Pool *ChainPool `json:"pool,omitempty"`
+}
+
+// ChainToken is an ERC-20 contract tracked on a chain
+type ChainToken struct {
+	Symbol  string `json:"symbol"`
+	Address string `json:"address"`
+}
+
+// ChainPool tunes the RPC endpoint pool of a chain. Zero fields take the
+// configured value.
+type ChainPool struct {
+	MaxAttempts      int      `json:"max_attempts,omitempty"`
+	RequestTimeout   Duration `json:"request_timeout,omitempty"`
+	RateLimit        float64  `json:"rate_limit,omitempty"`
+	Burst            int      `json:"burst,omitempty"`
+	MaxHeadLag       uint64   `json:"max_head_lag,omitempty"`
+	FailureThreshold int      `json:"failure_threshold,omitempty"`
+	Cooldown         Duration `json:"cooldown,omitempty"`
+	HealthInterval   Duration `json:"health_interval,omitempty"`
+}
+
+// Duration is a time.Duration written in JSON as a string such as "30s"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string such as "30s" or "5m"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string such as \"30s\"", data)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value such as 30s or 5m", s)
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+const (
+	TxStatusPending = "pending"
+	TxStatusFailed  = "failed"
+)
+
+// Transaction records a transfer broadcast from a user's wallet
+type Transaction struct {
+	ID                   primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID               primitive.ObjectID `json:"user_id" bson:"user_id"`
+	ChainID              int64              `json:"chain_id" bson:"chain_id"`
+	Hash                 string             `json:"hash" bson:"hash"`
+	From                 string             `json:"from" bson:"from"`
+	To                   string             `json:"to" bson:"to"`
+	Value                Amount             `json:"value" bson:"value"`
+	Nonce                uint64             `json:"nonce" bson:"nonce"`
+	Gas                  uint64             `json:"gas" bson:"gas"`
+	MaxFeePerGas         string             `json:"max_fee_per_gas" bson:"max_fee_per_gas"`
+	MaxPriorityFeePerGas string             `json:"max_priority_fee_per_gas" bson:"max_priority_fee_per_gas"`
+	Status               string             `json:"status" bson:"status"`
+	Error                string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt            time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt            time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+const (
+	DepositStatusPending   = "pending"
+	DepositStatusConfirmed = "confirmed"
+	DepositStatusReorged   = "reorged"
+)
+
+// Deposit is an incoming native or ERC-20 transfer to a user's address.
+// Token is empty for native transfers, whose LogIndex is -1.
+type Deposit struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ChainID       int64              `json:"chain_id" bson:"chain_id"`
+	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Address       string             `json:"address" bson:"address"`
+	From          string             `json:"from" bson:"from"`
+	TxHash        string             `json:"tx_hash" bson:"tx_hash"`
+	LogIndex      int                `json:"log_index" bson:"log_index"`
+	Token         string             `json:"token,omitempty" bson:"token,omitempty"`
+	Symbol        string             `json:"currency" bson:"currency"`
+	Amount        Amount             `json:"amount" bson:"amount"`
+	Decimals      uint8              `json:"decimals" bson:"decimals"`
+	BlockNumber   uint64             `json:"block_number" bson:"block_number"`
+	BlockHash     string             `json:"block_hash" bson:"block_hash"`
+	Confirmations uint64             `json:"confirmations" bson:"confirmations"`
+	Status        string             `json:"status" bson:"status"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// BlockRef identifies a scanned block
+type BlockRef struct {
+	Number uint64 `bson:"number"`
+	Hash   string `bson:"hash"`
+}
+
+// ScanCursor is the position of the deposit scanner on a chain. Recent holds
+// the hashes of the last scanned blocks, oldest first, to detect reorgs.
+type ScanCursor struct {
+	ChainID   int64      `bson:"_id"`
+	NextBlock uint64     `bson:"next_block"`
+	Recent    []BlockRef `bson:"recent"`
+	UpdatedAt time.Time  `bson:"updated_at"`
+}
+
+// WebAuthnCredential is a passkey registered by a user. PublicKey is the
+// credential's COSE key and SignCount its last seen signature counter.
+type WebAuthnCredential struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID `json:"-" bson:"user_id"`
+	CredentialID []byte             `json:"credential_id" bson:"credential_id"`
+	PublicKey    []byte             `json:"-" bson:"public_key"`
+	Algorithm    int64              `json:"algorithm" bson:"algorithm"`
+	SignCount    uint32             `json:"-" bson:"sign_count"`
+	AAGUID       []byte             `json:"-" bson:"aaguid"`
+	Name         string             `json:"name" bson:"name"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt   *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+// SIWENonce is a nonce issued for one Sign-In with Ethereum message
+type SIWENonce struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Nonce     string             `bson:"nonce"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// WebAuthnChallenge is a pending registration or login ceremony. UserID is
+// unset for logins that did not name a user.
+type WebAuthnChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Challenge []byte             `bson:"challenge"`
+	Ceremony  string             `bson:"ceremony"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// RefreshToken is a single-use refresh token of a session. Only the SHA-256
+// hash of the token is stored. All refresh tokens of a session form one
+// family and are revoked together.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	SessionID primitive.ObjectID `bson:"session_id"`
 	UserID    primitive.ObjectID `bson:"user_id"`
-	Token     string             `bson:"token"`
+	Hash      string             `bson:"hash"`
 	ExpiresAt time.Time          `bson:"expires_at"`
-	IsActive  bool               `bson:"is_active"`
+	Used      bool               `bson:"used"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Token is a login session. TokenID is the JWT ID of the session's current
+// access token; the token itself is never stored.
+type Token struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id"`
+	TokenID   string             `json:"-" bson:"token_id"`
+	Device    string             `json:"device,omitempty" bson:"device,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent" bson:"user_agent"`
+	IssuedAt  time.Time          `json:"issued_at" bson:"issued_at"`
+	ExpiresAt time.Time          `json:"-" bson:"expires_at"`
+	// RefreshExpiresAt ends the session: it cannot be refreshed after it
+	RefreshExpiresAt time.Time  `json:"expires_at" bson:"refresh_expires_at"`
+	LastSeen         time.Time  `json:"last_seen" bson:"last_seen"`
+	IsActive         bool       `json:"-" bson:"is_active"`
+	RevokedAt        *time.Time `json:"-" bson:"revoked_at,omitempty"`
+	StepUpAt         *time.Time `json:"-" bson:"step_up_at,omitempty"`
 }
@@ -5,50 +5,76 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	config "wallet/pkg/config"
 )
 
-func Connect() (*mongo.Client, error) {
-	// Load configuration
-	cfg := config.LoadEnv()
+// DB is the long-lived client shared by the whole process and its database.
+// It is safe for concurrent use.
+type DB struct {
+	client   *mongo.Client
+	database *mongo.Database
+	timeout  time.Duration
+}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+// Open connects to MongoDB, retrying with backoff until ctx is done, and
+// checks the connection with a ping
+func Open(ctx context.Context, cfg config.DatabaseConfig) (*DB, error) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.URI).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetMinPoolSize(cfg.MinPoolSize).
+		SetMaxConnIdleTime(cfg.MaxConnIdleTime).
+		SetConnectTimeout(cfg.ConnectTimeout)
 
-	var client *mongo.Client
-	var err error
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
 
 	// Retry logic with backoff
-	for i := 0; i < 5; i++ {
-		client, err = mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI).SetConnectTimeout(10*time.Second))
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+		err = client.Ping(pingCtx, nil)
+		cancel()
 		if err == nil {
-			err = client.Ping(ctx, nil)
-			if err == nil {
-				log.Println("Successfully connected to MongoDB")
-				return client, nil
-			}
+			break
+		}
+		log.Printf("Failed to connect to MongoDB (attempt %d): %v", attempt, err)
+
+		select {
+		case <-ctx.Done():
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		case <-time.After(time.Duration(attempt) * time.Second):
 		}
-		log.Printf("Failed to connect to MongoDB (attempt %d): %v", i+1, err)
-		time.Sleep(time.Duration(2*i) * time.Second)
 	}
 
-	// If still failing after retries, return error
-	log.Fatal("Failed to connect to MongoDB after 5 attempts")
-	return nil, err
+	log.Println("Successfully connected to MongoDB")
+	return &DB{client: client, database: client.Database(cfg.Name), timeout: cfg.Timeout}, nil
 }
 
-func DisconnectClient(client *mongo.Client) error {
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// Collection returns a collection of the database
+func (db *DB) Collection(name string) *mongo.Collection {
+	return db.database.Collection(name)
+}
 
-	// Disconnect the client
-	err := client.Disconnect(ctx)
-	if err != nil {
+// Context bounds ctx by the operation timeout
+func (db *DB) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.timeout)
+}
+
+// Ping checks that the database can be reached
+func (db *DB) Ping(ctx context.Context) error {
+	return db.client.Ping(ctx, nil)
+}
+
+// Close waits for operations in use to finish and closes the connections
+func (db *DB) Close(ctx context.Context) error {
+	if err := db.client.Disconnect(ctx); err != nil {
 		log.Printf("Failed to disconnect from MongoDB: %v", err)
 		return err
 	}
@@ -0,0 +1,985 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	models "wallet/pkg/models"
+)
+
+// NewMemory returns repositories keeping their records in memory, for tests
+// and running without a database. Records are copied through BSON on the
+// way in and out, so they round-trip like they would through MongoDB and
+// callers never share them with the store.
+func NewMemory() Repositories {
+	m := &memory{
+		users:         map[primitive.ObjectID]models.User{},
+		tokens:        map[primitive.ObjectID]models.Token{},
+		refreshTokens: map[string]models.RefreshToken{},
+		credentials:   map[primitive.ObjectID]models.WebAuthnCredential{},
+		challenges:    map[primitive.ObjectID]models.WebAuthnChallenge{},
+		nonces:        map[string]models.SIWENonce{},
+		transactions:  map[primitive.ObjectID]models.Transaction{},
+		deposits:      map[primitive.ObjectID]models.Deposit{},
+		cursors:       map[int64]models.ScanCursor{},
+	}
+
+	return Repositories{
+		Users:         &memoryUsers{m},
+		Tokens:        &memoryTokens{m},
+		RefreshTokens: &memoryRefreshTokens{m},
+		Credentials:   &memoryCredentials{m},
+		Challenges:    &memoryChallenges{m},
+		Nonces:        &memoryNonces{m},
+		Transactions:  &memoryTransactions{m},
+		Deposits:      &memoryDeposits{m},
+	}
+}
+
+// memory holds every record type behind one lock, so updates spanning
+// records are as atomic as their MongoDB counterparts
+type memory struct {
+	mu            sync.Mutex
+	users         map[primitive.ObjectID]models.User
+	tokens        map[primitive.ObjectID]models.Token
+	refreshTokens map[string]models.RefreshToken
+	credentials   map[primitive.ObjectID]models.WebAuthnCredential
+	challenges    map[primitive.ObjectID]models.WebAuthnChallenge
+	nonces        map[string]models.SIWENonce
+	transactions  map[primitive.ObjectID]models.Transaction
+	deposits      map[primitive.ObjectID]models.Deposit
+	cursors       map[int64]models.ScanCursor
+}
+
+// clone deep-copies a record through BSON. Every record type marshals, so
+// failing to is a programming error.
+func clone[T any](v T) T {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	var c T
+	if err := bson.Unmarshal(data, &c); err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// sortedByID returns the records of a map ordered by ID, which follows
+// insertion order like a MongoDB collection scan
+func sortedByID[T any](records map[primitive.ObjectID]T) []T {
+	ids := make([]primitive.ObjectID, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	sorted := make([]T, 0, len(ids))
+	for _, id := range ids {
+		sorted = append(sorted, clone(records[id]))
+	}
+
+	return sorted
+}
+
+// memoryUsers is the in-memory UserRepository
+type memoryUsers struct {
+	*memory
+}
+
+func (r *memoryUsers) Create(ctx context.Context, userData models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if userData.ID.IsZero() {
+		userData.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.users[userData.ID]; ok {
+		return ErrDuplicate
+	}
+	if err := r.violatesUnique(userData); err != nil {
+		return err
+	}
+	r.users[userData.ID] = clone(userData)
+
+	return nil
+}
+
+// violatesUnique returns ErrUsernameTaken or ErrDuplicate if another user
+// has the username, the email or a wallet address of userData, like the
+// unique indexes of the users collection
+func (r *memoryUsers) violatesUnique(userData models.User) error {
+	for id, other := range r.users {
+		if id == userData.ID {
+			continue
+		}
+		if userData.Username != "" && strings.EqualFold(other.Username, userData.Username) {
+			return ErrUsernameTaken
+		}
+		if userData.Email != "" && other.Email == userData.Email {
+			return ErrDuplicate
+		}
+		for _, account := range userData.Wallet.Accounts {
+			if ownsAddress(other, account.Address) {
+				return ErrDuplicate
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryUsers) Delete(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, userID)
+	return nil
+}
+
+// find returns the first user, in ID order, that match accepts
+func (r *memoryUsers) find(match func(models.User) bool) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, userData := range sortedByID(r.users) {
+		if match(userData) {
+			return userData, nil
+		}
+	}
+
+	return models.User{}, ErrNotFound
+}
+
+func (r *memoryUsers) FindByID(ctx context.Context, userID primitive.ObjectID) (models.User, error) {
+	return r.find(func(userData models.User) bool { return userData.ID == userID })
+}
+
+func (r *memoryUsers) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	email = models.NormalizeEmail(email)
+	return r.find(func(userData models.User) bool { return userData.Email == email })
+}
+
+func (r *memoryUsers) FindByAddress(ctx context.Context, address string) (models.User, error) {
+	return r.find(func(userData models.User) bool { return ownsAddress(userData, address) })
+}
+
+func (r *memoryUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := r.FindByEmail(ctx, email)
+	return err == nil, nil
+}
+
+func (r *memoryUsers) UsernameTaken(ctx context.Context, username string, except primitive.ObjectID) (bool, error) {
+	_, err := r.find(func(userData models.User) bool {
+		return userData.ID != except && strings.EqualFold(userData.Username, username)
+	})
+	return err == nil, nil
+}
+
+func (r *memoryUsers) ListActive(ctx context.Context) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []models.User{}
+	for _, userData := range sortedByID(r.users) {
+		if userData.Active {
+			users = append(users, userData)
+		}
+	}
+
+	return users, nil
+}
+
+func (r *memoryUsers) Each(ctx context.Context, fn func(models.User) error) error {
+	// fn may update users, so it runs on a snapshot without the lock
+	r.mu.Lock()
+	users := sortedByID(r.users)
+	r.mu.Unlock()
+
+	for _, userData := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(userData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update applies fn to a stored user. fn returns an error to leave the user
+// unchanged; missing is returned if there is no such user.
+func (r *memoryUsers) update(userID primitive.ObjectID, missing error, fn func(userData *models.User) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userID]
+	if !ok {
+		return missing
+	}
+
+	userData := clone(stored)
+	if err := fn(&userData); err != nil {
+		return err
+	}
+	r.users[userID] = clone(userData)
+
+	return nil
+}
+
+func (r *memoryUsers) SetBalances(ctx context.Context, userID primitive.ObjectID, chainID int64, balances []models.Balance) error {
+	return r.update(userID, nil, func(userData *models.User) error {
+		kept := []models.Balance{}
+		for _, balance := range userData.Balances {
+			if balance.ChainID != chainID {
+				kept = append(kept, balance)
+			}
+		}
+		userData.Balances = append(kept, balances...)
+
+		for _, id := range userData.Wallet.ChainIDs {
+			if id == chainID {
+				return nil
+			}
+		}
+		userData.Wallet.ChainIDs = append(userData.Wallet.ChainIDs, chainID)
+
+		return nil
+	})
+}
+
+func (r *memoryUsers) ReplaceWalletSecret(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		if userData.Wallet.Secret != old {
+			return ErrConflict
+		}
+		userData.Wallet.Secret = secret
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) AddWalletAccount(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret, account models.WalletAccount) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		if userData.Wallet.Secret != old || userData.Wallet.NextIndex != account.Index {
+			return ErrConflict
+		}
+		userData.Wallet.Accounts = append(userData.Wallet.Accounts, account)
+		userData.Wallet.NextIndex = account.Index + 1
+		userData.Wallet.Secret = secret
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) WalletOwners(ctx context.Context) (map[string]primitive.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners := map[string]primitive.ObjectID{}
+	for id, userData := range r.users {
+		if !userData.Active {
+			continue
+		}
+		for _, account := range userData.Wallet.Accounts {
+			owners[account.Address] = id
+		}
+	}
+
+	return owners, nil
+}
+
+func (r *memoryUsers) SetUsername(ctx context.Context, userID primitive.ObjectID, username string) error {
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		if err := r.violatesUnique(models.User{ID: userID, Username: username}); err != nil {
+			return err
+		}
+		userData.Username = username
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) SetPassword(ctx context.Context, userID primitive.ObjectID, hash string) error {
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		userData.Password = hash
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) ReplacePassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		if userData.Password != old {
+			return ErrConflict
+		}
+		userData.Password = hash
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) SetEmail(ctx context.Context, userID primitive.ObjectID, email string) error {
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		if err := r.violatesUnique(models.User{ID: userID, Email: email}); err != nil {
+			return err
+		}
+		userData.Email = email
+		userData.PendingVerification = true
+		userData.EmailVerifiedAt = nil
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string, at time.Time) error {
+	return r.update(userID, nil, func(userData *models.User) error {
+		if userData.Email != email || !userData.PendingVerification {
+			return nil
+		}
+		userData.PendingVerification = false
+		userData.EmailVerifiedAt = &at
+		userData.UpdatedAt = at
+		return nil
+	})
+}
+
+func (r *memoryUsers) SetRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		userData.Role = role
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) Deactivate(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(userID, nil, func(userData *models.User) error {
+		userData.Active = false
+		return nil
+	})
+}
+
+func (r *memoryUsers) EnrollTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		if userData.TOTP != nil && userData.TOTP.Enabled {
+			return ErrConflict
+		}
+		userData.TOTP = &models.TOTPConfig{Secret: secret}
+		return nil
+	})
+}
+
+func (r *memoryUsers) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret, step int64, recoveryCodes []string, at time.Time) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		totp := userData.TOTP
+		if totp == nil || totp.Enabled || totp.Secret != secret {
+			return ErrConflict
+		}
+		totp.Enabled = true
+		totp.EnabledAt = &at
+		totp.LastStep = step
+		totp.RecoveryCodes = recoveryCodes
+		userData.UpdatedAt = at
+		return nil
+	})
+}
+
+func (r *memoryUsers) DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(userID, nil, func(userData *models.User) error {
+		userData.TOTP = nil
+		userData.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryUsers) AdvanceTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		totp := userData.TOTP
+		if totp == nil || totp.LastStep >= step || totpLocked(totp) {
+			return ErrConflict
+		}
+		totp.LastStep = step
+		totp.Failures = 0
+		totp.LockedUntil = nil
+		return nil
+	})
+}
+
+func (r *memoryUsers) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) error {
+	return r.update(userID, ErrConflict, func(userData *models.User) error {
+		if userData.TOTP == nil || totpLocked(userData.TOTP) {
+			return ErrConflict
+		}
+
+		totp := userData.TOTP
+		remaining := []string{}
+		for _, code := range totp.RecoveryCodes {
+			if code != hash {
+				remaining = append(remaining, code)
+			}
+		}
+		if len(remaining) == len(totp.RecoveryCodes) {
+			return ErrConflict
+		}
+		totp.RecoveryCodes = remaining
+		totp.Failures = 0
+		totp.LockedUntil = nil
+		return nil
+	})
+}
+
+func (r *memoryUsers) ClearTOTPFailures(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(userID, nil, func(userData *models.User) error {
+		if userData.TOTP != nil && !totpLocked(userData.TOTP) {
+			userData.TOTP.Failures = 0
+		}
+		return nil
+	})
+}
+
+func (r *memoryUsers) RecordTOTPFailure(ctx context.Context, userID primitive.ObjectID, maxFailures int, lockedUntil time.Time) (int, error) {
+	var failures int
+	err := r.update(userID, ErrNotFound, func(userData *models.User) error {
+		if userData.TOTP == nil {
+			userData.TOTP = &models.TOTPConfig{}
+		}
+		failures = userData.TOTP.Failures + 1
+		if failures >= maxFailures {
+			userData.TOTP.Failures = 0
+			userData.TOTP.LockedUntil = &lockedUntil
+		} else {
+			userData.TOTP.Failures = failures
+		}
+		return nil
+	})
+
+	return failures, err
+}
+
+// totpLocked reports whether the second factor is locked now
+func totpLocked(totp *models.TOTPConfig) bool {
+	return totp.LockedUntil != nil && totp.LockedUntil.After(time.Now())
+}
+
+func (r *memoryUsers) LinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error {
+	if _, err := r.FindByAddress(ctx, address); err == nil {
+		return ErrDuplicate
+	}
+
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		userData.EthereumAddresses = append(userData.EthereumAddresses, address)
+		return nil
+	})
+}
+
+func (r *memoryUsers) UnlinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error {
+	return r.update(userID, ErrNotFound, func(userData *models.User) error {
+		remaining := []string{}
+		for _, linked := range userData.EthereumAddresses {
+			if linked != address {
+				remaining = append(remaining, linked)
+			}
+		}
+		if len(remaining) == len(userData.EthereumAddresses) {
+			return ErrNotFound
+		}
+		userData.EthereumAddresses = remaining
+		return nil
+	})
+}
+
+// ownsAddress reports whether address is a wallet account or a linked
+// address of the user
+func ownsAddress(userData models.User, address string) bool {
+	for _, account := range userData.Wallet.Accounts {
+		if account.Address == address {
+			return true
+		}
+	}
+	for _, linked := range userData.EthereumAddresses {
+		if linked == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// memoryTokens is the in-memory TokenRepository
+type memoryTokens struct {
+	*memory
+}
+
+func (r *memoryTokens) Create(ctx context.Context, session models.Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.tokens[session.ID]; ok {
+		return ErrDuplicate
+	}
+	r.tokens[session.ID] = clone(session)
+
+	return nil
+}
+
+func (r *memoryTokens) FindByID(ctx context.Context, id primitive.ObjectID) (models.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.tokens[id]
+	if !ok {
+		return models.Token{}, ErrNotFound
+	}
+
+	return clone(session), nil
+}
+
+func (r *memoryTokens) FindByTokenID(ctx context.Context, tokenID string) (models.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, session := range sortedByID(r.tokens) {
+		if session.TokenID == tokenID {
+			return session, nil
+		}
+	}
+
+	return models.Token{}, ErrNotFound
+}
+
+func (r *memoryTokens) ListActive(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := []models.Token{}
+	for _, session := range sortedByID(r.tokens) {
+		if session.UserID == userID && session.IsActive && session.RefreshExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+
+	return sessions, nil
+}
+
+// update applies fn to a stored session; see memoryUsers.update
+func (r *memoryTokens) update(id primitive.ObjectID, missing error, fn func(session *models.Token) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.tokens[id]
+	if !ok {
+		return missing
+	}
+
+	session := clone(stored)
+	if err := fn(&session); err != nil {
+		return err
+	}
+	r.tokens[id] = clone(session)
+
+	return nil
+}
+
+func (r *memoryTokens) Rotate(ctx context.Context, id primitive.ObjectID, tokenID string, expiresAt time.Time, at time.Time) error {
+	return r.update(id, ErrNotFound, func(session *models.Token) error {
+		if !session.IsActive {
+			return ErrNotFound
+		}
+		session.TokenID = tokenID
+		session.ExpiresAt = expiresAt
+		session.LastSeen = at
+		return nil
+	})
+}
+
+func (r *memoryTokens) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(id, nil, func(session *models.Token) error {
+		session.LastSeen = at
+		return nil
+	})
+}
+
+func (r *memoryTokens) MarkStepUp(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(id, nil, func(session *models.Token) error {
+		if session.IsActive {
+			session.StepUpAt = &at
+		}
+		return nil
+	})
+}
+
+func (r *memoryTokens) Revoke(ctx context.Context, filter SessionFilter, at time.Time) (int64, error) {
+	return r.deactivate(func(session models.Token) bool {
+		return (filter.ID.IsZero() || session.ID == filter.ID) &&
+			(filter.UserID.IsZero() || session.UserID == filter.UserID) &&
+			session.ID != filter.Except
+	}, &at)
+}
+
+func (r *memoryTokens) Expire(ctx context.Context, now time.Time) (int64, error) {
+	return r.deactivate(func(session models.Token) bool {
+		return session.ExpiresAt.Before(now) && !session.RefreshExpiresAt.After(now)
+	}, nil)
+}
+
+// deactivate ends the active sessions that match accepts, recording
+// revokedAt if it is set
+func (r *memoryTokens) deactivate(match func(models.Token) bool, revokedAt *time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for id, session := range r.tokens {
+		if !session.IsActive || !match(session) {
+			continue
+		}
+		session = clone(session)
+		session.IsActive = false
+		if revokedAt != nil {
+			session.RevokedAt = revokedAt
+		}
+		r.tokens[id] = clone(session)
+		count++
+	}
+
+	return count, nil
+}
+
+// memoryRefreshTokens is the in-memory RefreshTokenRepository
+type memoryRefreshTokens struct {
+	*memory
+}
+
+func (r *memoryRefreshTokens) Create(ctx context.Context, token models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.refreshTokens[token.Hash]; ok {
+		return ErrDuplicate
+	}
+	r.refreshTokens[token.Hash] = clone(token)
+
+	return nil
+}
+
+func (r *memoryRefreshTokens) Claim(ctx context.Context, hash string, now time.Time) (models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[hash]
+	if !ok || token.Used || !token.ExpiresAt.After(now) {
+		return models.RefreshToken{}, ErrNotFound
+	}
+
+	token = clone(token)
+	token.Used = true
+	token.UsedAt = &now
+	r.refreshTokens[hash] = clone(token)
+
+	return clone(token), nil
+}
+
+func (r *memoryRefreshTokens) FindByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[hash]
+	if !ok {
+		return models.RefreshToken{}, ErrNotFound
+	}
+
+	return clone(token), nil
+}
+
+// memoryCredentials is the in-memory CredentialRepository
+type memoryCredentials struct {
+	*memory
+}
+
+func (r *memoryCredentials) Create(ctx context.Context, credential models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if credential.ID.IsZero() {
+		credential.ID = primitive.NewObjectID()
+	}
+	for id, stored := range r.credentials {
+		if id == credential.ID || bytes.Equal(stored.CredentialID, credential.CredentialID) {
+			return ErrDuplicate
+		}
+	}
+	r.credentials[credential.ID] = clone(credential)
+
+	return nil
+}
+
+func (r *memoryCredentials) FindByCredentialID(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, credential := range r.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return clone(credential), nil
+		}
+	}
+
+	return models.WebAuthnCredential{}, ErrNotFound
+}
+
+func (r *memoryCredentials) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credentials := []models.WebAuthnCredential{}
+	for _, credential := range sortedByID(r.credentials) {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	sort.SliceStable(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+
+	return credentials, nil
+}
+
+func (r *memoryCredentials) UpdateSignCount(ctx context.Context, id primitive.ObjectID, old uint32, signCount uint32, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok || credential.SignCount != old {
+		return ErrConflict
+	}
+
+	credential = clone(credential)
+	credential.SignCount = signCount
+	credential.LastUsedAt = &at
+	r.credentials[id] = clone(credential)
+
+	return nil
+}
+
+func (r *memoryCredentials) Delete(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok || credential.UserID != userID {
+		return ErrNotFound
+	}
+	delete(r.credentials, id)
+
+	return nil
+}
+
+// memoryChallenges is the in-memory ChallengeRepository
+type memoryChallenges struct {
+	*memory
+}
+
+func (r *memoryChallenges) Create(ctx context.Context, challenge models.WebAuthnChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if challenge.ID.IsZero() {
+		challenge.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.challenges[challenge.ID]; ok {
+		return ErrDuplicate
+	}
+	r.challenges[challenge.ID] = clone(challenge)
+
+	return nil
+}
+
+func (r *memoryChallenges) Take(ctx context.Context, id primitive.ObjectID, ceremony string, now time.Time) (models.WebAuthnChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[id]
+	if !ok || challenge.Ceremony != ceremony || !challenge.ExpiresAt.After(now) {
+		return models.WebAuthnChallenge{}, ErrNotFound
+	}
+	delete(r.challenges, id)
+
+	return clone(challenge), nil
+}
+
+// memoryNonces is the in-memory NonceRepository
+type memoryNonces struct {
+	*memory
+}
+
+func (r *memoryNonces) Create(ctx context.Context, nonce models.SIWENonce) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nonces[nonce.Nonce]; ok {
+		return ErrDuplicate
+	}
+	r.nonces[nonce.Nonce] = clone(nonce)
+
+	return nil
+}
+
+func (r *memoryNonces) Take(ctx context.Context, nonce string, now time.Time) (models.SIWENonce, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.nonces[nonce]
+	if !ok || !record.ExpiresAt.After(now) {
+		return models.SIWENonce{}, ErrNotFound
+	}
+	delete(r.nonces, nonce)
+
+	return clone(record), nil
+}
+
+// memoryTransactions is the in-memory TransactionRepository
+type memoryTransactions struct {
+	*memory
+}
+
+func (r *memoryTransactions) Create(ctx context.Context, record models.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record.ID.IsZero() {
+		record.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.transactions[record.ID]; ok {
+		return ErrDuplicate
+	}
+	r.transactions[record.ID] = clone(record)
+
+	return nil
+}
+
+func (r *memoryTransactions) SetStatus(ctx context.Context, id primitive.ObjectID, status, errorMessage string, updatedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.transactions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	record.Status = status
+	record.Error = errorMessage
+	record.UpdatedAt = updatedAt
+	r.transactions[id] = record
+
+	return nil
+}
+
+// memoryDeposits is the in-memory DepositRepository
+type memoryDeposits struct {
+	*memory
+}
+
+func (r *memoryDeposits) LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cursor, ok := r.cursors[chainID]
+	if !ok {
+		return models.ScanCursor{}, false, nil
+	}
+
+	return clone(cursor), true, nil
+}
+
+func (r *memoryDeposits) SaveCursor(ctx context.Context, cursor models.ScanCursor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cursors[cursor.ChainID] = clone(cursor)
+
+	return nil
+}
+
+func (r *memoryDeposits) SaveDeposit(ctx context.Context, deposit models.Deposit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, stored := range r.deposits {
+		if stored.ChainID == deposit.ChainID && stored.TxHash == deposit.TxHash && stored.LogIndex == deposit.LogIndex {
+			deposit.ID = id
+			deposit.CreatedAt = stored.CreatedAt
+			r.deposits[id] = clone(deposit)
+			return nil
+		}
+	}
+
+	deposit.ID = primitive.NewObjectID()
+	r.deposits[deposit.ID] = clone(deposit)
+
+	return nil
+}
+
+func (r *memoryDeposits) RollBack(ctx context.Context, chainID int64, fromBlock uint64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, deposit := range r.deposits {
+		if deposit.ChainID == chainID && deposit.BlockNumber >= fromBlock && deposit.Status == models.DepositStatusPending {
+			deposit.Status = models.DepositStatusReorged
+			deposit.UpdatedAt = at
+			r.deposits[id] = deposit
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryDeposits) Confirm(ctx context.Context, chainID int64, head uint64, required uint64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, deposit := range r.deposits {
+		if deposit.ChainID != chainID || deposit.Status != models.DepositStatusPending {
+			continue
+		}
+		deposit.Confirmations = head - deposit.BlockNumber + 1
+		if deposit.Confirmations >= required {
+			deposit.Status = models.DepositStatusConfirmed
+		}
+		deposit.UpdatedAt = at
+		r.deposits[id] = deposit
+	}
+
+	return nil
+}
+
+func (r *memoryDeposits) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.Deposit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deposits := []models.Deposit{}
+	for _, deposit := range sortedByID(r.deposits) {
+		if deposit.UserID == userID && deposit.Status != models.DepositStatusReorged {
+			deposits = append(deposits, deposit)
+		}
+	}
+	sort.SliceStable(deposits, func(i, j int) bool { return deposits[i].BlockNumber > deposits[j].BlockNumber })
+	if len(deposits) > limit {
+		deposits = deposits[:limit]
+	}
+
+	return deposits, nil
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	models "wallet/pkg/models"
+)
+
+func TestMemoryUsers(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemory().Users
+
+	alice := models.User{ID: primitive.NewObjectID(), Username: "Alice", Email: "alice@example.com", Password: "old", Active: true}
+	if err := users.Create(ctx, alice); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := users.Create(ctx, alice); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for a second insert, got %v", err)
+	}
+	twin := models.User{ID: primitive.NewObjectID(), Email: alice.Email}
+	if err := users.Create(ctx, twin); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for a taken email, got %v", err)
+	}
+
+	if _, err := users.FindByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown user, got %v", err)
+	}
+
+	taken, err := users.UsernameTaken(ctx, "alice", primitive.NilObjectID)
+	if err != nil || !taken {
+		t.Errorf("expected username to be taken ignoring case, got %v, %v", taken, err)
+	}
+	taken, _ = users.UsernameTaken(ctx, "alice", alice.ID)
+	if taken {
+		t.Error("a user's own username must not count as taken")
+	}
+
+	if err := users.ReplacePassword(ctx, alice.ID, "stale", "new"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale password, got %v", err)
+	}
+	if err := users.ReplacePassword(ctx, alice.ID, "old", "new"); err != nil {
+		t.Fatalf("ReplacePassword failed: %v", err)
+	}
+
+	// Records returned must not alias the stored ones
+	stored, _ := users.FindByID(ctx, alice.ID)
+	stored.Password = "tampered"
+	if again, _ := users.FindByID(ctx, alice.ID); again.Password != "new" {
+		t.Errorf("expected stored password %q, got %q", "new", again.Password)
+	}
+
+	address := "0x000000000000000000000000000000000000dEaD"
+	if err := users.LinkAddress(ctx, alice.ID, address); err != nil {
+		t.Fatalf("LinkAddress failed: %v", err)
+	}
+	bob := models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Active: true}
+	users.Create(ctx, bob)
+	if err := users.SetEmail(ctx, bob.ID, alice.Email); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate moving to a taken email, got %v", err)
+	}
+	if err := users.SetUsername(ctx, bob.ID, "ALICE"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("expected ErrUsernameTaken for a taken username, got %v", err)
+	}
+	carol := models.User{ID: primitive.NewObjectID(), Username: "alice", Email: "carol@example.com"}
+	if err := users.Create(ctx, carol); !errors.Is(err, ErrUsernameTaken) || !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrUsernameTaken for a taken username, got %v", err)
+	}
+	if err := users.LinkAddress(ctx, bob.ID, address); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate for an address linked twice, got %v", err)
+	}
+	if found, err := users.FindByAddress(ctx, address); err != nil || found.ID != alice.ID {
+		t.Errorf("expected the address to find alice, got %v, %v", found.ID, err)
+	}
+
+	users.Deactivate(ctx, bob.ID)
+	active, err := users.ListActive(ctx)
+	if err != nil || len(active) != 1 || active[0].ID != alice.ID {
+		t.Errorf("expected only alice to be active, got %v, %v", active, err)
+	}
+}
+
+func TestMemoryTokens(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	now := time.Now()
+
+	userID := primitive.NewObjectID()
+	current := models.Token{ID: primitive.NewObjectID(), UserID: userID, TokenID: "a", ExpiresAt: now.Add(time.Minute), RefreshExpiresAt: now.Add(time.Hour), IsActive: true}
+	other := models.Token{ID: primitive.NewObjectID(), UserID: userID, TokenID: "b", ExpiresAt: now.Add(time.Minute), RefreshExpiresAt: now.Add(time.Hour), IsActive: true}
+	repos.Tokens.Create(ctx, current)
+	repos.Tokens.Create(ctx, other)
+
+	revoked, err := repos.Tokens.Revoke(ctx, SessionFilter{UserID: userID, Except: current.ID}, now)
+	if err != nil || revoked != 1 {
+		t.Fatalf("expected one session revoked, got %d, %v", revoked, err)
+	}
+	if err := repos.Tokens.Rotate(ctx, other.ID, "c", now.Add(time.Minute), now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound rotating a revoked session, got %v", err)
+	}
+	if err := repos.Tokens.Rotate(ctx, current.ID, "c", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if session, err := repos.Tokens.FindByTokenID(ctx, "c"); err != nil || session.ID != current.ID {
+		t.Errorf("expected the rotated token ID to find the session, got %v, %v", session.ID, err)
+	}
+
+	repos.RefreshTokens.Create(ctx, models.RefreshToken{ID: primitive.NewObjectID(), SessionID: current.ID, Hash: "h", ExpiresAt: now.Add(time.Hour)})
+	if _, err := repos.RefreshTokens.Claim(ctx, "h", now); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if _, err := repos.RefreshTokens.Claim(ctx, "h", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound claiming a refresh token twice, got %v", err)
+	}
+}
+
+func TestMemoryTake(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	now := time.Now()
+
+	repos.Nonces.Create(ctx, models.SIWENonce{Nonce: "fresh", ExpiresAt: now.Add(time.Minute)})
+	repos.Nonces.Create(ctx, models.SIWENonce{Nonce: "stale", ExpiresAt: now.Add(-time.Minute)})
+
+	if _, err := repos.Nonces.Take(ctx, "fresh", now); err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	for _, nonce := range []string{"fresh", "stale", "unknown"} {
+		if _, err := repos.Nonces.Take(ctx, nonce, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", nonce, err)
+		}
+	}
+
+	challenge := models.WebAuthnChallenge{ID: primitive.NewObjectID(), Ceremony: "login", ExpiresAt: now.Add(time.Minute)}
+	repos.Challenges.Create(ctx, challenge)
+	if _, err := repos.Challenges.Take(ctx, challenge.ID, "registration", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a challenge of another ceremony to be refused, got %v", err)
+	}
+	if _, err := repos.Challenges.Take(ctx, challenge.ID, "login", now); err != nil {
+		t.Errorf("Take failed: %v", err)
+	}
+}
+
+func TestMemoryTransactionStatus(t *testing.T) {
+	ctx := context.Background()
+	transactions := NewMemory().Transactions
+
+	record := models.Transaction{ID: primitive.NewObjectID(), Status: models.TxStatusPending}
+	if err := transactions.Create(ctx, record); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := transactions.SetStatus(ctx, record.ID, models.TxStatusFailed, "nonce too low", time.Now()); err != nil {
+		t.Errorf("SetStatus failed: %v", err)
+	}
+	if err := transactions.SetStatus(ctx, primitive.NewObjectID(), models.TxStatusFailed, "", time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown transaction, got %v", err)
+	}
+}
+
+func TestMemoryTOTPLockout(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemory().Users
+
+	alice := models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", TOTP: &models.TOTPConfig{Enabled: true, RecoveryCodes: []string{"hash"}}}
+	if err := users.Create(ctx, alice); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	lockedUntil := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	for want := 1; want <= 3; want++ {
+		failures, err := users.RecordTOTPFailure(ctx, alice.ID, 3, lockedUntil)
+		if err != nil || failures != want {
+			t.Fatalf("RecordTOTPFailure = %d, %v, want %d", failures, err, want)
+		}
+	}
+
+	stored, _ := users.FindByID(ctx, alice.ID)
+	if stored.TOTP.Failures != 0 || stored.TOTP.LockedUntil == nil || !stored.TOTP.LockedUntil.Equal(lockedUntil) {
+		t.Errorf("expected the third failure to lock, got %+v", stored.TOTP)
+	}
+
+	if err := users.AdvanceTOTPStep(ctx, alice.ID, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a step while locked, got %v", err)
+	}
+	if err := users.UseRecoveryCode(ctx, alice.ID, "hash"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a recovery code while locked, got %v", err)
+	}
+}
+
+func TestMemoryDeposits(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+
+	alice := models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Active: true}
+	alice.Wallet.Accounts = []models.WalletAccount{{Address: "0x000000000000000000000000000000000000dEaD"}}
+	if err := repos.Users.Create(ctx, alice); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	owners, err := repos.Users.WalletOwners(ctx)
+	if err != nil || owners[alice.Wallet.Accounts[0].Address] != alice.ID {
+		t.Errorf("expected alice to own her wallet address, got %v, %v", owners, err)
+	}
+
+	if _, found, err := repos.Deposits.LoadCursor(ctx, 1); found || err != nil {
+		t.Errorf("expected no cursor before the first scan, got %v, %v", found, err)
+	}
+	cursor := models.ScanCursor{ChainID: 1, NextBlock: 12}
+	if err := repos.Deposits.SaveCursor(ctx, cursor); err != nil {
+		t.Fatalf("SaveCursor failed: %v", err)
+	}
+	if stored, found, _ := repos.Deposits.LoadCursor(ctx, 1); !found || stored.NextBlock != 12 {
+		t.Errorf("expected the saved cursor, got %+v", stored)
+	}
+
+	first := models.Deposit{ChainID: 1, UserID: alice.ID, TxHash: "0x01", LogIndex: -1, BlockNumber: 10, Status: models.DepositStatusPending}
+	second := models.Deposit{ChainID: 1, UserID: alice.ID, TxHash: "0x02", LogIndex: 0, BlockNumber: 11, Status: models.DepositStatusPending}
+	for _, deposit := range []models.Deposit{first, second, first} {
+		if err := repos.Deposits.SaveDeposit(ctx, deposit); err != nil {
+			t.Fatalf("SaveDeposit failed: %v", err)
+		}
+	}
+
+	if err := repos.Deposits.RollBack(ctx, 1, 11, time.Now()); err != nil {
+		t.Fatalf("RollBack failed: %v", err)
+	}
+	if err := repos.Deposits.Confirm(ctx, 1, 11, 2, time.Now()); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	deposits, err := repos.Deposits.ListByUser(ctx, alice.ID, 10)
+	if err != nil {
+		t.Fatalf("ListByUser failed: %v", err)
+	}
+	if len(deposits) != 1 || deposits[0].TxHash != first.TxHash {
+		t.Fatalf("expected only the deposit that was not reorged, got %+v", deposits)
+	}
+	if deposits[0].Confirmations != 2 || deposits[0].Status != models.DepositStatusConfirmed {
+		t.Errorf("expected the deposit to be confirmed twice, got %+v", deposits[0])
+	}
+}
@@ -0,0 +1,879 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	models "wallet/pkg/models"
+	mongodb "wallet/pkg/mongo"
+)
+
+// NewMongo returns repositories storing their records in db, one collection
+// per record type
+func NewMongo(db *mongodb.DB) Repositories {
+	return Repositories{
+		Users:         &mongoUsers{db: db},
+		Tokens:        &mongoTokens{db: db},
+		RefreshTokens: &mongoRefreshTokens{db: db},
+		Credentials:   &mongoCredentials{db: db},
+		Challenges:    &mongoChallenges{db: db},
+		Nonces:        &mongoNonces{db: db},
+		Transactions:  &mongoTransactions{db: db},
+		Deposits:      &mongoDeposits{db: db},
+	}
+}
+
+// findOne decodes the document matching filter into v
+func findOne(ctx context.Context, collection *mongo.Collection, filter bson.M, v interface{}) error {
+	err := collection.FindOne(ctx, filter).Decode(v)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// usernameIndex is the unique index on usernames, created by the
+// username-index migration
+const usernameIndex = "username_unique"
+
+// duplicateError reports a unique index violation as ErrUsernameTaken or
+// ErrDuplicate, and returns other errors unchanged
+func duplicateError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	if strings.Contains(err.Error(), usernameIndex) {
+		return ErrUsernameTaken
+	}
+
+	return ErrDuplicate
+}
+
+// insertOne inserts a document, reporting unique index violations with
+// duplicateError
+func insertOne(ctx context.Context, collection *mongo.Collection, document interface{}) error {
+	_, err := collection.InsertOne(ctx, document)
+	return duplicateError(err)
+}
+
+// updateOne applies update to the document matching filter and returns
+// missing if there is none. Unique index violations are reported with
+// duplicateError.
+func updateOne(ctx context.Context, collection *mongo.Collection, filter bson.M, update interface{}, missing error) error {
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return duplicateError(err)
+	}
+	if missing != nil && result.MatchedCount == 0 {
+		return missing
+	}
+
+	return nil
+}
+
+// mongoUsers stores users in the "users" collection
+type mongoUsers struct {
+	db *mongodb.DB
+}
+
+func (r *mongoUsers) collection() *mongo.Collection {
+	return r.db.Collection("users")
+}
+
+func (r *mongoUsers) Create(ctx context.Context, userData models.User) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.collection(), userData)
+}
+
+func (r *mongoUsers) Delete(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	_, err := r.collection().DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+func (r *mongoUsers) find(ctx context.Context, filter bson.M) (models.User, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var userData models.User
+	if err := findOne(ctx, r.collection(), filter, &userData); err != nil {
+		return models.User{}, err
+	}
+
+	return userData, nil
+}
+
+func (r *mongoUsers) FindByID(ctx context.Context, userID primitive.ObjectID) (models.User, error) {
+	return r.find(ctx, bson.M{"_id": userID})
+}
+
+func (r *mongoUsers) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return r.find(ctx, bson.M{"email": models.NormalizeEmail(email)})
+}
+
+func (r *mongoUsers) FindByAddress(ctx context.Context, address string) (models.User, error) {
+	return r.find(ctx, bson.M{"$or": addressFilter(address)})
+}
+
+func (r *mongoUsers) count(ctx context.Context, filter bson.M, opts ...*options.CountOptions) (bool, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	count, err := r.collection().CountDocuments(ctx, filter, opts...)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *mongoUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	return r.count(ctx, bson.M{"email": models.NormalizeEmail(email)})
+}
+
+func (r *mongoUsers) UsernameTaken(ctx context.Context, username string, except primitive.ObjectID) (bool, error) {
+	return r.count(ctx,
+		bson.M{"username": username, "_id": bson.M{"$ne": except}},
+		options.Count().SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	)
+}
+
+// ListActive and Each are not bounded by the operation timeout, since they
+// walk the whole collection; ctx bounds them instead
+func (r *mongoUsers) ListActive(ctx context.Context) ([]models.User, error) {
+	cursor, err := r.collection().Find(ctx, bson.M{"active": true})
+	if err != nil {
+		return nil, err
+	}
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *mongoUsers) Each(ctx context.Context, fn func(models.User) error) error {
+	cursor, err := r.collection().Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var userData models.User
+		if err := cursor.Decode(&userData); err != nil {
+			return fmt.Errorf("user %v: %w", cursor.Current.Lookup("_id"), err)
+		}
+		if err := fn(userData); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (r *mongoUsers) update(ctx context.Context, filter bson.M, update interface{}, missing error) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return updateOne(ctx, r.collection(), filter, update, missing)
+}
+
+func (r *mongoUsers) SetBalances(ctx context.Context, userID primitive.ObjectID, chainID int64, balances []models.Balance) error {
+	if balances == nil {
+		balances = []models.Balance{}
+	}
+
+	// A pipeline update swaps the chain's balances in a single atomic write
+	return r.update(ctx, bson.M{"_id": userID}, bson.A{bson.M{"$set": bson.M{
+		"balances": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$balances", bson.A{}}},
+				"cond":  bson.M{"$ne": bson.A{"$$this.chain_id", chainID}},
+			}},
+			balances,
+		}},
+		"wallet.chain_ids": bson.M{"$setUnion": bson.A{
+			bson.M{"$ifNull": bson.A{"$wallet.chain_ids", bson.A{}}},
+			bson.A{chainID},
+		}},
+	}}}, nil)
+}
+
+func (r *mongoUsers) ReplaceWalletSecret(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "wallet.secret": old},
+		bson.M{"$set": bson.M{"wallet.secret": secret, "updated_at": time.Now()}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) AddWalletAccount(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret, account models.WalletAccount) error {
+	// Matching the next index keeps concurrent derivations from taking the
+	// same account twice
+	return r.update(ctx,
+		bson.M{"_id": userID, "wallet.secret": old, "wallet.next_index": account.Index},
+		bson.M{
+			"$push": bson.M{"wallet.accounts": account},
+			"$set":  bson.M{"wallet.next_index": account.Index + 1, "wallet.secret": secret, "updated_at": time.Now()},
+		},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) WalletOwners(ctx context.Context) (map[string]primitive.ObjectID, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	cursor, err := r.collection().Find(ctx,
+		bson.M{"active": true},
+		options.Find().SetProjection(bson.M{"wallet.accounts.address": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	owners := map[string]primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Wallet struct {
+				Accounts []models.WalletAccount `bson:"accounts"`
+			} `bson:"wallet"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		for _, account := range doc.Wallet.Accounts {
+			owners[account.Address] = doc.ID
+		}
+	}
+
+	return owners, cursor.Err()
+}
+
+func (r *mongoUsers) SetUsername(ctx context.Context, userID primitive.ObjectID, username string) error {
+	return r.update(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"username": username, "updated_at": time.Now()}}, ErrNotFound)
+}
+
+func (r *mongoUsers) SetPassword(ctx context.Context, userID primitive.ObjectID, hash string) error {
+	return r.update(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"password": hash, "updated_at": time.Now()}}, ErrNotFound)
+}
+
+func (r *mongoUsers) ReplacePassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error {
+	return r.update(ctx, bson.M{"_id": userID, "password": old},
+		bson.M{"$set": bson.M{"password": hash, "updated_at": time.Now()}}, ErrConflict)
+}
+
+func (r *mongoUsers) SetEmail(ctx context.Context, userID primitive.ObjectID, email string) error {
+	return r.update(ctx, bson.M{"_id": userID}, bson.M{
+		"$set":   bson.M{"email": email, "pending_verification": true, "updated_at": time.Now()},
+		"$unset": bson.M{"email_verified_at": ""},
+	}, ErrNotFound)
+}
+
+func (r *mongoUsers) VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string, at time.Time) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "email": email, "pending_verification": true},
+		bson.M{
+			"$set":   bson.M{"email_verified_at": at, "updated_at": at},
+			"$unset": bson.M{"pending_verification": ""},
+		}, nil)
+}
+
+func (r *mongoUsers) SetRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	return r.update(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}}, ErrNotFound)
+}
+
+func (r *mongoUsers) Deactivate(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"active": false}}, nil)
+}
+
+func (r *mongoUsers) EnrollTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "totp.enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp": models.TOTPConfig{Secret: secret}}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret, step int64, recoveryCodes []string, at time.Time) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "totp.secret": secret, "totp.enabled": false},
+		bson.M{"$set": bson.M{
+			"totp.enabled":        true,
+			"totp.enabled_at":     at,
+			"totp.last_step":      step,
+			"totp.recovery_codes": recoveryCodes,
+			"updated_at":          at,
+		}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(ctx, bson.M{"_id": userID}, bson.M{
+		"$unset": bson.M{"totp": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	}, nil)
+}
+
+func (r *mongoUsers) AdvanceTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error {
+	// Recording the step only if it advances stops concurrent replays
+	return r.update(ctx,
+		bson.M{"_id": userID, "totp.last_step": bson.M{"$lt": step}, "totp.locked_until": notLocked(time.Now())},
+		bson.M{"$set": bson.M{"totp.last_step": step, "totp.failures": 0}, "$unset": bson.M{"totp.locked_until": ""}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "totp.recovery_codes": hash, "totp.locked_until": notLocked(time.Now())},
+		bson.M{"$pull": bson.M{"totp.recovery_codes": hash}, "$set": bson.M{"totp.failures": 0}, "$unset": bson.M{"totp.locked_until": ""}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoUsers) ClearTOTPFailures(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "totp.locked_until": notLocked(time.Now())},
+		bson.M{"$set": bson.M{"totp.failures": 0}},
+		nil,
+	)
+}
+
+func (r *mongoUsers) RecordTOTPFailure(ctx context.Context, userID primitive.ObjectID, maxFailures int, lockedUntil time.Time) (int, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	// A pipeline update decides on the lock from the count it increments,
+	// so concurrent failures cannot each miss the limit
+	failures := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$totp.failures", 0}}, 1}}
+	locks := bson.M{"$gte": bson.A{failures, maxFailures}}
+
+	var before models.User
+	err := r.collection().FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.A{bson.M{"$set": bson.M{
+			"totp.failures":     bson.M{"$cond": bson.A{locks, 0, failures}},
+			"totp.locked_until": bson.M{"$cond": bson.A{locks, lockedUntil, "$totp.locked_until"}},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{"totp.failures": 1}),
+	).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if before.TOTP == nil {
+		return 1, nil
+	}
+
+	return before.TOTP.Failures + 1, nil
+}
+
+// notLocked matches a second factor that is not locked at now
+func notLocked(now time.Time) bson.M {
+	return bson.M{"$not": bson.M{"$gt": now}}
+}
+
+func (r *mongoUsers) LinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error {
+	linked, err := r.count(ctx, bson.M{"$or": addressFilter(address)})
+	if err != nil {
+		return err
+	}
+	if linked {
+		return ErrDuplicate
+	}
+
+	return r.update(ctx, bson.M{"_id": userID},
+		bson.M{"$addToSet": bson.M{"ethereum_addresses": address}}, ErrNotFound)
+}
+
+func (r *mongoUsers) UnlinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error {
+	return r.update(ctx,
+		bson.M{"_id": userID, "ethereum_addresses": address},
+		bson.M{"$pull": bson.M{"ethereum_addresses": address}},
+		ErrNotFound,
+	)
+}
+
+// addressFilter matches users owning an address as a wallet account or a
+// linked address
+func addressFilter(address string) bson.A {
+	return bson.A{
+		bson.M{"wallet.accounts.address": address},
+		bson.M{"ethereum_addresses": address},
+	}
+}
+
+// mongoTokens stores sessions in the "tokens" collection
+type mongoTokens struct {
+	db *mongodb.DB
+}
+
+func (r *mongoTokens) collection() *mongo.Collection {
+	return r.db.Collection("tokens")
+}
+
+func (r *mongoTokens) Create(ctx context.Context, session models.Token) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.collection(), session)
+}
+
+func (r *mongoTokens) find(ctx context.Context, filter bson.M) (models.Token, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var session models.Token
+	if err := findOne(ctx, r.collection(), filter, &session); err != nil {
+		return models.Token{}, err
+	}
+
+	return session, nil
+}
+
+func (r *mongoTokens) FindByID(ctx context.Context, id primitive.ObjectID) (models.Token, error) {
+	return r.find(ctx, bson.M{"_id": id})
+}
+
+func (r *mongoTokens) FindByTokenID(ctx context.Context, tokenID string) (models.Token, error) {
+	return r.find(ctx, bson.M{"token_id": tokenID})
+}
+
+func (r *mongoTokens) ListActive(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.Token, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	cursor, err := r.collection().Find(ctx,
+		bson.M{"user_id": userID, "is_active": true, "refresh_expires_at": bson.M{"$gt": now}},
+		options.Find().SetSort(bson.D{{Key: "last_seen", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.Token{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *mongoTokens) update(ctx context.Context, filter bson.M, update bson.M, missing error) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return updateOne(ctx, r.collection(), filter, update, missing)
+}
+
+func (r *mongoTokens) Rotate(ctx context.Context, id primitive.ObjectID, tokenID string, expiresAt time.Time, at time.Time) error {
+	return r.update(ctx,
+		bson.M{"_id": id, "is_active": true},
+		bson.M{"$set": bson.M{"token_id": tokenID, "expires_at": expiresAt, "last_seen": at}},
+		ErrNotFound,
+	)
+}
+
+func (r *mongoTokens) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen": at}}, nil)
+}
+
+func (r *mongoTokens) MarkStepUp(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(ctx, bson.M{"_id": id, "is_active": true}, bson.M{"$set": bson.M{"step_up_at": at}}, nil)
+}
+
+func (r *mongoTokens) updateMany(ctx context.Context, filter bson.M, update bson.M) (int64, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	result, err := r.collection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (r *mongoTokens) Revoke(ctx context.Context, filter SessionFilter, at time.Time) (int64, error) {
+	query := bson.M{"is_active": true}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	switch {
+	case !filter.ID.IsZero() && !filter.Except.IsZero():
+		query["_id"] = bson.M{"$eq": filter.ID, "$ne": filter.Except}
+	case !filter.ID.IsZero():
+		query["_id"] = filter.ID
+	case !filter.Except.IsZero():
+		query["_id"] = bson.M{"$ne": filter.Except}
+	}
+
+	return r.updateMany(ctx, query, bson.M{"$set": bson.M{"is_active": false, "revoked_at": at}})
+}
+
+func (r *mongoTokens) Expire(ctx context.Context, now time.Time) (int64, error) {
+	return r.updateMany(ctx,
+		bson.M{
+			"expires_at":         bson.M{"$lt": now},
+			"refresh_expires_at": bson.M{"$not": bson.M{"$gt": now}},
+			"is_active":          true,
+		},
+		bson.M{"$set": bson.M{"is_active": false}},
+	)
+}
+
+// mongoRefreshTokens stores refresh token hashes in the "refresh_tokens"
+// collection
+type mongoRefreshTokens struct {
+	db *mongodb.DB
+}
+
+func (r *mongoRefreshTokens) collection() *mongo.Collection {
+	return r.db.Collection("refresh_tokens")
+}
+
+func (r *mongoRefreshTokens) Create(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.collection(), token)
+}
+
+func (r *mongoRefreshTokens) Claim(ctx context.Context, hash string, now time.Time) (models.RefreshToken, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	// Claiming the token in a single update makes concurrent uses detectable
+	var record models.RefreshToken
+	err := r.collection().FindOneAndUpdate(ctx,
+		bson.M{"hash": hash, "used": false, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.RefreshToken{}, ErrNotFound
+	}
+
+	return record, err
+}
+
+func (r *mongoRefreshTokens) FindByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var record models.RefreshToken
+	if err := findOne(ctx, r.collection(), bson.M{"hash": hash}, &record); err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	return record, nil
+}
+
+// mongoCredentials stores passkeys in the "webauthn_credentials" collection
+type mongoCredentials struct {
+	db *mongodb.DB
+}
+
+func (r *mongoCredentials) collection() *mongo.Collection {
+	return r.db.Collection("webauthn_credentials")
+}
+
+func (r *mongoCredentials) Create(ctx context.Context, credential models.WebAuthnCredential) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	count, err := r.collection().CountDocuments(ctx, bson.M{"credential_id": credential.CredentialID})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDuplicate
+	}
+
+	return insertOne(ctx, r.collection(), credential)
+}
+
+func (r *mongoCredentials) FindByCredentialID(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var credential models.WebAuthnCredential
+	if err := findOne(ctx, r.collection(), bson.M{"credential_id": credentialID}, &credential); err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	return credential, nil
+}
+
+func (r *mongoCredentials) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	cursor, err := r.collection().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := []models.WebAuthnCredential{}
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func (r *mongoCredentials) UpdateSignCount(ctx context.Context, id primitive.ObjectID, old uint32, signCount uint32, at time.Time) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return updateOne(ctx, r.collection(),
+		bson.M{"_id": id, "sign_count": old},
+		bson.M{"$set": bson.M{"sign_count": signCount, "last_used_at": at}},
+		ErrConflict,
+	)
+}
+
+func (r *mongoCredentials) Delete(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	result, err := r.collection().DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// mongoChallenges stores WebAuthn challenges in the "webauthn_challenges"
+// collection
+type mongoChallenges struct {
+	db *mongodb.DB
+}
+
+func (r *mongoChallenges) Create(ctx context.Context, challenge models.WebAuthnChallenge) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.db.Collection("webauthn_challenges"), challenge)
+}
+
+func (r *mongoChallenges) Take(ctx context.Context, id primitive.ObjectID, ceremony string, now time.Time) (models.WebAuthnChallenge, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var challenge models.WebAuthnChallenge
+	err := r.db.Collection("webauthn_challenges").FindOneAndDelete(ctx, bson.M{
+		"_id":        id,
+		"ceremony":   ceremony,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&challenge)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.WebAuthnChallenge{}, ErrNotFound
+	}
+
+	return challenge, err
+}
+
+// mongoNonces stores SIWE nonces in the "siwe_nonces" collection
+type mongoNonces struct {
+	db *mongodb.DB
+}
+
+func (r *mongoNonces) Create(ctx context.Context, nonce models.SIWENonce) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.db.Collection("siwe_nonces"), nonce)
+}
+
+func (r *mongoNonces) Take(ctx context.Context, nonce string, now time.Time) (models.SIWENonce, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var record models.SIWENonce
+	err := r.db.Collection("siwe_nonces").FindOneAndDelete(ctx, bson.M{
+		"nonce":      nonce,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.SIWENonce{}, ErrNotFound
+	}
+
+	return record, err
+}
+
+// mongoTransactions stores transfers in the "transactions" collection
+type mongoTransactions struct {
+	db *mongodb.DB
+}
+
+func (r *mongoTransactions) Create(ctx context.Context, record models.Transaction) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return insertOne(ctx, r.db.Collection("transactions"), record)
+}
+
+func (r *mongoTransactions) SetStatus(ctx context.Context, id primitive.ObjectID, status, errorMessage string, updatedAt time.Time) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	return updateOne(ctx, r.db.Collection("transactions"), bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"status": status, "error": errorMessage, "updated_at": updatedAt},
+	}, ErrNotFound)
+}
+
+// mongoDeposits stores deposits in the "deposits" collection and scanner
+// cursors in the "scan_cursors" collection
+type mongoDeposits struct {
+	db *mongodb.DB
+}
+
+func (r *mongoDeposits) collection() *mongo.Collection {
+	return r.db.Collection("deposits")
+}
+
+func (r *mongoDeposits) LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	var cursor models.ScanCursor
+	err := findOne(ctx, r.db.Collection("scan_cursors"), bson.M{"_id": chainID}, &cursor)
+	if errors.Is(err, ErrNotFound) {
+		return models.ScanCursor{}, false, nil
+	}
+	if err != nil {
+		return models.ScanCursor{}, false, err
+	}
+
+	return cursor, true, nil
+}
+
+func (r *mongoDeposits) SaveCursor(ctx context.Context, cursor models.ScanCursor) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	_, err := r.db.Collection("scan_cursors").ReplaceOne(ctx,
+		bson.M{"_id": cursor.ChainID},
+		cursor,
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (r *mongoDeposits) SaveDeposit(ctx context.Context, deposit models.Deposit) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	_, err := r.collection().UpdateOne(ctx,
+		bson.M{"chain_id": deposit.ChainID, "tx_hash": deposit.TxHash, "log_index": deposit.LogIndex},
+		bson.M{
+			"$set": bson.M{
+				"user_id":       deposit.UserID,
+				"address":       deposit.Address,
+				"from":          deposit.From,
+				"token":         deposit.Token,
+				"currency":      deposit.Symbol,
+				"amount":        deposit.Amount,
+				"decimals":      deposit.Decimals,
+				"block_number":  deposit.BlockNumber,
+				"block_hash":    deposit.BlockHash,
+				"confirmations": deposit.Confirmations,
+				"status":        deposit.Status,
+				"updated_at":    deposit.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"created_at": deposit.CreatedAt},
+		},
+		options.Update().SetUpsert(true),
+	)
+
+	return duplicateError(err)
+}
+
+func (r *mongoDeposits) RollBack(ctx context.Context, chainID int64, fromBlock uint64, at time.Time) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	_, err := r.collection().UpdateMany(ctx,
+		bson.M{
+			"chain_id":     chainID,
+			"block_number": bson.M{"$gte": fromBlock},
+			"status":       models.DepositStatusPending,
+		},
+		bson.M{"$set": bson.M{"status": models.DepositStatusReorged, "updated_at": at}},
+	)
+
+	return err
+}
+
+func (r *mongoDeposits) Confirm(ctx context.Context, chainID int64, head uint64, required uint64, at time.Time) error {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	filter := bson.M{"chain_id": chainID, "status": models.DepositStatusPending}
+
+	// confirmations = head - block_number + 1, computed by the server
+	confirmations := bson.M{"$add": bson.A{bson.M{"$subtract": bson.A{int64(head), "$block_number"}}, 1}}
+	_, err := r.collection().UpdateMany(ctx, filter, bson.A{bson.M{"$set": bson.M{
+		"confirmations": confirmations,
+		"status": bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{confirmations, int64(required)}},
+			models.DepositStatusConfirmed,
+			models.DepositStatusPending,
+		}},
+		"updated_at": at,
+	}}})
+
+	return err
+}
+
+func (r *mongoDeposits) ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.Deposit, error) {
+	ctx, cancel := r.db.Context(ctx)
+	defer cancel()
+
+	cursor, err := r.collection().Find(ctx,
+		bson.M{"user_id": userID, "status": bson.M{"$ne": models.DepositStatusReorged}},
+		options.Find().SetSort(bson.D{{Key: "block_number", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deposits := []models.Deposit{}
+	if err := cursor.All(ctx, &deposits); err != nil {
+		return nil, err
+	}
+
+	return deposits, nil
+}
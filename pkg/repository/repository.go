@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	models "wallet/pkg/models"
+)
+
+var (
+	// ErrNotFound is returned when no record matches
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a conditional update no longer applies,
+	// e.g. because the record was changed concurrently
+	ErrConflict = errors.New("record was changed concurrently")
+	// ErrDuplicate is returned when a record with the same unique key exists
+	ErrDuplicate = errors.New("record already exists")
+	// ErrUsernameTaken is the ErrDuplicate returned when another user has
+	// the username, ignoring case
+	ErrUsernameTaken = fmt.Errorf("%w: username is taken", ErrDuplicate)
+)
+
+// Repositories holds the repositories of every record type. Services take
+// the ones they need from it, so the storage can be swapped as a whole.
+type Repositories struct {
+	Users         UserRepository
+	Tokens        TokenRepository
+	RefreshTokens RefreshTokenRepository
+	Credentials   CredentialRepository
+	Challenges    ChallengeRepository
+	Nonces        NonceRepository
+	Transactions  TransactionRepository
+	Deposits      DepositRepository
+}
+
+// WalletRepository stores the wallet and balances kept on user records
+type WalletRepository interface {
+	// SetBalances replaces the balances of a user on one chain, leaving the
+	// balances on other chains untouched, and enables the chain on the wallet
+	SetBalances(ctx context.Context, userID primitive.ObjectID, chainID int64, balances []models.Balance) error
+	// ReplaceWalletSecret swaps the wallet secret of a user if it is still
+	// old, and returns ErrConflict otherwise
+	ReplaceWalletSecret(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret) error
+	// AddWalletAccount appends account to a user's wallet, advances its next
+	// index and stores secret in place of old. It returns ErrConflict if the
+	// secret is no longer old or account.Index is no longer the next index.
+	AddWalletAccount(ctx context.Context, userID primitive.ObjectID, old models.EncryptedSecret, secret models.EncryptedSecret, account models.WalletAccount) error
+	// WalletOwners maps the address of every wallet account of an active
+	// user to the user
+	WalletOwners(ctx context.Context) (map[string]primitive.ObjectID, error)
+}
+
+// UserRepository stores users. Lookups return ErrNotFound for unknown
+// users, whether or not they are active.
+type UserRepository interface {
+	WalletRepository
+
+	// Create returns ErrUsernameTaken if another user has the username, or
+	// ErrDuplicate if another user has the email or a wallet address of
+	// userData
+	Create(ctx context.Context, userData models.User) error
+	Delete(ctx context.Context, userID primitive.ObjectID) error
+	FindByID(ctx context.Context, userID primitive.ObjectID) (models.User, error)
+	// FindByEmail and EmailExists match emails ignoring case and
+	// surrounding spaces, as emails are stored normalized
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	// FindByAddress returns the user owning address as a wallet account or
+	// a linked Ethereum address
+	FindByAddress(ctx context.Context, address string) (models.User, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	// UsernameTaken reports whether a user other than except has username,
+	// ignoring case
+	UsernameTaken(ctx context.Context, username string, except primitive.ObjectID) (bool, error)
+	ListActive(ctx context.Context) ([]models.User, error)
+	// Each calls fn with every user until fn returns an error
+	Each(ctx context.Context, fn func(models.User) error) error
+
+	// SetUsername returns ErrUsernameTaken if another user has the username
+	SetUsername(ctx context.Context, userID primitive.ObjectID, username string) error
+	SetPassword(ctx context.Context, userID primitive.ObjectID, hash string) error
+	// ReplacePassword swaps the password hash of a user if it is still old,
+	// and returns ErrConflict otherwise
+	ReplacePassword(ctx context.Context, userID primitive.ObjectID, old string, hash string) error
+	// SetEmail moves a user to a new address, pending its verification. It
+	// returns ErrDuplicate if another user has email.
+	SetEmail(ctx context.Context, userID primitive.ObjectID, email string) error
+	// VerifyEmail marks the email of a user pending verification as
+	// verified, if it is still email. It does nothing otherwise.
+	VerifyEmail(ctx context.Context, userID primitive.ObjectID, email string, at time.Time) error
+	SetRole(ctx context.Context, userID primitive.ObjectID, role string) error
+	Deactivate(ctx context.Context, userID primitive.ObjectID) error
+
+	// EnrollTOTP stores an unconfirmed TOTP secret, replacing any previous
+	// unconfirmed one. It returns ErrConflict if TOTP is enabled.
+	EnrollTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret) error
+	// EnableTOTP confirms the enrollment of secret at step and stores the
+	// recovery code hashes. It returns ErrConflict if secret is no longer
+	// the unconfirmed enrollment.
+	EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret models.EncryptedSecret, step int64, recoveryCodes []string, at time.Time) error
+	DisableTOTP(ctx context.Context, userID primitive.ObjectID) error
+	// AdvanceTOTPStep records an accepted TOTP step and clears failures. It
+	// returns ErrConflict unless step is after the last accepted one, or
+	// while the second factor is locked.
+	AdvanceTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) error
+	// UseRecoveryCode spends a recovery code hash and clears failures. It
+	// returns ErrConflict if the user does not have the code, or while the
+	// second factor is locked.
+	UseRecoveryCode(ctx context.Context, userID primitive.ObjectID, hash string) error
+	// ClearTOTPFailures resets the count of invalid codes unless the second
+	// factor is locked
+	ClearTOTPFailures(ctx context.Context, userID primitive.ObjectID) error
+	// RecordTOTPFailure counts an invalid code and returns the count. The
+	// failure that reaches maxFailures resets the count and locks the second
+	// factor until lockedUntil in the same write.
+	RecordTOTPFailure(ctx context.Context, userID primitive.ObjectID, maxFailures int, lockedUntil time.Time) (int, error)
+
+	// LinkAddress links an Ethereum address to a user. It returns
+	// ErrDuplicate if any user owns or linked the address already.
+	LinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error
+	// UnlinkAddress returns ErrNotFound if the user did not link address
+	UnlinkAddress(ctx context.Context, userID primitive.ObjectID, address string) error
+}
+
+// SessionFilter selects the sessions of Revoke. Unset fields match every
+// session.
+type SessionFilter struct {
+	ID     primitive.ObjectID
+	UserID primitive.ObjectID
+	// Except is a session to leave untouched
+	Except primitive.ObjectID
+}
+
+// TokenRepository stores login sessions
+type TokenRepository interface {
+	Create(ctx context.Context, session models.Token) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Token, error)
+	// FindByTokenID returns the session whose current access token has the
+	// JWT ID tokenID
+	FindByTokenID(ctx context.Context, tokenID string) (models.Token, error)
+	// ListActive returns the active sessions of a user that can still be
+	// refreshed at now, most recently used first
+	ListActive(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.Token, error)
+	// Rotate gives an active session a new access token ID and expiry. It
+	// returns ErrNotFound if the session is not active.
+	Rotate(ctx context.Context, id primitive.ObjectID, tokenID string, expiresAt time.Time, at time.Time) error
+	// Touch records activity on a session
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// MarkStepUp records a re-verification on an active session
+	MarkStepUp(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// Revoke deactivates the active sessions matching filter and returns
+	// how many there were
+	Revoke(ctx context.Context, filter SessionFilter, at time.Time) (int64, error)
+	// Expire deactivates the active sessions that can neither be used nor
+	// refreshed at now, and returns how many there were
+	Expire(ctx context.Context, now time.Time) (int64, error)
+}
+
+// RefreshTokenRepository stores refresh token hashes
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token models.RefreshToken) error
+	// Claim marks the unused token with hash as used and returns it. It
+	// returns ErrNotFound if no such token is unexpired at now.
+	Claim(ctx context.Context, hash string, now time.Time) (models.RefreshToken, error)
+	FindByHash(ctx context.Context, hash string) (models.RefreshToken, error)
+}
+
+// CredentialRepository stores WebAuthn passkeys
+type CredentialRepository interface {
+	// Create returns ErrDuplicate if the credential ID is registered
+	Create(ctx context.Context, credential models.WebAuthnCredential) error
+	FindByCredentialID(ctx context.Context, credentialID []byte) (models.WebAuthnCredential, error)
+	// ListByUser returns the passkeys of a user, oldest first
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.WebAuthnCredential, error)
+	// UpdateSignCount stores the signature counter of a passkey if it is
+	// still old, and returns ErrConflict otherwise
+	UpdateSignCount(ctx context.Context, id primitive.ObjectID, old uint32, signCount uint32, at time.Time) error
+	// Delete returns ErrNotFound if the user has no such passkey
+	Delete(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error
+}
+
+// ChallengeRepository stores pending WebAuthn ceremonies
+type ChallengeRepository interface {
+	Create(ctx context.Context, challenge models.WebAuthnChallenge) error
+	// Take removes and returns a challenge of a ceremony. It returns
+	// ErrNotFound if there is none unexpired at now.
+	Take(ctx context.Context, id primitive.ObjectID, ceremony string, now time.Time) (models.WebAuthnChallenge, error)
+}
+
+// NonceRepository stores Sign-In with Ethereum nonces
+type NonceRepository interface {
+	Create(ctx context.Context, nonce models.SIWENonce) error
+	// Take removes and returns a nonce. It returns ErrNotFound if there is
+	// none unexpired at now.
+	Take(ctx context.Context, nonce string, now time.Time) (models.SIWENonce, error)
+}
+
+// TransactionRepository stores transfers broadcast from user wallets
+type TransactionRepository interface {
+	Create(ctx context.Context, record models.Transaction) error
+	// SetStatus records the status of a transaction and the error that
+	// caused it, if any. It returns ErrNotFound if there is no such
+	// transaction.
+	SetStatus(ctx context.Context, id primitive.ObjectID, status, errorMessage string, updatedAt time.Time) error
+}
+
+// DepositRepository stores the deposits found by the deposit scanner and
+// its cursor on every chain
+type DepositRepository interface {
+	// LoadCursor returns the cursor of a chain, or false if it never ran
+	LoadCursor(ctx context.Context, chainID int64) (models.ScanCursor, bool, error)
+	SaveCursor(ctx context.Context, cursor models.ScanCursor) error
+	// SaveDeposit inserts a deposit, or updates the one with the same
+	// chain, transaction hash and log index
+	SaveDeposit(ctx context.Context, deposit models.Deposit) error
+	// RollBack marks the pending deposits of a chain at or above a block as
+	// reorged
+	RollBack(ctx context.Context, chainID int64, fromBlock uint64, at time.Time) error
+	// Confirm updates the confirmation count of the pending deposits of a
+	// chain and confirms those with at least the required number
+	Confirm(ctx context.Context, chainID int64, head uint64, required uint64, at time.Time) error
+	// ListByUser returns up to limit deposits of a user that were not
+	// reorged, newest block first
+	ListByUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.Deposit, error)
+}
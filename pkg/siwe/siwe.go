@@ -0,0 +1,306 @@
+// Package siwe parses and verifies Sign-In with Ethereum (EIP-4361)
+// messages, signed with personal_sign by an account key or validated by a
+// contract wallet through EIP-1271
+package siwe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	header = " wants you to sign in with your Ethereum account:"
+	// clockSkew is how far in the future Issued At may be
+	clockSkew = time.Minute
+)
+
+var (
+	ErrInvalidMessage   = errors.New("invalid SIWE message")
+	ErrDomainMismatch   = errors.New("SIWE domain does not match")
+	ErrURIMismatch      = errors.New("SIWE URI does not match the domain")
+	ErrChainNotAllowed  = errors.New("SIWE chain is not supported")
+	ErrExpired          = errors.New("SIWE message has expired")
+	ErrNotYetValid      = errors.New("SIWE message is not valid yet")
+	ErrInvalidSignature = errors.New("invalid SIWE signature")
+)
+
+// eip1271Magic is returned by isValidSignature for valid signatures
+var eip1271Magic = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+var eip1271ABI = mustParseABI(`[{"name":"isValidSignature","type":"function","stateMutability":"view",
+	"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],
+	"outputs":[{"name":"magicValue","type":"bytes4"}]}]`)
+
+// ContractCaller is the RPC access needed to verify EIP-1271 signatures.
+// ethereum.Client implements it.
+type ContractCaller interface {
+	CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Message is an EIP-4361 message. Optional times are nil when absent.
+type Message struct {
+	Scheme         string
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// Options are the values a message must match to be accepted
+type Options struct {
+	Domain   string
+	ChainIDs []int64
+	Now      time.Time
+}
+
+// ParseMessage parses the text of an EIP-4361 message. The address must be
+// EIP-55 checksummed.
+func ParseMessage(text string) (Message, error) {
+	lines := strings.Split(text, "\n")
+	invalid := func(reason string) (Message, error) {
+		return Message{}, fmt.Errorf("%w: %s", ErrInvalidMessage, reason)
+	}
+	if len(lines) < 9 {
+		return invalid("message is too short")
+	}
+
+	var m Message
+	domain, ok := strings.CutSuffix(lines[0], header)
+	if !ok || domain == "" {
+		return invalid("missing header")
+	}
+	if scheme, rest, ok := strings.Cut(domain, "://"); ok {
+		m.Scheme, domain = scheme, rest
+	}
+	m.Domain = domain
+
+	if !common.IsHexAddress(lines[1]) || common.HexToAddress(lines[1]).Hex() != lines[1] {
+		return invalid("address must be EIP-55 checksummed")
+	}
+	m.Address = common.HexToAddress(lines[1])
+
+	if lines[2] != "" {
+		return invalid("missing blank line after the address")
+	}
+	i := 3
+	if lines[i] != "" {
+		m.Statement = lines[i]
+		i++
+	}
+	if lines[i] != "" {
+		return invalid("missing blank line before the fields")
+	}
+	i++
+
+	// field returns the value of the next line if it has the tag
+	field := func(tag string) (string, bool) {
+		if i < len(lines) {
+			if value, ok := strings.CutPrefix(lines[i], tag+": "); ok {
+				i++
+				return value, true
+			}
+		}
+		return "", false
+	}
+	var err error
+
+	if m.URI, ok = field("URI"); !ok {
+		return invalid("missing URI")
+	}
+	if m.Version, ok = field("Version"); !ok || m.Version != "1" {
+		return invalid("version must be 1")
+	}
+	chainID, ok := field("Chain ID")
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); !ok || err != nil {
+		return invalid("invalid chain ID")
+	}
+	if m.Nonce, ok = field("Nonce"); !ok || len(m.Nonce) < 8 || !isAlphanumeric(m.Nonce) {
+		return invalid("nonce must be at least 8 alphanumeric characters")
+	}
+	issuedAt, ok := field("Issued At")
+	if m.IssuedAt, err = time.Parse(time.RFC3339, issuedAt); !ok || err != nil {
+		return invalid("invalid issued at time")
+	}
+	if value, ok := field("Expiration Time"); ok {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("invalid expiration time")
+		}
+		m.ExpirationTime = &t
+	}
+	if value, ok := field("Not Before"); ok {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalid("invalid not before time")
+		}
+		m.NotBefore = &t
+	}
+	m.RequestID, _ = field("Request ID")
+	if i < len(lines) && lines[i] == "Resources:" {
+		for i++; i < len(lines) && strings.HasPrefix(lines[i], "- "); i++ {
+			m.Resources = append(m.Resources, lines[i][2:])
+		}
+	}
+	if i != len(lines) {
+		return invalid(fmt.Sprintf("unexpected line %q", lines[i]))
+	}
+
+	return m, nil
+}
+
+// String renders the message in the EIP-4361 format
+func (m Message) String() string {
+	var b strings.Builder
+	if m.Scheme != "" {
+		b.WriteString(m.Scheme + "://")
+	}
+	b.WriteString(m.Domain + header + "\n")
+	b.WriteString(m.Address.Hex() + "\n\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString("URI: " + m.URI + "\n")
+	b.WriteString("Version: " + m.Version + "\n")
+	b.WriteString("Chain ID: " + strconv.FormatInt(m.ChainID, 10) + "\n")
+	b.WriteString("Nonce: " + m.Nonce + "\n")
+	b.WriteString("Issued At: " + m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		b.WriteString("\nExpiration Time: " + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		b.WriteString("\nNot Before: " + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		b.WriteString("\nRequest ID: " + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for _, resource := range m.Resources {
+			b.WriteString("\n- " + resource)
+		}
+	}
+
+	return b.String()
+}
+
+// Validate checks the domain, URI, chain and validity period of a message.
+// The nonce is checked by the caller, which issued it.
+func (m Message) Validate(opts Options) error {
+	if !strings.EqualFold(m.Domain, opts.Domain) || (m.Scheme != "" && m.Scheme != "https" && m.Scheme != "http") {
+		return ErrDomainMismatch
+	}
+
+	uri, err := url.Parse(m.URI)
+	if err != nil || !strings.EqualFold(uri.Host, m.Domain) {
+		return ErrURIMismatch
+	}
+
+	if !slices.Contains(opts.ChainIDs, m.ChainID) {
+		return ErrChainNotAllowed
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return ErrExpired
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return ErrNotYetValid
+	}
+	if m.IssuedAt.After(now.Add(clockSkew)) {
+		return ErrNotYetValid
+	}
+
+	return nil
+}
+
+// VerifySignature checks that address signed text with personal_sign. If
+// the signature was not made by the account key and caller is not nil, the
+// address is asked to validate it as an EIP-1271 contract wallet.
+func VerifySignature(ctx context.Context, caller ContractCaller, address common.Address, text string, signature []byte) error {
+	hash := accounts.TextHash([]byte(text))
+
+	if len(signature) == crypto.SignatureLength {
+		sig := bytes.Clone(signature)
+		if sig[crypto.RecoveryIDOffset] >= 27 {
+			sig[crypto.RecoveryIDOffset] -= 27
+		}
+		if public, err := crypto.SigToPub(hash, sig); err == nil && crypto.PubkeyToAddress(*public) == address {
+			return nil
+		}
+	}
+
+	if caller == nil {
+		return ErrInvalidSignature
+	}
+
+	return verifyEIP1271(ctx, caller, address, common.BytesToHash(hash), signature)
+}
+
+// verifyEIP1271 calls isValidSignature on a contract wallet
+func verifyEIP1271(ctx context.Context, caller ContractCaller, address common.Address, hash common.Hash, signature []byte) error {
+	input, err := eip1271ABI.Pack("isValidSignature", hash, signature)
+	if err != nil {
+		return err
+	}
+
+	output, err := caller.CallContract(ctx, geth.CallMsg{To: &address, Data: input}, nil)
+	if err != nil {
+		// A revert means the contract rejected the signature, anything else
+		// is an RPC failure
+		var dataErr interface{ ErrorData() interface{} }
+		if errors.As(err, &dataErr) || strings.Contains(err.Error(), "execution reverted") {
+			return ErrInvalidSignature
+		}
+		return err
+	}
+
+	// Accounts without code return no data
+	if len(output) < 4 || !bytes.Equal(output[:4], eip1271Magic[:]) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func isAlphanumeric(s string) bool {
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
@@ -0,0 +1,183 @@
+package siwe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func testMessage(address common.Address) Message {
+	expires := time.Date(2030, 1, 1, 0, 10, 0, 0, time.UTC)
+	return Message{
+		Domain:         "wallet.example.com",
+		Address:        address,
+		Statement:      "Sign in to Wallet",
+		URI:            "https://wallet.example.com/login",
+		Version:        "1",
+		ChainID:        1,
+		Nonce:          "abcdef0123456789",
+		IssuedAt:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpirationTime: &expires,
+		Resources:      []string{"https://wallet.example.com/terms"},
+	}
+}
+
+// personalSign signs text the way wallets do for personal_sign
+func personalSign(t *testing.T, text string) (common.Address, []byte) {
+	t.Helper()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	signature, err := crypto.Sign(accounts.TextHash([]byte(text)), key)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+
+	return crypto.PubkeyToAddress(key.PublicKey), signature
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	address := common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	for _, statement := range []string{"Sign in to Wallet", ""} {
+		m := testMessage(address)
+		m.Statement = statement
+
+		parsed, err := ParseMessage(m.String())
+		if err != nil {
+			t.Fatalf("ParseMessage failed: %v\n%s", err, m.String())
+		}
+		if parsed.String() != m.String() {
+			t.Errorf("round trip changed the message:\n%s\n---\n%s", m.String(), parsed.String())
+		}
+		if parsed.Address != address || parsed.Statement != statement || parsed.ChainID != 1 || len(parsed.Resources) != 1 {
+			t.Errorf("unexpected message %+v", parsed)
+		}
+	}
+}
+
+func TestParseMessageRejects(t *testing.T) {
+	valid := testMessage(common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")).String()
+
+	tests := map[string]string{
+		"lowercase address": strings.Replace(valid, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", 1),
+		"short nonce":       strings.Replace(valid, "Nonce: abcdef0123456789", "Nonce: abc", 1),
+		"wrong version":     strings.Replace(valid, "Version: 1", "Version: 2", 1),
+		"missing URI":       strings.Replace(valid, "URI: https://wallet.example.com/login\n", "", 1),
+		"trailing content":  valid + "\nextra",
+		"bad header":        strings.Replace(valid, "wants you to sign in", "wants you to log in", 1),
+	}
+
+	for name, text := range tests {
+		if _, err := ParseMessage(text); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", name, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := testMessage(common.Address{})
+	opts := Options{Domain: "wallet.example.com", ChainIDs: []int64{1, 137}, Now: m.IssuedAt.Add(time.Minute)}
+
+	if err := m.Validate(opts); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *Message, opts *Options)
+		want   error
+	}{
+		{"other domain", func(m *Message, opts *Options) { opts.Domain = "phishing.example.com" }, ErrDomainMismatch},
+		{"URI on another host", func(m *Message, opts *Options) { m.URI = "https://phishing.example.com/" }, ErrURIMismatch},
+		{"unsupported chain", func(m *Message, opts *Options) { m.ChainID = 56 }, ErrChainNotAllowed},
+		{"expired", func(m *Message, opts *Options) { opts.Now = m.ExpirationTime.Add(time.Second) }, ErrExpired},
+		{"not before", func(m *Message, opts *Options) {
+			notBefore := opts.Now.Add(time.Hour)
+			m.NotBefore = &notBefore
+		}, ErrNotYetValid},
+		{"issued in the future", func(m *Message, opts *Options) { m.IssuedAt = opts.Now.Add(time.Hour) }, ErrNotYetValid},
+	}
+
+	for _, test := range tests {
+		m, opts := testMessage(common.Address{}), opts
+		test.modify(&m, &opts)
+		if err := m.Validate(opts); !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	text := testMessage(common.Address{}).String()
+	address, signature := personalSign(t, text)
+
+	if err := VerifySignature(context.Background(), nil, address, text, signature); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+
+	other, _ := personalSign(t, text)
+	if err := VerifySignature(context.Background(), nil, other, text, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another address, got %v", err)
+	}
+	if err := VerifySignature(context.Background(), nil, address, text+" ", signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another text, got %v", err)
+	}
+}
+
+// contractWallet answers isValidSignature like an EIP-1271 wallet that
+// accepts one signature
+type contractWallet struct {
+	address   common.Address
+	signature []byte
+	err       error
+}
+
+func (w contractWallet) CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if call.To == nil || *call.To != w.address {
+		return nil, nil
+	}
+
+	args, err := eip1271ABI.Methods["isValidSignature"].Inputs.Unpack(call.Data[4:])
+	if err != nil || !bytes.Equal(args[1].([]byte), w.signature) {
+		return common.LeftPadBytes(nil, 32), nil
+	}
+	return common.RightPadBytes(eip1271Magic[:], 32), nil
+}
+
+func TestVerifySignatureEIP1271(t *testing.T) {
+	text := testMessage(common.Address{}).String()
+	wallet := contractWallet{address: common.HexToAddress("0x00000000000000000000000000000000000000aa"), signature: []byte("multisig approval")}
+
+	if err := VerifySignature(context.Background(), wallet, wallet.address, text, wallet.signature); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+	if err := VerifySignature(context.Background(), wallet, wallet.address, text, []byte("forged")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a rejected signature, got %v", err)
+	}
+
+	eoa, signature := personalSign(t, text)
+	if err := VerifySignature(context.Background(), wallet, eoa, text, signature[:64]); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for an account without code, got %v", err)
+	}
+
+	wallet.err = errors.New("connection refused")
+	if err := VerifySignature(context.Background(), wallet, wallet.address, text, wallet.signature); err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected the RPC error, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	config "wallet/pkg/config"
+	"wallet/pkg/mailer"
+	models "wallet/pkg/models"
+	"wallet/pkg/repository"
+)
+
+var ErrAlreadyVerified = errors.New("email is already verified")
+
+// RegisterUser creates a user pending email verification and mails them a
+// verification link. The user is removed again if the link cannot be sent,
+// so the email address can be registered again.
+func (s *Service) RegisterUser(userName string, email string, password string, m mailer.Mailer) (models.User, error) {
+	userData, err := s.createUser(userName, email, password, true)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := sendVerification(userData, m); err != nil {
+		if deleteErr := s.deleteUser(userData.ID); deleteErr != nil {
+			log.Printf("Failed to roll back user %s: %v", userData.ID.Hex(), deleteErr)
+		}
+		return models.User{}, fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return userData, nil
+}
+
+// ResendVerification mails a new verification link to a user who has not
+// verified their email yet
+func (s *Service) ResendVerification(email string, m mailer.Mailer) error {
+	userData, err := s.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if !userData.PendingVerification {
+		return ErrAlreadyVerified
+	}
+
+	return sendVerification(userData, m)
+}
+
+// VerifyEmail completes verification with a token from a verification link.
+// The token is only accepted while the user still has the email it was
+// issued for.
+func (s *Service) VerifyEmail(token string) (models.User, error) {
+	userID, email, err := auth.ParseVerificationToken(token)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	ctx := context.Background()
+
+	if err := s.users.VerifyEmail(ctx, userID, email, time.Now()); err != nil {
+		return models.User{}, err
+	}
+
+	userData, err := s.users.FindByID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && userData.Email != email) {
+		return models.User{}, auth.ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return userData, nil
+}
+
+// sendVerification mails a verification link to the user
+func sendVerification(userData models.User, m mailer.Mailer) error {
+	token, err := auth.GenerateVerificationToken(userData.ID, userData.Email, auth.VerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return err
+	}
+	link := verificationURL(cfg.AppURL, token)
+
+	return m.Send(mailer.Message{
+		To:      userData.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nConfirm your email address to activate your wallet:\n\n%s\n\nThe link expires in %s. If you did not sign up, ignore this email.\n",
+			userData.Username, link, auth.VerificationTTL),
+	})
+}
+
+// verificationURL builds the verification link served by GET /verify
+func verificationURL(appURL string, token string) string {
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+
+	return strings.TrimRight(appURL, "/") + "/verify?token=" + url.QueryEscape(token)
+}
+
+// deleteUser removes a user record
+func (s *Service) deleteUser(userID primitive.ObjectID) error {
+	return s.users.Delete(context.Background(), userID)
+}
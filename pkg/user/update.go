@@ -0,0 +1,272 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	"wallet/pkg/mailer"
+	models "wallet/pkg/models"
+	"wallet/pkg/repository"
+)
+
+// MinPasswordLength is the shortest password accepted for new passwords
+const MinPasswordLength = 8
+
+var ErrWrongPassword = errors.New("current password is incorrect")
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// ProfileUpdate holds the fields a user may change through UpdateUser.
+// Fields left nil are not changed. Passwords and emails have their own
+// flows, ChangePassword and ChangeEmail, since both need the current
+// password.
+type ProfileUpdate struct {
+	Username *string `json:"username"`
+}
+
+// FieldError reports the fields a request was refused for: fields that
+// cannot be set and fields with invalid values
+type FieldError struct {
+	Rejected []string          `json:"rejected_fields,omitempty"`
+	Invalid  map[string]string `json:"invalid_fields,omitempty"`
+}
+
+func (e *FieldError) Error() string {
+	var parts []string
+	if len(e.Rejected) > 0 {
+		parts = append(parts, "fields cannot be updated: "+strings.Join(e.Rejected, ", "))
+	}
+
+	fields := make([]string, 0, len(e.Invalid))
+	for field := range e.Invalid {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		parts = append(parts, field+" "+e.Invalid[field])
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// invalid records a validation failure for a field
+func (e *FieldError) invalid(field string, reason string) {
+	if e.Invalid == nil {
+		e.Invalid = map[string]string{}
+	}
+	e.Invalid[field] = reason
+}
+
+// orNil returns e if it holds any failure
+func (e *FieldError) orNil() error {
+	if len(e.Rejected) == 0 && len(e.Invalid) == 0 {
+		return nil
+	}
+	return e
+}
+
+// DecodeProfileUpdate parses a JSON update, refusing any field that is not
+// part of ProfileUpdate
+func DecodeProfileUpdate(data []byte) (ProfileUpdate, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ProfileUpdate{}, err
+	}
+
+	allowed := map[string]bool{"username": true}
+	fieldErr := &FieldError{}
+	for field := range fields {
+		if !allowed[field] {
+			fieldErr.Rejected = append(fieldErr.Rejected, field)
+		}
+	}
+	sort.Strings(fieldErr.Rejected)
+	if err := fieldErr.orNil(); err != nil {
+		return ProfileUpdate{}, err
+	}
+
+	var update ProfileUpdate
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		return ProfileUpdate{}, err
+	}
+
+	return update, nil
+}
+
+// UpdateUser applies a validated profile update to a user
+func (s *Service) UpdateUser(userID primitive.ObjectID, update ProfileUpdate) error {
+	if update.Username == nil {
+		return nil
+	}
+
+	username := strings.TrimSpace(*update.Username)
+	problem, err := s.usernameProblem(username, userID)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		fieldErr := &FieldError{}
+		fieldErr.invalid("username", problem)
+		return fieldErr
+	}
+
+	// The unique username index catches a username claimed concurrently
+	err = userNotFound(s.users.SetUsername(context.Background(), userID, username))
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		return usernameTakenError()
+	}
+
+	return err
+}
+
+// ChangePassword replaces the user's password after checking the current one
+func (s *Service) ChangePassword(userID primitive.ObjectID, currentPassword string, newPassword string) error {
+	if _, err := s.checkPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	if len(newPassword) < MinPasswordLength {
+		fieldErr := &FieldError{}
+		fieldErr.invalid("new_password", fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+		return fieldErr
+	}
+
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return userNotFound(s.users.SetPassword(context.Background(), userID, hash))
+}
+
+// ChangeEmail moves the user to a new email address after checking their
+// password. The new address must be verified like at registration, and the
+// previous address is told about the change.
+func (s *Service) ChangeEmail(userID primitive.ObjectID, currentPassword string, newEmail string, m mailer.Mailer) error {
+	userData, err := s.checkPassword(userID, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	newEmail = models.NormalizeEmail(newEmail)
+	if problem := emailProblem(newEmail); problem != "" {
+		fieldErr := &FieldError{}
+		fieldErr.invalid("email", problem)
+		return fieldErr
+	}
+	if newEmail == userData.Email {
+		return nil
+	}
+
+	exists, err := s.doesUserExist(newEmail)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrUserExists
+	}
+
+	err = userNotFound(s.users.SetEmail(context.Background(), userID, newEmail))
+	if errors.Is(err, repository.ErrDuplicate) {
+		return ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	notice := mailer.Message{
+		To:      userData.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hello %s,\n\nThe email address of your wallet was changed to %s. If you did not make this change, contact support immediately.\n",
+			userData.Username, newEmail),
+	}
+	if err := m.Send(notice); err != nil {
+		log.Printf("Failed to notify %s of the email change of user %s: %v", userData.Email, userID.Hex(), err)
+	}
+
+	userData.Email = newEmail
+	if err := sendVerification(userData, m); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// checkPassword loads a user and checks their password
+func (s *Service) checkPassword(userID primitive.ObjectID, password string) (models.User, error) {
+	userData, err := s.GetUserByID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	ok, _, err := auth.VerifyPassword(userData.Password, password)
+	if err != nil || !ok {
+		return models.User{}, ErrWrongPassword
+	}
+
+	return userData, nil
+}
+
+// usernameProblem checks the charset of a username and that no user other
+// than userID has it, ignoring case. It returns why the username is refused,
+// or "" if it is valid.
+func (s *Service) usernameProblem(username string, userID primitive.ObjectID) (string, error) {
+	if !usernamePattern.MatchString(username) {
+		return "must be 3 to 32 letters, digits, '.', '_' or '-'", nil
+	}
+
+	taken, err := s.users.UsernameTaken(context.Background(), username, userID)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return usernameTaken, nil
+	}
+
+	return "", nil
+}
+
+// usernameTaken is why a username another user has is refused
+const usernameTaken = "is already taken"
+
+// usernameTakenError refuses a username another user claimed between the
+// check and the write
+func usernameTakenError() error {
+	fieldErr := &FieldError{}
+	fieldErr.invalid("username", usernameTaken)
+	return fieldErr
+}
+
+// emailProblem checks that email is a bare address. It returns why the
+// address is refused, or "" if it is valid.
+func emailProblem(email string) string {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "must be a valid email address"
+	}
+
+	return ""
+}
+
+// userNotFound reports an update of a user that does not exist as
+// ErrUserNotFound
+func userNotFound(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,56 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeProfileUpdate(t *testing.T) {
+	update, err := DecodeProfileUpdate([]byte(`{"username": "alice"}`))
+	if err != nil {
+		t.Fatalf("DecodeProfileUpdate failed: %v", err)
+	}
+	if update.Username == nil || *update.Username != "alice" {
+		t.Errorf("unexpected update %+v", update)
+	}
+
+	_, err = DecodeProfileUpdate([]byte(`{"username": "alice", "password": "x", "active": true, "wallet": {}, "_id": "1"}`))
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Fatalf("expected a FieldError, got %v", err)
+	}
+	if want := []string{"_id", "active", "password", "wallet"}; !reflect.DeepEqual(fieldErr.Rejected, want) {
+		t.Errorf("expected rejected fields %v, got %v", want, fieldErr.Rejected)
+	}
+
+	if _, err := DecodeProfileUpdate([]byte(`{"username": 42}`)); err == nil {
+		t.Error("expected an error for a username of the wrong type")
+	}
+}
+
+func TestEmailProblem(t *testing.T) {
+	for _, email := range []string{"alice@example.com", "a.b+c@sub.example.org"} {
+		if problem := emailProblem(email); problem != "" {
+			t.Errorf("%q: unexpected problem %q", email, problem)
+		}
+	}
+	for _, email := range []string{"", "alice", "Alice <alice@example.com>", "alice@example.com\r\nBcc: x@example.com"} {
+		if emailProblem(email) == "" {
+			t.Errorf("%q: expected to be refused", email)
+		}
+	}
+}
+
+func TestUsernamePattern(t *testing.T) {
+	for _, username := range []string{"alice", "bob_99", "j.doe-2"} {
+		if !usernamePattern.MatchString(username) {
+			t.Errorf("%q: expected to be accepted", username)
+		}
+	}
+	for _, username := range []string{"ab", "alice smith", "<script>", "$where", "a-very-long-username-over-32-chars"} {
+		if usernamePattern.MatchString(username) {
+			t.Errorf("%q: expected to be refused", username)
+		}
+	}
+}
@@ -3,162 +3,319 @@ package user
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
+	"wallet/pkg/auth"
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
 	models "wallet/pkg/models"
-	mongodb "wallet/pkg/mongo"
+	"wallet/pkg/repository"
+	"wallet/pkg/vault"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Assuming models package and User struct are defined elsewhere
-// import "path/to/models"
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Service manages users and their wallets
+type Service struct {
+	users repository.UserRepository
+	auth  *auth.Service
+}
+
+// NewService returns a Service storing users in repos. Sessions are revoked
+// through authService when a user's role changes.
+func NewService(repos repository.Repositories, authService *auth.Service) *Service {
+	return &Service{users: repos.Users, auth: authService}
+}
 
-func CreateUser(userName string, email string, password string) error {
+// CreateUser inserts a new user together with a freshly generated wallet.
+// If any step fails the user is not left behind without a usable wallet.
+func (s *Service) CreateUser(userName string, email string, password string) (models.User, error) {
+	return s.createUser(userName, email, password, false)
+}
+
+// createUser inserts a user, pending email verification if requested
+func (s *Service) createUser(userName string, email string, password string, pendingVerification bool) (models.User, error) {
+	email = models.NormalizeEmail(email)
 
-	exists, err := doesUserExist(email)
+	fieldErr := &FieldError{}
+	if problem := emailProblem(email); problem != "" {
+		fieldErr.invalid("email", problem)
+	}
+	problem, err := s.usernameProblem(userName, primitive.NilObjectID)
 	if err != nil {
-		return err
+		return models.User{}, err
+	}
+	if problem != "" {
+		fieldErr.invalid("username", problem)
+	}
+	if err := fieldErr.orNil(); err != nil {
+		return models.User{}, err
+	}
+
+	exists, err := s.doesUserExist(email)
+	if err != nil {
+		return models.User{}, err
 	}
 
 	if exists {
-		return errors.New("user already exists")
+		return models.User{}, ErrUserExists
 	}
 
-	connection, err := mongodb.Connect()
+	hash, err := auth.HashPassword(password)
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
-		return err
+		return models.User{}, err
 	}
 
-	defer mongodb.DisconnectClient(connection)
+	userID := primitive.NewObjectID()
+
+	walletKey, err := provisionWallet(userID)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to provision wallet: %w", err)
+	}
 
 	userData := models.User{
-		ID:        primitive.NewObjectID(),
+		ID:        userID,
 		Username:  userName,
 		Email:     email,
-		Password:  password, // Consider hashing the password before storing
+		Password:  hash,
+		Wallet:    walletKey,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		Active:    true,
+
+		PendingVerification: pendingVerification,
 	}
 
-	collection := connection.Database("wallet").Collection("users")
+	ctx := context.Background()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// The unique email and username indexes catch users registering
+	// concurrently
+	err = s.users.Create(ctx, userData)
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		return models.User{}, usernameTakenError()
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		return models.User{}, ErrUserExists
+	}
+	if err != nil {
+		return models.User{}, err
+	}
 
-	_, err = collection.InsertOne(ctx, userData)
+	// Read the stored wallet back and make sure it still derives the same address
+	stored, err := s.users.FindByID(ctx, userData.ID)
+	if err == nil {
+		err = verifyWallet(stored.ID, stored.Wallet)
+	}
 	if err != nil {
-		return err
+		if deleteErr := s.users.Delete(ctx, userData.ID); deleteErr != nil {
+			log.Printf("Failed to roll back user %s: %v", userData.ID.Hex(), deleteErr)
+		}
+		return models.User{}, fmt.Errorf("failed to store wallet: %w", err)
 	}
 
-	return nil
+	return userData, nil
 }
 
-func GetUserByID(userID primitive.ObjectID) (models.User, error) {
-	connection, err := mongodb.Connect()
+// provisionWallet generates a wallet and encrypts its mnemonic for the user
+func provisionWallet(userID primitive.ObjectID) (models.WalletKey, error) {
+	keyring, err := vault.LoadKeyring()
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
-		return models.User{}, err
+		return models.WalletKey{}, err
 	}
 
-	defer mongodb.DisconnectClient(connection)
-
-	var userData models.User
-	collection := connection.Database("wallet").Collection("users")
+	registry, err := chains.Load()
+	if err != nil {
+		return models.WalletKey{}, err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	walletKey, err := ethereum.GenerateWallet()
+	if err != nil {
+		return models.WalletKey{}, err
+	}
+	walletKey.ChainIDs = registry.IDs()
 
-	err = collection.FindOne(ctx, primitive.M{"_id": userID}).Decode(&userData)
+	walletKey.Secret, err = keyring.Seal([]byte(walletKey.Mnemonic), userID[:])
 	if err != nil {
-		return models.User{}, err
+		return models.WalletKey{}, err
 	}
+	walletKey.Mnemonic = ""
 
-	return userData, nil
+	if err := verifyWallet(userID, walletKey); err != nil {
+		return models.WalletKey{}, err
+	}
+
+	return walletKey, nil
 }
 
-func UpdateUser(userID primitive.ObjectID, updatedData map[string]interface{}) error {
-	connection, err := mongodb.Connect()
+// OpenWallet decrypts the user's mnemonic and returns the HD wallet
+func OpenWallet(userID primitive.ObjectID, walletKey models.WalletKey) (*ethereum.HDWallet, error) {
+	keyring, err := vault.LoadKeyring()
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
-		return err
+		return nil, err
 	}
-	defer connection.Disconnect(context.Background())
-
-	collection := connection.Database("wallet").Collection("users")
-
-	update := bson.M{}
 
-	for key, value := range updatedData {
-		update[key] = value
+	mnemonic, err := keyring.Open(walletKey.Secret, userID[:])
+	if err != nil {
+		return nil, err
 	}
 
-	update["updated_at"] = time.Now()
+	return ethereum.NewHDWallet(string(mnemonic), "")
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// verifyWallet decrypts the mnemonic and checks every account re-derives
+func verifyWallet(userID primitive.ObjectID, walletKey models.WalletKey) error {
+	if walletKey.PublicKey == "" || len(walletKey.Accounts) == 0 {
+		return errors.New("wallet has no accounts")
+	}
 
-	_, err = collection.UpdateOne(
-		ctx,
-		bson.M{"_id": userID},
-		bson.M{"$set": update},
-		options.Update().SetUpsert(false),
-	)
+	wallet, err := OpenWallet(userID, walletKey)
 	if err != nil {
 		return err
 	}
 
+	for _, account := range walletKey.Accounts {
+		if err := wallet.VerifyAccount(account); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func doesUserExist(email string) (bool, error) {
-	connection, err := mongodb.Connect()
+// RewrapWallets re-wraps every wallet data key that is not wrapped by the
+// active master key. It returns the number of wallets updated.
+func (s *Service) RewrapWallets() (int, error) {
+	keyring, err := vault.LoadKeyring()
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
-		return false, err
+		return 0, err
 	}
 
-	defer connection.Disconnect(context.Background())
+	updated := 0
+	err = s.users.Each(context.Background(), func(userData models.User) error {
+		if userData.Wallet.Secret.Ciphertext == "" {
+			return nil
+		}
+
+		secret, changed, err := keyring.Rewrap(userData.Wallet.Secret, userData.ID[:])
+		if err != nil {
+			return fmt.Errorf("failed to rewrap wallet of user %s: %w", userData.ID.Hex(), err)
+		}
+		if !changed {
+			return nil
+		}
+
+		// Only replace the secret if it has not been rotated concurrently
+		err = s.users.ReplaceWalletSecret(context.Background(), userData.ID, userData.Wallet.Secret, secret)
+		if errors.Is(err, repository.ErrConflict) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		updated++
+
+		return nil
+	})
+
+	return updated, err
+}
 
-	collection := connection.Database("wallet").Collection("users")
+// GetUserByID returns a user, or ErrUserNotFound
+func (s *Service) GetUserByID(userID primitive.ObjectID) (models.User, error) {
+	return found(s.users.FindByID(context.Background(), userID))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// GetUserByEmail returns a user, or ErrUserNotFound
+func (s *Service) GetUserByEmail(email string) (models.User, error) {
+	return found(s.users.FindByEmail(context.Background(), email))
+}
 
-	count, err := collection.CountDocuments(ctx, bson.M{"email": email})
-	if err != nil {
-		return false, err
+// found reports a user lookup that found nothing as ErrUserNotFound
+func found(userData models.User, err error) (models.User, error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.User{}, ErrUserNotFound
 	}
 
-	return count > 0, nil
+	return userData, err
 }
 
-func DeactivateUser(userID primitive.ObjectID) error {
-	connection, err := mongodb.Connect()
-	if err != nil {
-		return err
-	}
-	defer connection.Disconnect(context.Background())
+// ListActiveUsers returns every active user
+func (s *Service) ListActiveUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.users.ListActive(ctx)
+}
 
-	collection := connection.Database("wallet").Collection("users")
+// SetBalances replaces the stored balances of a user on one chain, leaving
+// the balances on other chains untouched
+func (s *Service) SetBalances(userID primitive.ObjectID, chainID int64, balances []models.Balance) error {
+	return s.users.SetBalances(context.Background(), userID, chainID, balances)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func (s *Service) doesUserExist(email string) (bool, error) {
+	return s.users.EmailExists(context.Background(), email)
+}
 
-	// Update the "active" field to false
-	_, err = collection.UpdateOne(
-		ctx,
-		bson.M{"_id": userID},
-		bson.M{"$set": bson.M{"active": false}},
-	)
+func (s *Service) DeactivateUser(userID primitive.ObjectID) error {
+	return s.users.Deactivate(context.Background(), userID)
+}
+
+// SetRole changes the role of a user and revokes their sessions, so the new
+// role applies from their next login
+func (s *Service) SetRole(userID primitive.ObjectID, role string) error {
+	if !auth.ValidRole(role) {
+		return auth.ErrInvalidRole
+	}
+
+	err := s.users.SetRole(context.Background(), userID, role)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrUserNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = s.auth.RevokeAllSessions(userID)
+	return err
+}
+
+// HashPlaintextPasswords hashes every stored password that is not already an
+// Argon2id or bcrypt hash. It returns the number of users updated.
+func (s *Service) HashPlaintextPasswords() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	updated := 0
+	err := s.users.Each(ctx, func(userData models.User) error {
+		if userData.Password == "" || auth.IsPasswordHash(userData.Password) {
+			return nil
+		}
+
+		hash, err := auth.HashPassword(userData.Password)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", userData.ID.Hex(), err)
+		}
+
+		// Only replace the value that was read, in case the user changed it meanwhile
+		err = s.users.ReplacePassword(ctx, userData.ID, userData.Password, hash)
+		if errors.Is(err, repository.ErrConflict) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		updated++
+
+		return nil
+	})
+
+	return updated, err
 }
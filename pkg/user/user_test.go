@@ -0,0 +1,225 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	config "wallet/pkg/config"
+	"wallet/pkg/config/configtest"
+	"wallet/pkg/mailer"
+	"wallet/pkg/repository"
+)
+
+// outbox is a mailer keeping sent messages, or failing if err is set
+type outbox struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (o *outbox) Send(msg mailer.Message) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+// verificationToken returns the token of the last verification link sent
+func (o *outbox) verificationToken(t *testing.T) string {
+	t.Helper()
+
+	for i := len(o.sent) - 1; i >= 0; i-- {
+		_, link, ok := strings.Cut(o.sent[i].Body, "/verify?token=")
+		if !ok {
+			continue
+		}
+		token, err := url.QueryUnescape(strings.Fields(link)[0])
+		if err != nil {
+			t.Fatalf("invalid verification link: %v", err)
+		}
+		return token
+	}
+
+	t.Fatal("no verification link was sent")
+	return ""
+}
+
+// newTestService returns a Service on in-memory repositories, sealing
+// wallets with a test master key
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	configtest.Use(t, configtest.Config())
+
+	repos := repository.NewMemory()
+	return NewService(repos, auth.NewService(repos))
+}
+
+func TestRegisterAndVerify(t *testing.T) {
+	s := newTestService(t)
+	m := &outbox{}
+
+	userData, err := s.RegisterUser("alice", "alice@example.com", "correct horse", m)
+	if err != nil {
+		t.Fatalf("RegisterUser failed: %v", err)
+	}
+	if !userData.PendingVerification || userData.Wallet.Mnemonic != "" {
+		t.Errorf("unexpected user %+v", userData)
+	}
+
+	if _, err := s.RegisterUser("bob", "alice@example.com", "correct horse", m); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+	var fieldErr *FieldError
+	if _, err := s.RegisterUser("ALICE", "bob@example.com", "correct horse", m); !errors.As(err, &fieldErr) {
+		t.Errorf("expected a FieldError for a taken username, got %v", err)
+	}
+
+	verified, err := s.VerifyEmail(m.verificationToken(t))
+	if err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if verified.PendingVerification || verified.EmailVerifiedAt == nil {
+		t.Errorf("expected the email to be verified, got %+v", verified)
+	}
+
+	stored, err := s.GetUserByID(userData.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if err := verifyWallet(stored.ID, stored.Wallet); err != nil {
+		t.Errorf("stored wallet does not verify: %v", err)
+	}
+}
+
+// racingUsers reports every username as free, like a check made just before
+// another request claims the username
+type racingUsers struct {
+	repository.UserRepository
+}
+
+func (racingUsers) UsernameTaken(ctx context.Context, username string, except primitive.ObjectID) (bool, error) {
+	return false, nil
+}
+
+func TestUsernameClaimedConcurrently(t *testing.T) {
+	s := newTestService(t)
+	s.users = racingUsers{s.users}
+
+	alice, err := s.CreateUser("alice", "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	var fieldErr *FieldError
+	if _, err := s.CreateUser("Alice", "other@example.com", "correct horse"); !errors.As(err, &fieldErr) || fieldErr.Invalid["username"] == "" {
+		t.Errorf("expected a username FieldError creating a user, got %v", err)
+	}
+
+	bob, _ := s.CreateUser("bob", "bob@example.com", "correct horse")
+	username := alice.Username
+	if err := s.UpdateUser(bob.ID, ProfileUpdate{Username: &username}); !errors.As(err, &fieldErr) || fieldErr.Invalid["username"] == "" {
+		t.Errorf("expected a username FieldError updating a user, got %v", err)
+	}
+}
+
+func TestRegisterRollsBackWhenMailFails(t *testing.T) {
+	s := newTestService(t)
+
+	_, err := s.RegisterUser("alice", "alice@example.com", "correct horse", &outbox{err: errors.New("smtp down")})
+	if err == nil {
+		t.Fatal("expected RegisterUser to fail")
+	}
+	if _, err := s.GetUserByEmail("alice@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected the user to be removed, got %v", err)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	s := newTestService(t)
+	m := &outbox{}
+
+	userData, err := s.RegisterUser("alice", "alice@example.com", "correct horse", m)
+	if err != nil {
+		t.Fatalf("RegisterUser failed: %v", err)
+	}
+	oldToken := m.verificationToken(t)
+
+	if err := s.ChangeEmail(userData.ID, "wrong", "new@example.com", m); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword, got %v", err)
+	}
+	if err := s.ChangeEmail(userData.ID, "correct horse", "New@Example.com", m); err != nil {
+		t.Fatalf("ChangeEmail failed: %v", err)
+	}
+
+	// A link issued for the previous address no longer verifies anything
+	if _, err := s.VerifyEmail(oldToken); !errors.Is(err, auth.ErrInvalidVerificationToken) {
+		t.Errorf("expected ErrInvalidVerificationToken, got %v", err)
+	}
+	verified, err := s.VerifyEmail(m.verificationToken(t))
+	if err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if verified.Email != "new@example.com" || verified.PendingVerification {
+		t.Errorf("unexpected user %+v", verified)
+	}
+}
+
+func TestRewrapWallets(t *testing.T) {
+	s := newTestService(t)
+
+	userData, err := s.CreateUser("alice", "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	cfg := configtest.Config()
+	cfg.Encryption.MasterKeys = append(cfg.Encryption.MasterKeys, config.Pair{ID: "k2", Value: "second master passphrase"})
+	cfg.Encryption.ActiveMasterKey = "k2"
+	configtest.Use(t, cfg)
+
+	updated, err := s.RewrapWallets()
+	if err != nil || updated != 1 {
+		t.Fatalf("expected one wallet rewrapped, got %d, %v", updated, err)
+	}
+	if updated, _ := s.RewrapWallets(); updated != 0 {
+		t.Errorf("expected nothing left to rewrap, got %d", updated)
+	}
+
+	stored, _ := s.GetUserByID(userData.ID)
+	if stored.Wallet.Secret.KeyID != "k2" {
+		t.Errorf("expected the wallet to be wrapped by k2, got %q", stored.Wallet.Secret.KeyID)
+	}
+	if err := verifyWallet(stored.ID, stored.Wallet); err != nil {
+		t.Errorf("rewrapped wallet does not verify: %v", err)
+	}
+}
+
+func TestLoginWithRegisteredEmail(t *testing.T) {
+	s := newTestService(t)
+	m := &outbox{}
+
+	userData, err := s.RegisterUser("alice", " Alice@Example.com", "correct horse", m)
+	if err != nil {
+		t.Fatalf("RegisterUser failed: %v", err)
+	}
+	if userData.Email != "alice@example.com" {
+		t.Errorf("expected the email to be stored normalized, got %q", userData.Email)
+	}
+	if _, err := s.VerifyEmail(m.verificationToken(t)); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+
+	// Users sign in with the address as they typed it at registration
+	if _, err := s.auth.UserLogin("Alice@Example.com", "correct horse", auth.SessionMeta{}); err != nil {
+		t.Errorf("login with the registered spelling failed: %v", err)
+	}
+	if _, err := s.RegisterUser("alice2", "ALICE@example.com", "correct horse", m); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists for the same address in another case, got %v", err)
+	}
+}
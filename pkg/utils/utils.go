@@ -4,47 +4,276 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-
-	// "errors"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Ciphertexts are encoded as text so they can be stored in JSON and Mongo:
+//
+//	v1$<kdf>$<params>$<salt>$<nonce>$<ciphertext>
+//
+// Binary fields are unpadded base64. The header up to and including the salt
+// is authenticated together with the caller's associated data, so neither the
+// KDF parameters nor the binding to e.g. a user ID can be altered.
+const formatVersion = "v1"
+
+// KDF names the function used to derive the AES key from a passphrase
+type KDF string
+
+const (
+	KDFNone     KDF = "none"
+	KDFScrypt   KDF = "scrypt"
+	KDFArgon2id KDF = "argon2id"
+)
+
+// DefaultKDF is used by EncryptIt and whenever no KDF is requested
+const DefaultKDF = KDFArgon2id
+
+const (
+	keyLength   = 32
+	saltLength  = 16
+	nonceLength = 12
+)
+
+// Upper bounds on parameters read from a ciphertext, so a forged header
+// cannot make decryption exhaust memory
+const (
+	maxScryptN      = 1 << 20
+	maxArgon2Memory = 1 << 20
 )
 
-func encriptIt(key, plaintext string) (string, error) {
-	block, err := aes.NewCipher([]byte(key))
+var (
+	scryptParams   = map[string]int{"n": 32768, "r": 8, "p": 1}
+	argon2idParams = map[string]int{"m": 64 * 1024, "t": 1, "p": 4}
+)
+
+var (
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	ErrUnsupportedKDF    = errors.New("unsupported key derivation function")
+)
+
+// EncryptIt encrypts plaintext with a key derived from the passphrase
+func EncryptIt(key, plaintext string) (string, error) {
+	return Encrypt(key, []byte(plaintext), nil, DefaultKDF)
+}
+
+// DecryptIt decrypts a ciphertext produced by EncryptIt
+func DecryptIt(key, ciphertext string) (string, error) {
+	plaintext, err := Decrypt(key, ciphertext, nil)
 	if err != nil {
 		return "", err
 	}
 
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	return string(plaintext), nil
+}
+
+// Encrypt derives an AES-256 key from the passphrase with the given KDF and
+// encrypts plaintext, binding it to associatedData
+func Encrypt(passphrase string, plaintext, associatedData []byte, kdf KDF) (string, error) {
+	var params map[string]int
+	switch kdf {
+	case KDFScrypt:
+		params = scryptParams
+	case KDFArgon2id:
+		params = argon2idParams
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedKDF, kdf)
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return "", err
 	}
 
-	aesgcm, err := cipher.NewGCM(block)
+	key, err := deriveKey(passphrase, kdf, params, salt)
 	if err != nil {
 		return "", err
 	}
 
-	ciphertext := aesgcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return string(ciphertext), nil
+	return seal(key, header(kdf, params, salt), plaintext, associatedData)
 }
 
-func decriptIt(key, ciphertext string) (string, error) {
-	block, err := aes.NewCipher([]byte(key))
+// Decrypt decrypts a passphrase-encrypted ciphertext produced by Encrypt
+func Decrypt(passphrase string, ciphertext string, associatedData []byte) ([]byte, error) {
+	env, err := parse(ciphertext)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if env.kdf == KDFNone {
+		return nil, fmt.Errorf("%w: ciphertext was sealed with a raw key", ErrInvalidCiphertext)
 	}
 
-	aesgcm, err := cipher.NewGCM(block)
+	key, err := deriveKey(passphrase, env.kdf, env.params, env.salt)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return env.open(key, associatedData)
+}
+
+// Seal encrypts plaintext with a raw 32 byte key, binding it to associatedData
+func Seal(key, plaintext, associatedData []byte) (string, error) {
+	if len(key) != keyLength {
+		return "", fmt.Errorf("key must be %d bytes", keyLength)
+	}
+
+	return seal(key, header(KDFNone, nil, nil), plaintext, associatedData)
+}
+
+// Open decrypts a ciphertext produced by Seal
+func Open(key []byte, ciphertext string, associatedData []byte) ([]byte, error) {
+	env, err := parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.kdf != KDFNone {
+		return nil, fmt.Errorf("%w: ciphertext was sealed with a passphrase", ErrInvalidCiphertext)
+	}
+
+	return env.open(key, associatedData)
+}
+
+// envelope is a parsed versioned ciphertext
+type envelope struct {
+	header     string
+	kdf        KDF
+	params     map[string]int
+	salt       []byte
+	nonce      []byte
+	ciphertext []byte
+}
+
+func (e envelope) open(key, associatedData []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aesgcm.Open(nil, e.nonce, e.ciphertext, additionalData(e.header, associatedData))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
 	}
 
-	nonce, ciphertextBytes := []byte(ciphertext)[:12], []byte(ciphertext)[12:]
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertextBytes, nil)
+	return plaintext, nil
+}
+
+func seal(key []byte, header string, plaintext, associatedData []byte) (string, error) {
+	aesgcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
 
-	return string(plaintext), nil
+	nonce := make([]byte, nonceLength)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := aesgcm.Seal(nil, nonce, plaintext, additionalData(header, associatedData))
+
+	return header + "$" + encode(nonce) + "$" + encode(ciphertext), nil
+}
+
+func parse(s string) (envelope, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[0] != formatVersion {
+		return envelope{}, ErrInvalidCiphertext
+	}
+
+	env := envelope{
+		header: strings.Join(parts[:4], "$"),
+		kdf:    KDF(parts[1]),
+		params: map[string]int{},
+	}
+
+	if parts[2] != "" {
+		for _, pair := range strings.Split(parts[2], ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return envelope{}, ErrInvalidCiphertext
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return envelope{}, ErrInvalidCiphertext
+			}
+			env.params[name] = n
+		}
+	}
+
+	var err error
+	if env.salt, err = decode(parts[3]); err != nil {
+		return envelope{}, ErrInvalidCiphertext
+	}
+	if env.nonce, err = decode(parts[4]); err != nil || len(env.nonce) != nonceLength {
+		return envelope{}, ErrInvalidCiphertext
+	}
+	if env.ciphertext, err = decode(parts[5]); err != nil {
+		return envelope{}, ErrInvalidCiphertext
+	}
+
+	return env, nil
+}
+
+func header(kdf KDF, params map[string]int, salt []byte) string {
+	var encodedParams string
+	switch kdf {
+	case KDFScrypt:
+		encodedParams = fmt.Sprintf("n=%d,r=%d,p=%d", params["n"], params["r"], params["p"])
+	case KDFArgon2id:
+		encodedParams = fmt.Sprintf("m=%d,t=%d,p=%d", params["m"], params["t"], params["p"])
+	}
+
+	return strings.Join([]string{formatVersion, string(kdf), encodedParams, encode(salt)}, "$")
+}
+
+func deriveKey(passphrase string, kdf KDF, params map[string]int, salt []byte) ([]byte, error) {
+	if len(salt) < saltLength {
+		return nil, ErrInvalidCiphertext
+	}
+
+	switch kdf {
+	case KDFScrypt:
+		n, r, p := params["n"], params["r"], params["p"]
+		if n > maxScryptN || r <= 0 || r > 32 || p <= 0 || p > 16 {
+			return nil, ErrInvalidCiphertext
+		}
+		return scrypt.Key([]byte(passphrase), salt, n, r, p, keyLength)
+	case KDFArgon2id:
+		m, t, p := params["m"], params["t"], params["p"]
+		if m <= 0 || m > maxArgon2Memory || t <= 0 || t > 16 || p <= 0 || p > 255 {
+			return nil, ErrInvalidCiphertext
+		}
+		return argon2.IDKey([]byte(passphrase), salt, uint32(t), uint32(m), uint8(p), keyLength), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedKDF, kdf)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func additionalData(header string, associatedData []byte) []byte {
+	return append([]byte(header+"$"), associatedData...)
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
 }
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecryptIt(t *testing.T) {
+	key := "this is a very secret key"
+	plaintext := "this is some text to encrypt"
+
+	ciphertext, err := EncryptIt(key, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptIt failed: %v", err)
+	}
+
+	decrypted, err := DecryptIt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptIt failed: %v", err)
+	}
+
+	if decrypted != plaintext {
+		t.Errorf("Decrypted text doesn't match the original plaintext. got: %s, want: %s", decrypted, plaintext)
+	}
+}
+
+func TestEncryptKDFs(t *testing.T) {
+	for _, kdf := range []KDF{KDFScrypt, KDFArgon2id} {
+		ciphertext, err := Encrypt("passphrase", []byte("secret"), []byte("user-1"), kdf)
+		if err != nil {
+			t.Fatalf("Encrypt(%s) failed: %v", kdf, err)
+		}
+
+		if !strings.HasPrefix(ciphertext, "v1$"+string(kdf)+"$") {
+			t.Errorf("unexpected header: %s", ciphertext)
+		}
+
+		plaintext, err := Decrypt("passphrase", ciphertext, []byte("user-1"))
+		if err != nil || string(plaintext) != "secret" {
+			t.Errorf("Decrypt(%s) = %q, %v", kdf, plaintext, err)
+		}
+
+		if _, err := Decrypt("wrong", ciphertext, []byte("user-1")); err == nil {
+			t.Errorf("Decrypt(%s) accepted the wrong passphrase", kdf)
+		}
+	}
+}
+
+func TestAssociatedDataBinding(t *testing.T) {
+	key := make([]byte, 32)
+
+	ciphertext, err := Seal(key, []byte("secret"), []byte("user-1"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	if _, err := Open(key, ciphertext, []byte("user-2")); err != ErrInvalidCiphertext {
+		t.Errorf("expected ErrInvalidCiphertext for swapped associated data, got %v", err)
+	}
+
+	plaintext, err := Open(key, ciphertext, []byte("user-1"))
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("Open = %q, %v", plaintext, err)
+	}
+}
+
+func TestTamperedHeader(t *testing.T) {
+	ciphertext, err := Encrypt("passphrase", []byte("secret"), nil, KDFScrypt)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	tampered := strings.Replace(ciphertext, "r=8", "r=9", 1)
+	if _, err := Decrypt("passphrase", tampered, nil); err == nil {
+		t.Error("Decrypt accepted a tampered header")
+	}
+
+	if _, err := Decrypt("passphrase", "not a ciphertext", nil); err != ErrInvalidCiphertext {
+		t.Errorf("expected ErrInvalidCiphertext, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	config "wallet/pkg/config"
+	models "wallet/pkg/models"
+	"wallet/pkg/utils"
+)
+
+var (
+	ErrNoMasterKey      = errors.New("no master key configured")
+	ErrUnknownMasterKey = errors.New("unknown master key")
+)
+
+// Keyring holds the master passphrases used to wrap per-secret data keys.
+// Only the active key wraps new data keys; the others are kept so
+// secrets wrapped before a rotation can still be opened and re-wrapped.
+type Keyring struct {
+	active string
+	keys   map[string]string
+}
+
+// NewKeyring builds a keyring from master passphrases indexed by key ID
+func NewKeyring(active string, keys map[string]string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownMasterKey, active)
+	}
+
+	for id, key := range keys {
+		if len(key) < 16 {
+			return nil, fmt.Errorf("master key %q must be at least 16 characters", id)
+		}
+	}
+
+	return &Keyring{active: active, keys: keys}, nil
+}
+
+// LoadKeyring builds the keyring from the configured master keys. The
+// legacy encryption key is accepted as the key "default".
+func LoadKeyring() (*Keyring, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{}
+	for _, key := range cfg.Encryption.Keys() {
+		keys[key.ID] = key.Value
+	}
+
+	return NewKeyring(cfg.Encryption.ActiveKeyID(), keys)
+}
+
+// ActiveKeyID returns the ID of the key used to wrap new data keys
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped by the active master
+// key. Both layers are bound to associatedData, so a secret sealed for one
+// owner cannot be opened as another's.
+func (k *Keyring) Seal(plaintext, associatedData []byte) (models.EncryptedSecret, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return models.EncryptedSecret{}, err
+	}
+
+	ciphertext, err := utils.Seal(dataKey, plaintext, associatedData)
+	if err != nil {
+		return models.EncryptedSecret{}, err
+	}
+
+	wrappedKey, err := utils.Encrypt(k.keys[k.active], dataKey, associatedData, utils.DefaultKDF)
+	if err != nil {
+		return models.EncryptedSecret{}, err
+	}
+
+	return models.EncryptedSecret{
+		KeyID:      k.active,
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open unwraps the data key and decrypts the secret
+func (k *Keyring) Open(secret models.EncryptedSecret, associatedData []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(secret, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.Open(dataKey, secret.Ciphertext, associatedData)
+}
+
+// Rewrap wraps the secret's data key with the active master key. It
+// reports false if nothing changed.
+func (k *Keyring) Rewrap(secret models.EncryptedSecret, associatedData []byte) (models.EncryptedSecret, bool, error) {
+	if secret.KeyID == k.active {
+		return secret, false, nil
+	}
+
+	dataKey, err := k.unwrap(secret, associatedData)
+	if err != nil {
+		return secret, false, err
+	}
+
+	wrappedKey, err := utils.Encrypt(k.keys[k.active], dataKey, associatedData, utils.DefaultKDF)
+	if err != nil {
+		return secret, false, err
+	}
+
+	secret.KeyID = k.active
+	secret.WrappedKey = wrappedKey
+
+	return secret, true, nil
+}
+
+// unwrap decrypts the data key of a secret with the master key it names
+func (k *Keyring) unwrap(secret models.EncryptedSecret, associatedData []byte) ([]byte, error) {
+	masterKey, ok := k.keys[secret.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMasterKey, secret.KeyID)
+	}
+
+	return utils.Decrypt(masterKey, secret.WrappedKey, associatedData)
+}
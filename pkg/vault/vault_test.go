@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string]string{"k1": "first master passphrase"})
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+
+	plaintext := []byte("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about")
+
+	secret, err := keyring.Seal(plaintext, []byte("user-1"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if secret.KeyID != "k1" {
+		t.Errorf("unexpected key ID: %s", secret.KeyID)
+	}
+
+	opened, err := keyring.Open(secret, []byte("user-1"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Opened text doesn't match. got: %s, want: %s", opened, plaintext)
+	}
+
+	if _, err := keyring.Open(secret, []byte("user-2")); err == nil {
+		t.Error("Open accepted a secret bound to another user")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKey := "first master passphrase"
+	newKey := "second master passphrase"
+
+	before, _ := NewKeyring("k1", map[string]string{"k1": oldKey})
+	secret, err := before.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	after, _ := NewKeyring("k2", map[string]string{"k1": oldKey, "k2": newKey})
+	rewrapped, changed, err := after.Rewrap(secret, nil)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if !changed || rewrapped.KeyID != "k2" || rewrapped.Ciphertext != secret.Ciphertext {
+		t.Errorf("unexpected rewrap result: %+v", rewrapped)
+	}
+
+	// Once rewrapped the old master key can be dropped
+	rotated, _ := NewKeyring("k2", map[string]string{"k2": newKey})
+	opened, err := rotated.Open(rewrapped, nil)
+	if err != nil || string(opened) != "secret" {
+		t.Errorf("Open after rotation failed: %q, %v", opened, err)
+	}
+
+	if _, err := rotated.Open(secret, nil); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("expected ErrUnknownMasterKey, got %v", err)
+	}
+
+	if _, changed, _ := rotated.Rewrap(rewrapped, nil); changed {
+		t.Error("Rewrap changed a secret already wrapped by the active key")
+	}
+}
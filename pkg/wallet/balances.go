@@ -0,0 +1,144 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+)
+
+// ReadBalances reads the native and token balances of every wallet account
+// on a chain. All balances are read at the same block so they are consistent.
+func ReadBalances(ctx context.Context, reader ethereum.BalanceReader, chain models.Chain, walletKey models.WalletKey) ([]models.Balance, error) {
+	head, err := reader.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blockNumber := new(big.Int).SetUint64(head)
+	now := time.Now()
+
+	decimals := make(map[string]uint8, len(chain.Tokens))
+	for _, token := range chain.Tokens {
+		d, err := ethereum.TokenDecimals(ctx, reader, common.HexToAddress(token.Address), blockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read decimals of %s: %w", token.Symbol, err)
+		}
+		decimals[token.Address] = d
+	}
+
+	var balances []models.Balance
+	for _, account := range walletKey.Accounts {
+		address := common.HexToAddress(account.Address)
+
+		wei, err := ethereum.NativeBalance(ctx, reader, address, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		balances = append(balances, models.Balance{
+			ChainID:     chain.ID,
+			Name:        chain.NativeSymbol,
+			Symbol:      chain.NativeSymbol,
+			Account:     account.Address,
+			Amount:      models.NewAmount(wei),
+			Decimals:    chain.NativeDecimals,
+			BlockNumber: head,
+			UpdatedAt:   now,
+		})
+
+		for _, token := range chain.Tokens {
+			tokenAddress := common.HexToAddress(token.Address)
+
+			amount, err := ethereum.TokenBalance(ctx, reader, tokenAddress, address, blockNumber)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s balance: %w", token.Symbol, err)
+			}
+
+			balances = append(balances, models.Balance{
+				ChainID:     chain.ID,
+				Name:        token.Symbol,
+				Symbol:      token.Symbol,
+				Address:     tokenAddress.Hex(),
+				Account:     account.Address,
+				Amount:      models.NewAmount(amount),
+				Decimals:    decimals[token.Address],
+				BlockNumber: head,
+				UpdatedAt:   now,
+			})
+		}
+	}
+
+	return balances, nil
+}
+
+// RefreshBalances reads the user's balances on a chain and stores them
+func (s *Service) RefreshBalances(userData models.User, chain models.Chain) ([]models.Balance, error) {
+	if err := checkVerified(userData); err != nil {
+		return nil, err
+	}
+
+	client, err := chains.Client(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	balances, err := ReadBalances(ctx, client, chain, userData.Wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.SetBalances(userData.ID, chain.ID, balances); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// RunBalanceWorker refreshes the balances of every active, verified user on
+// every chain with an RPC URL on each tick, until ctx is cancelled
+func (s *Service) RunBalanceWorker(ctx context.Context, registry *chains.Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		users, err := s.users.ListActiveUsers()
+		if err != nil {
+			log.Printf("Balance worker failed to list users: %v", err)
+			continue
+		}
+
+		for _, chain := range registry.All() {
+			if len(chain.RPCURLs) == 0 {
+				continue
+			}
+
+			for _, userData := range users {
+				if ctx.Err() != nil {
+					return
+				}
+				if userData.PendingVerification {
+					continue
+				}
+				if _, err := s.RefreshBalances(userData, chain); err != nil {
+					log.Printf("Balance worker failed to refresh user %s on %s: %v", userData.ID.Hex(), chain.Name, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	models "wallet/pkg/models"
+)
+
+// fakeReader serves a fixed chain state for one ERC-20 token
+type fakeReader struct {
+	head     uint64
+	native   *big.Int
+	token    common.Address
+	balance  *big.Int
+	decimals int64
+	blocks   []*big.Int
+}
+
+func (f *fakeReader) BlockNumber(ctx context.Context) (uint64, error) {
+	return f.head, nil
+}
+
+func (f *fakeReader) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	f.blocks = append(f.blocks, blockNumber)
+	return f.native, nil
+}
+
+func (f *fakeReader) CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.blocks = append(f.blocks, blockNumber)
+	if *call.To != f.token {
+		return nil, nil
+	}
+
+	if bytes.Equal(call.Data[:4], crypto.Keccak256([]byte("decimals()"))[:4]) {
+		return common.LeftPadBytes(big.NewInt(f.decimals).Bytes(), 32), nil
+	}
+	return common.LeftPadBytes(f.balance.Bytes(), 32), nil
+}
+
+func TestReadBalances(t *testing.T) {
+	token := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	reader := &fakeReader{
+		head:     1234,
+		native:   big.NewInt(1500000000000000000),
+		token:    token,
+		balance:  big.NewInt(2500000),
+		decimals: 6,
+	}
+
+	walletKey := models.WalletKey{Accounts: []models.WalletAccount{
+		{Index: 0, Address: "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+	}}
+
+	chain := models.Chain{
+		ID:             11155111,
+		Name:           "sepolia",
+		NativeSymbol:   "ETH",
+		NativeDecimals: 18,
+		Tokens:         []models.ChainToken{{Symbol: "USDC", Address: token.Hex()}},
+	}
+
+	balances, err := ReadBalances(context.Background(), reader, chain, walletKey)
+	if err != nil {
+		t.Fatalf("ReadBalances failed: %v", err)
+	}
+
+	if len(balances) != 2 {
+		t.Fatalf("expected 2 balances, got %d", len(balances))
+	}
+	if balances[0].Symbol != "ETH" || balances[0].Amount.Format(balances[0].Decimals) != "1.5" {
+		t.Errorf("unexpected native balance: %+v", balances[0])
+	}
+	if balances[1].Symbol != "USDC" || balances[1].Amount.String() != "2500000" || balances[1].Decimals != 6 || balances[1].Address != token.Hex() {
+		t.Errorf("unexpected token balance: %+v", balances[1])
+	}
+
+	for _, b := range balances {
+		if b.ChainID != chain.ID {
+			t.Errorf("balance tagged with chain %d, want %d", b.ChainID, chain.ID)
+		}
+		if b.BlockNumber != 1234 {
+			t.Errorf("balance read at block %d, want 1234", b.BlockNumber)
+		}
+	}
+	for _, n := range reader.blocks {
+		if n.Uint64() != 1234 {
+			t.Errorf("call made at block %s, want 1234", n)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+	mongodb "wallet/pkg/mongo"
+)
+
+// MigrateFloatBalances converts balances stored as float64 whole units into
+// exact base unit amounts. Balances stored before chains were tracked are
+// tagged with the default chain, and token decimals are read from that chain
+// when the stored balance does not carry them. It returns the number of users
+// updated. It works on the raw documents of db, as such balances no longer
+// decode into users.
+func MigrateFloatBalances(db *mongodb.DB) (int, error) {
+	registry, err := chains.Load()
+	if err != nil {
+		return 0, err
+	}
+	chain := registry.Default()
+
+	collection := db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{"balances.amount": bson.M{"$type": "double"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	decimals := tokenDecimalsLookup(ctx, chain)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Balances []bson.M           `bson:"balances"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return updated, err
+		}
+
+		for _, balance := range doc.Balances {
+			units, ok := balance["amount"].(float64)
+			if !ok {
+				continue
+			}
+
+			d, err := balanceDecimals(balance, decimals)
+			if err != nil {
+				return updated, fmt.Errorf("user %s: %w", doc.ID.Hex(), err)
+			}
+
+			amount, err := models.FloatToAmount(units, d)
+			if err != nil {
+				return updated, fmt.Errorf("user %s: %w", doc.ID.Hex(), err)
+			}
+			balance["amount"] = amount.String()
+			balance["decimals"] = int32(d)
+			if _, ok := balance["chain_id"]; !ok {
+				balance["chain_id"] = chain.ID
+			}
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": doc.ID},
+			bson.M{"$set": bson.M{"balances": doc.Balances}},
+		)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, cursor.Err()
+}
+
+// balanceDecimals returns the decimals of a legacy balance document
+func balanceDecimals(balance bson.M, lookup func(common.Address) (uint8, error)) (uint8, error) {
+	switch d := balance["decimals"].(type) {
+	case int32:
+		return uint8(d), nil
+	case int64:
+		return uint8(d), nil
+	}
+
+	address, _ := balance["address"].(string)
+	if address == "" {
+		return 18, nil
+	}
+	if !common.IsHexAddress(address) {
+		return 0, fmt.Errorf("invalid token address %q", address)
+	}
+
+	return lookup(common.HexToAddress(address))
+}
+
+// tokenDecimalsLookup returns a cached decimals() reader for a chain
+func tokenDecimalsLookup(ctx context.Context, chain models.Chain) func(common.Address) (uint8, error) {
+	cache := map[common.Address]uint8{}
+
+	return func(token common.Address) (uint8, error) {
+		if d, ok := cache[token]; ok {
+			return d, nil
+		}
+
+		client, err := chains.Client(chain)
+		if err != nil {
+			return 0, fmt.Errorf("cannot read decimals of %s: %w", token.Hex(), err)
+		}
+
+		d, err := ethereum.TokenDecimals(ctx, client, token, nil)
+		if err != nil {
+			return 0, err
+		}
+		cache[token] = d
+
+		return d, nil
+	}
+}
@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/ethereum"
+	models "wallet/pkg/models"
+	"wallet/pkg/repository"
+	user "wallet/pkg/user"
+	"wallet/pkg/vault"
+)
+
+var (
+	ErrAccountNotFound  = errors.New("address does not belong to this wallet")
+	ErrChainMismatch    = errors.New("RPC endpoint serves a different chain")
+	ErrEmailNotVerified = errors.New("email address must be verified before using the wallet")
+	ErrWalletChanged    = errors.New("wallet was changed concurrently, try again")
+)
+
+// Service signs transfers, derives accounts and keeps balances up to date
+type Service struct {
+	transactions repository.TransactionRepository
+	wallets      repository.WalletRepository
+	users        *user.Service
+
+	// senders serializes transfers per chain and sending address, so
+	// concurrent transfers do not take the same nonce
+	senders sync.Map
+}
+
+// sender identifies an account on a chain
+type sender struct {
+	chainID int64
+	address common.Address
+}
+
+// lockSender holds the lock of an account until the returned function is
+// called. The lock only covers this process.
+func (s *Service) lockSender(chainID int64, address common.Address) func() {
+	lock, _ := s.senders.LoadOrStore(sender{chainID, address}, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+
+	return mu.Unlock
+}
+
+// NewService returns a Service storing transactions and wallets in repos and
+// balances through users
+func NewService(repos repository.Repositories, users *user.Service) *Service {
+	return &Service{transactions: repos.Transactions, wallets: repos.Users, users: users}
+}
+
+// checkVerified rejects users who have not verified their email yet
+func checkVerified(userData models.User) error {
+	if userData.PendingVerification {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+// FindAccount returns the wallet account with the given address
+func FindAccount(walletKey models.WalletKey, address string) (models.WalletAccount, error) {
+	if !common.IsHexAddress(address) {
+		return models.WalletAccount{}, ErrAccountNotFound
+	}
+
+	for _, account := range walletKey.Accounts {
+		if common.HexToAddress(account.Address) == common.HexToAddress(address) {
+			return account, nil
+		}
+	}
+
+	return models.WalletAccount{}, ErrAccountNotFound
+}
+
+// NewAccount derives the next account of the user's wallet and stores it.
+// The wallet secret is resealed under the active master key if it is not
+// already.
+func (s *Service) NewAccount(userData models.User) (models.WalletAccount, error) {
+	if err := checkVerified(userData); err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	keyring, err := vault.LoadKeyring()
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	secret, _, err := keyring.Rewrap(userData.Wallet.Secret, userData.ID[:])
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	hdWallet, err := user.OpenWallet(userData.ID, userData.Wallet)
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	walletKey := userData.Wallet
+	account, err := ethereum.NextAccount(&walletKey, hdWallet)
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	err = s.wallets.AddWalletAccount(context.Background(), userData.ID, userData.Wallet.Secret, secret, account)
+	if errors.Is(err, repository.ErrConflict) {
+		return models.WalletAccount{}, ErrWalletChanged
+	}
+	if err != nil {
+		return models.WalletAccount{}, err
+	}
+
+	return account, nil
+}
+
+// Transfer sends value wei from one of the user's accounts on a chain and
+// records the transaction. The record is stored as pending once the
+// transaction is signed and before it is broadcast, and marked failed if the
+// broadcast is rejected.
+func (s *Service) Transfer(userData models.User, chain models.Chain, from string, to common.Address, value *big.Int) (models.Transaction, error) {
+	if err := checkVerified(userData); err != nil {
+		return models.Transaction{}, err
+	}
+
+	account, err := FindAccount(userData.Wallet, from)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	hdWallet, err := user.OpenWallet(userData.ID, userData.Wallet)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	if err := hdWallet.VerifyAccount(account); err != nil {
+		return models.Transaction{}, err
+	}
+
+	_, privateKey, err := hdWallet.DeriveAccount(account.Index)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	client, err := chains.Client(chain)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Never sign for a chain other than the one the user asked for
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	if chainID.Cmp(big.NewInt(chain.ID)) != 0 {
+		return models.Transaction{}, fmt.Errorf("%w: expected %d, got %s", ErrChainMismatch, chain.ID, chainID)
+	}
+
+	// Hold the account from fetching the nonce until the broadcast
+	unlock := s.lockSender(chain.ID, common.HexToAddress(account.Address))
+	defer unlock()
+
+	var signed *types.Transaction
+	if chain.EIP1559 {
+		signed, err = ethereum.NewSignedTransfer(ctx, client, privateKey, to, value)
+	} else {
+		signed, err = ethereum.NewSignedLegacyTransfer(ctx, client, privateKey, to, value)
+	}
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	// A transaction that cannot be recorded is not sent
+	record := newTransaction(userData.ID, account.Address, signed)
+	if err := s.transactions.Create(context.Background(), record); err != nil {
+		return models.Transaction{}, err
+	}
+
+	sendErr := client.SendTransaction(ctx, signed)
+	if sendErr == nil {
+		return record, nil
+	}
+
+	record.Status = models.TxStatusFailed
+	record.Error = sendErr.Error()
+	record.UpdatedAt = time.Now()
+	if err := s.transactions.SetStatus(context.Background(), record.ID, record.Status, record.Error, record.UpdatedAt); err != nil {
+		return record, err
+	}
+
+	return record, sendErr
+}
+
+// newTransaction builds a pending transaction record from a signed transaction
+func newTransaction(userID primitive.ObjectID, from string, tx *types.Transaction) models.Transaction {
+	now := time.Now()
+
+	return models.Transaction{
+		ID:                   primitive.NewObjectID(),
+		UserID:               userID,
+		ChainID:              tx.ChainId().Int64(),
+		Hash:                 tx.Hash().Hex(),
+		From:                 from,
+		To:                   tx.To().Hex(),
+		Value:                models.NewAmount(tx.Value()),
+		Nonce:                tx.Nonce(),
+		Gas:                  tx.Gas(),
+		MaxFeePerGas:         tx.GasFeeCap().String(),
+		MaxPriorityFeePerGas: tx.GasTipCap().String(),
+		Status:               models.TxStatusPending,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+}
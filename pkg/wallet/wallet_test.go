@@ -0,0 +1,85 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"wallet/pkg/auth"
+	config "wallet/pkg/config"
+	"wallet/pkg/config/configtest"
+	"wallet/pkg/repository"
+	user "wallet/pkg/user"
+)
+
+func TestLockSenderSerializesAccounts(t *testing.T) {
+	s := &Service{}
+	address := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	unlock := s.lockSender(1, address)
+
+	// Other chains and addresses are not held up
+	s.lockSender(5, address)()
+	s.lockSender(1, common.HexToAddress("0x00000000000000000000000000000000000000bb"))()
+
+	acquired := make(chan struct{})
+	go func() {
+		s.lockSender(1, address)()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("a second transfer from the account must wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("the second transfer did not proceed once the first finished")
+	}
+}
+
+func TestNewAccount(t *testing.T) {
+	configtest.Use(t, configtest.Config())
+
+	repos := repository.NewMemory()
+	users := user.NewService(repos, auth.NewService(repos))
+	s := NewService(repos, users)
+
+	userData, err := users.CreateUser("alice", "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// Rotating the master key reseals the wallet as an account is added
+	cfg := configtest.Config()
+	cfg.Encryption.MasterKeys = append(cfg.Encryption.MasterKeys, config.Pair{ID: "k2", Value: "second master passphrase"})
+	cfg.Encryption.ActiveMasterKey = "k2"
+	configtest.Use(t, cfg)
+
+	account, err := s.NewAccount(userData)
+	if err != nil {
+		t.Fatalf("NewAccount failed: %v", err)
+	}
+	if account.Index != 1 || account.Address == userData.Wallet.PublicKey {
+		t.Errorf("unexpected account %+v", account)
+	}
+
+	stored, _ := users.GetUserByID(userData.ID)
+	if len(stored.Wallet.Accounts) != 2 || stored.Wallet.NextIndex != 2 || stored.Wallet.Secret.KeyID != "k2" {
+		t.Errorf("unexpected stored wallet %+v", stored.Wallet)
+	}
+	if found, err := FindAccount(stored.Wallet, account.Address); err != nil || found != account {
+		t.Errorf("FindAccount = %+v, %v", found, err)
+	}
+
+	// A stale copy of the user cannot derive the same index again
+	if _, err := s.NewAccount(userData); !errors.Is(err, ErrWalletChanged) {
+		t.Errorf("expected ErrWalletChanged for a stale wallet, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers (RFC 9053)
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+// COSE key types and curves
+const (
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// parseCOSEKey decodes a COSE_Key into a Go public key
+func parseCOSEKey(data []byte) (int64, crypto.PublicKey, error) {
+	var header struct {
+		KeyType   int64 `cbor:"1,keyasint"`
+		Algorithm int64 `cbor:"3,keyasint"`
+	}
+	if err := cbor.Unmarshal(data, &header); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+	}
+
+	switch {
+	case header.KeyType == ktyEC2 && header.Algorithm == algES256:
+		var key struct {
+			Curve int64  `cbor:"-1,keyasint"`
+			X     []byte `cbor:"-2,keyasint"`
+			Y     []byte `cbor:"-3,keyasint"`
+		}
+		if err := cbor.Unmarshal(data, &key); err != nil || key.Curve != crvP256 || len(key.X) != 32 || len(key.Y) != 32 {
+			return 0, nil, fmt.Errorf("%w: invalid P-256 key", ErrUnsupportedKey)
+		}
+
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(key.X), Y: new(big.Int).SetBytes(key.Y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return 0, nil, fmt.Errorf("%w: point is not on the curve", ErrUnsupportedKey)
+		}
+		return algES256, public, nil
+
+	case header.KeyType == ktyOKP && header.Algorithm == algEdDSA:
+		var key struct {
+			Curve int64  `cbor:"-1,keyasint"`
+			X     []byte `cbor:"-2,keyasint"`
+		}
+		if err := cbor.Unmarshal(data, &key); err != nil || key.Curve != crvEd25519 || len(key.X) != ed25519.PublicKeySize {
+			return 0, nil, fmt.Errorf("%w: invalid Ed25519 key", ErrUnsupportedKey)
+		}
+		return algEdDSA, ed25519.PublicKey(key.X), nil
+
+	case header.KeyType == ktyRSA && header.Algorithm == algRS256:
+		var key struct {
+			N []byte `cbor:"-1,keyasint"`
+			E []byte `cbor:"-2,keyasint"`
+		}
+		if err := cbor.Unmarshal(data, &key); err != nil || len(key.N) < 256 || len(key.E) == 0 || len(key.E) > 4 {
+			return 0, nil, fmt.Errorf("%w: invalid RSA key", ErrUnsupportedKey)
+		}
+		return algRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(key.N), E: int(new(big.Int).SetBytes(key.E).Int64())}, nil
+	}
+
+	return 0, nil, fmt.Errorf("%w: key type %d, algorithm %d", ErrUnsupportedKey, header.KeyType, header.Algorithm)
+}
+
+// coseAlgorithm validates a COSE_Key and returns its algorithm
+func coseAlgorithm(data []byte) (int64, error) {
+	alg, _, err := parseCOSEKey(data)
+	return alg, err
+}
+
+// verifySignature checks a signature made with a COSE_Key
+func verifySignature(keyData []byte, signed []byte, signature []byte) error {
+	alg, public, err := parseCOSEKey(keyData)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch alg {
+	case algES256:
+		valid = ecdsa.VerifyASN1(public.(*ecdsa.PublicKey), digest[:], signature)
+	case algEdDSA:
+		valid = ed25519.Verify(public.(ed25519.PublicKey), signed, signature)
+	case algRS256:
+		valid = rsa.VerifyPKCS1v15(public.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
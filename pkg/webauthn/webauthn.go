@@ -0,0 +1,395 @@
+// Package webauthn verifies WebAuthn registration and assertion ceremonies
+// (https://www.w3.org/TR/webauthn-2/). Attestation statements are not
+// checked against trust anchors: the relying party asks for "none"
+// attestation, so only the credential's own signatures are verified.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// ChallengeTTL is how long a ceremony may take
+const ChallengeTTL = 5 * time.Minute
+
+var (
+	ErrInvalidClientData = errors.New("invalid client data")
+	ErrInvalidAuthData   = errors.New("invalid authenticator data")
+	ErrChallengeMismatch = errors.New("challenge does not match")
+	ErrOriginMismatch    = errors.New("origin is not allowed")
+	ErrRPIDMismatch      = errors.New("relying party ID does not match")
+	ErrUserNotPresent    = errors.New("user presence was not confirmed")
+	ErrUserNotVerified   = errors.New("user verification was not performed")
+	ErrInvalidSignature  = errors.New("invalid assertion signature")
+	ErrCounterRegressed  = errors.New("signature counter did not increase, the authenticator may be cloned")
+)
+
+// Config identifies the relying party
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	Algorithm int64
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// URLEncoding is the unpadded base64url encoding WebAuthn uses for binary
+// values in JSON
+var URLEncoding = base64.RawURLEncoding
+
+// Bytes is binary data encoded as unpadded base64url in JSON
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(URLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	// Accept padded and standard base64 from lenient clients
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding} {
+		if decoded, err := encoding.DecodeString(s); err == nil {
+			*b = decoded
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid base64url value %q", s)
+}
+
+// NewChallenge returns a random 32-byte challenge
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// User is the account a credential is created for
+type User struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialDescriptor names an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// CreationOptions are the publicKey options of navigator.credentials.create
+type CreationOptions struct {
+	Challenge Bytes `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User             User `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// NewCreationOptions builds registration options for a user. Credentials the
+// user already has are excluded so an authenticator is not registered twice.
+// Credentials must be discoverable, since logins do not name the user.
+func (cfg Config) NewCreationOptions(challenge []byte, user User, existing [][]byte) CreationOptions {
+	var options CreationOptions
+	options.Challenge = challenge
+	options.RP.ID = cfg.RPID
+	options.RP.Name = cfg.RPName
+	options.User = user
+	for _, alg := range []int64{algES256, algEdDSA, algRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{"public-key", alg})
+	}
+	options.Timeout = ChallengeTTL.Milliseconds()
+	options.ExcludeCredentials = descriptors(existing)
+	options.AuthenticatorSelection.ResidentKey = "required"
+	options.AuthenticatorSelection.UserVerification = "required"
+	options.Attestation = "none"
+
+	return options
+}
+
+// NewRequestOptions builds login options. With no allowed credentials the
+// authenticator offers its discoverable credentials (passkeys).
+func (cfg Config) NewRequestOptions(challenge []byte, allowed [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             cfg.RPID,
+		Timeout:          ChallengeTTL.Milliseconds(),
+		AllowCredentials: descriptors(allowed),
+		UserVerification: "required",
+	}
+}
+
+// AttestationResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.create
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AttestationObject Bytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// VerifyRegistration checks a registration response against its challenge
+// and returns the new credential
+func (cfg Config) VerifyRegistration(challenge []byte, response AttestationResponse) (Credential, error) {
+	if response.Type != "public-key" {
+		return Credential{}, fmt.Errorf("unexpected credential type %q", response.Type)
+	}
+
+	if err := cfg.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	var attestation struct {
+		Format   string          `cbor:"fmt"`
+		AuthData []byte          `cbor:"authData"`
+		Stmt     cbor.RawMessage `cbor:"attStmt"`
+	}
+	if err := cbor.Unmarshal(response.Response.AttestationObject, &attestation); err != nil {
+		return Credential{}, fmt.Errorf("invalid attestation object: %w", err)
+	}
+
+	authData, err := parseAuthData(attestation.AuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := cfg.verifyAuthData(authData); err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return Credential{}, fmt.Errorf("%w: no attested credential data", ErrInvalidAuthData)
+	}
+
+	alg, err := coseAlgorithm(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if len(response.RawID) > 0 && !bytes.Equal(response.RawID, authData.credentialID) {
+		return Credential{}, errors.New("credential ID does not match authenticator data")
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		Algorithm: alg,
+		SignCount: authData.signCount,
+		AAGUID:    authData.aaguid,
+	}, nil
+}
+
+// VerifyAssertion checks a login response for a stored credential and
+// returns the credential's new signature counter
+func (cfg Config) VerifyAssertion(challenge []byte, credential Credential, response AssertionResponse) (uint32, error) {
+	if response.Type != "public-key" {
+		return 0, fmt.Errorf("unexpected credential type %q", response.Type)
+	}
+	if !bytes.Equal(response.RawID, credential.ID) {
+		return 0, errors.New("assertion is for another credential")
+	}
+
+	clientData := response.Response.ClientDataJSON
+	if err := cfg.verifyClientData(clientData, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthData(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := cfg.verifyAuthData(authData); err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := verifySignature(credential.PublicKey, signed, response.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that do not count always report zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrCounterRegressed
+	}
+
+	return authData.signCount, nil
+}
+
+// verifyClientData checks the type, challenge and origin of clientDataJSON
+func (cfg Config) verifyClientData(data []byte, ceremony string, challenge []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(data, &clientData); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: type %q", ErrInvalidClientData, clientData.Type)
+	}
+
+	got, err := URLEncoding.DecodeString(clientData.Challenge)
+	if err != nil || !bytes.Equal(got, challenge) {
+		return ErrChallengeMismatch
+	}
+
+	if clientData.CrossOrigin {
+		return ErrOriginMismatch
+	}
+	for _, origin := range cfg.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q", ErrOriginMismatch, clientData.Origin)
+}
+
+// verifyAuthData checks the relying party and the user presence and
+// verification flags
+func (cfg Config) verifyAuthData(authData authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(cfg.RPID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	if authData.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+
+	return nil
+}
+
+// authenticatorData is the parsed authData structure
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses authenticator data (WebAuthn section 6.1)
+func parseAuthData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, fmt.Errorf("%w: too short", ErrInvalidAuthData)
+	}
+
+	authData := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return authData, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, fmt.Errorf("%w: truncated attested credential data", ErrInvalidAuthData)
+	}
+	authData.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return authenticatorData{}, fmt.Errorf("%w: invalid credential ID length", ErrInvalidAuthData)
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The public key is a CBOR map, possibly followed by extensions
+	var key cbor.RawMessage
+	decoder := cbor.NewDecoder(bytes.NewReader(rest))
+	if err := decoder.Decode(&key); err != nil {
+		return authenticatorData{}, fmt.Errorf("%w: invalid public key: %v", ErrInvalidAuthData, err)
+	}
+	authData.publicKey = key
+
+	return authData, nil
+}
+
+// descriptors turns credential IDs into credential descriptors
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return list
+}
@@ -0,0 +1,261 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var testConfig = Config{RPID: "wallet.example.com", RPName: "Wallet", Origins: []string{"https://wallet.example.com"}}
+
+// softAuthenticator is a software authenticator holding one credential
+type softAuthenticator struct {
+	t          *testing.T
+	id         []byte
+	ecKey      *ecdsa.PrivateKey
+	edKey      ed25519.PrivateKey
+	counter    uint32
+	rpID       string
+	origin     string
+	skipVerify bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	t.Helper()
+
+	a := &softAuthenticator{t: t, id: make([]byte, 16), rpID: testConfig.RPID, origin: testConfig.Origins[0]}
+	rand.Read(a.id)
+
+	var err error
+	switch alg {
+	case algES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	var key map[int]interface{}
+	if a.ecKey != nil {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.ecKey.X.FillBytes(x)
+		a.ecKey.Y.FillBytes(y)
+		key = map[int]interface{}{1: ktyEC2, 3: algES256, -1: crvP256, -2: x, -3: y}
+	} else {
+		key = map[int]interface{}{1: ktyOKP, 3: algEdDSA, -1: crvEd25519, -2: []byte(a.edKey.Public().(ed25519.PublicKey))}
+	}
+
+	data, err := cbor.Marshal(key)
+	if err != nil {
+		a.t.Fatalf("cbor.Marshal failed: %v", err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(flagUserPresent | flagUserVerified)
+	if a.skipVerify {
+		flags = flagUserPresent
+	}
+	if attested {
+		flags |= flagAttestedData
+	}
+
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      ceremony,
+		"challenge": URLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// create performs navigator.credentials.create
+func (a *softAuthenticator) create(challenge []byte) AttestationResponse {
+	object, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(true),
+	})
+	if err != nil {
+		a.t.Fatalf("cbor.Marshal failed: %v", err)
+	}
+
+	var response AttestationResponse
+	response.ID = URLEncoding.EncodeToString(a.id)
+	response.RawID = a.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	response.Response.AttestationObject = object
+	return response
+}
+
+// get performs navigator.credentials.get
+func (a *softAuthenticator) get(challenge []byte) AssertionResponse {
+	a.counter++
+	authData := a.authData(false)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var signature []byte
+	if a.ecKey != nil {
+		digest := sha256.Sum256(signed)
+		var err error
+		signature, err = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+		if err != nil {
+			a.t.Fatalf("SignASN1 failed: %v", err)
+		}
+	} else {
+		signature = ed25519.Sign(a.edKey, signed)
+	}
+
+	var response AssertionResponse
+	response.ID = URLEncoding.EncodeToString(a.id)
+	response.RawID = a.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	return response
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for name, alg := range map[string]int64{"ES256": algES256, "EdDSA": algEdDSA} {
+		authenticator := newSoftAuthenticator(t, alg)
+
+		challenge, _ := NewChallenge()
+		credential, err := testConfig.VerifyRegistration(challenge, authenticator.create(challenge))
+		if err != nil {
+			t.Fatalf("%s: VerifyRegistration failed: %v", name, err)
+		}
+		if string(credential.ID) != string(authenticator.id) || credential.Algorithm != alg {
+			t.Errorf("%s: unexpected credential %+v", name, credential)
+		}
+
+		for i := 0; i < 2; i++ {
+			challenge, _ = NewChallenge()
+			count, err := testConfig.VerifyAssertion(challenge, credential, authenticator.get(challenge))
+			if err != nil {
+				t.Fatalf("%s: VerifyAssertion failed: %v", name, err)
+			}
+			if count != authenticator.counter {
+				t.Errorf("%s: expected counter %d, got %d", name, authenticator.counter, count)
+			}
+			credential.SignCount = count
+		}
+	}
+}
+
+func TestResponsesJSONRoundTrip(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, algES256)
+	challenge, _ := NewChallenge()
+
+	data, err := json.Marshal(authenticator.create(challenge))
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+
+	var response AttestationResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if _, err := testConfig.VerifyRegistration(challenge, response); err != nil {
+		t.Errorf("VerifyRegistration after a JSON round trip failed: %v", err)
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, algES256)
+	challenge, _ := NewChallenge()
+	credential, err := testConfig.VerifyRegistration(challenge, authenticator.create(challenge))
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse)
+		want   error
+	}{
+		{"wrong challenge", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			other, _ := NewChallenge()
+			return challenge, a.get(other)
+		}, ErrChallengeMismatch},
+		{"wrong origin", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			a.origin = "https://phishing.example.com"
+			defer func() { a.origin = testConfig.Origins[0] }()
+			return challenge, a.get(challenge)
+		}, ErrOriginMismatch},
+		{"wrong relying party", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			a.rpID = "phishing.example.com"
+			defer func() { a.rpID = testConfig.RPID }()
+			return challenge, a.get(challenge)
+		}, ErrRPIDMismatch},
+		{"no user verification", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			a.skipVerify = true
+			defer func() { a.skipVerify = false }()
+			return challenge, a.get(challenge)
+		}, ErrUserNotVerified},
+		{"tampered signature", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			response := a.get(challenge)
+			response.Response.AuthenticatorData[36]++
+			return challenge, response
+		}, ErrInvalidSignature},
+		{"cloned authenticator", func(a *softAuthenticator, challenge []byte) ([]byte, AssertionResponse) {
+			a.counter = 0
+			return challenge, a.get(challenge)
+		}, ErrCounterRegressed},
+	}
+
+	credential.SignCount = 5
+	for _, test := range tests {
+		authenticator.counter = credential.SignCount
+		challenge, _ := NewChallenge()
+		challenge, response := test.modify(authenticator, challenge)
+
+		if _, err := testConfig.VerifyAssertion(challenge, credential, response); !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestRegistrationRejectsWrongCeremony(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, algES256)
+	challenge, _ := NewChallenge()
+
+	response := authenticator.create(challenge)
+	response.Response.ClientDataJSON = authenticator.clientData("webauthn.get", challenge)
+
+	if _, err := testConfig.VerifyRegistration(challenge, response); !errors.Is(err, ErrInvalidClientData) {
+		t.Errorf("expected ErrInvalidClientData, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	user "wallet/pkg/user"
+)
+
+// Authorize allows the request if the caller's role holds the permission on
+// the user named by the :id parameter: on any user, or only on themselves
+// for auth.ScopeOwn. It must run after AuthMiddleware.
+func Authorize(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch auth.PermissionScope(c.GetString("role"), permission) {
+		case auth.ScopeAny:
+			c.Next()
+			return
+		case auth.ScopeOwn:
+			if c.Param("id") == c.MustGet("user_id").(primitive.ObjectID).Hex() {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action"})
+		c.Abort()
+	}
+}
+
+// setUserRole changes the role of a user (protected by AuthMiddleware,
+// admins only)
+func setUserRole(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = userService.SetRole(userID, request.Role)
+	switch {
+	case errors.Is(err, auth.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Role updated, the user's sessions were revoked", "role": request.Role})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet/pkg/auth"
+	user "wallet/pkg/user"
+)
+
+// RegisterRequest is the body of a self-service registration
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// registerUser creates an account pending email verification and mails a
+// verification link to the given address
+func registerUser(c *gin.Context) {
+	var request RegisterRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	createdUser, err := userService.RegisterUser(request.Username, request.Email, request.Password, mail)
+	if err != nil {
+		respondFieldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "User registered, check your email to verify your address",
+		"id":      createdUser.ID,
+	})
+}
+
+// resendVerification mails a new verification link. The response is the
+// same whether or not the address is registered.
+func resendVerification(c *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := userService.ResendVerification(request.Email, mail); err != nil && !errors.Is(err, user.ErrAlreadyVerified) {
+		log.Printf("Failed to resend verification email: %v", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address is awaiting verification, a new link has been sent"})
+}
+
+// verifyPage asks the user to confirm the verification of their address.
+// The token is posted back by the form.
+var verifyPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Verify your email address</title></head>
+<body>
+<form method="post" action="/verify">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Verify my email address</button>
+</form>
+</body>
+</html>
+`))
+
+// confirmVerification renders the page the verification link opens. The
+// link does not verify on its own, since mail scanners prefetch links.
+func confirmVerification(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token is required"})
+		return
+	}
+
+	var page bytes.Buffer
+	if err := verifyPage.Execute(&page, token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep the token out of caches and of the Referer of other requests
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// verifyEmail completes email verification with the token from the link,
+// given as the token query parameter, a form field or in a JSON body
+func verifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	if token == "" {
+		var request struct {
+			Token string `json:"token"`
+		}
+		_ = c.ShouldBindJSON(&request)
+		token = request.Token
+	}
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token is required"})
+		return
+	}
+
+	verifiedUser, err := userService.VerifyEmail(token)
+	if errors.Is(err, auth.ErrInvalidVerificationToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "id": verifiedUser.ID})
+}
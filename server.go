@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+
+	config "wallet/pkg/config"
+)
+
+// newServer returns an HTTP server for handler with the configured address
+// and timeouts
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// loadConfig loads and validates the configuration and makes it the one
+// the packages use
+func loadConfig() (config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return config.Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return config.Config{}, err
+	}
+
+	config.Use(cfg)
+	return cfg, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+)
+
+// refreshToken exchanges a refresh token for new access and refresh tokens.
+// The refresh token presented is spent; reusing it logs the session out.
+func refreshToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.ValidateAndRefreshToken(request.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrSessionRevoked) || errors.Is(err, auth.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// jwks publishes the public keys access tokens are verified with, so other
+// services can check wallet tokens
+func jwks(c *gin.Context) {
+	ks, err := auth.CurrentKeySet()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ks.JWKS())
+}
+
+// listSessions returns the active sessions of the authenticated user,
+// flagging the one making the request (protected by AuthMiddleware)
+func listSessions(c *gin.Context) {
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+	current := c.MustGet("session_id").(primitive.ObjectID)
+
+	sessions, err := authService.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "current": current})
+}
+
+// revokeSession logs out one session of the authenticated user (protected by
+// AuthMiddleware)
+func revokeSession(c *gin.Context) {
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	err = authService.RevokeSession(c.MustGet("user_id").(primitive.ObjectID), sessionID)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// logout ends the session making the request (protected by AuthMiddleware)
+func logout(c *gin.Context) {
+	err := authService.RevokeSession(c.MustGet("user_id").(primitive.ObjectID), c.MustGet("session_id").(primitive.ObjectID))
+	if err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// logoutAll ends every session of the authenticated user, including the one
+// making the request (protected by AuthMiddleware)
+func logoutAll(c *gin.Context) {
+	revoked, err := authService.RevokeAllSessions(c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere", "revoked": revoked})
+}
+
+// expireSessions deactivates expired sessions on each tick, until ctx is
+// cancelled
+func expireSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := authService.ExpireOldTokens(); err != nil {
+			log.Printf("Failed to expire sessions: %v", err)
+		}
+	}
+}
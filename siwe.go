@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"wallet/pkg/auth"
+	"wallet/pkg/chains"
+	"wallet/pkg/siwe"
+)
+
+// SIWERequest carries a signed EIP-4361 message
+type SIWERequest struct {
+	Message   string `json:"message" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+	Device    string `json:"device"`
+}
+
+// siweNonce issues a nonce and returns the values the client needs to build
+// a Sign-In with Ethereum message
+func siweNonce(c *gin.Context) {
+	domain, err := auth.SIWEDomain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	nonce, err := authService.NewSIWENonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"nonce":      nonce,
+		"domain":     domain,
+		"chain_ids":  registry.IDs(),
+		"expires_in": auth.SIWENonceTTL.String(),
+	})
+}
+
+// siweLogin signs in with an EIP-4361 message signed by one of the user's
+// wallet accounts or linked addresses
+func siweLogin(c *gin.Context) {
+	var request SIWERequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	signature, err := hexutil.Decode(request.Signature)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Signature must be 0x-prefixed hex"})
+		return
+	}
+
+	result, err := authService.SIWELogin(request.Message, signature, registry.IDs(), chainCaller, auth.SessionMeta{
+		Device:    request.Device,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		respondSIWEError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// linkEthereumAddress links an external address to the authenticated user
+// with a signed EIP-4361 message (protected by AuthMiddleware and
+// RequireStepUp)
+func linkEthereumAddress(c *gin.Context) {
+	var request SIWERequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	signature, err := hexutil.Decode(request.Signature)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Signature must be 0x-prefixed hex"})
+		return
+	}
+
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	address, err := authService.LinkEthereumAddress(userData, request.Message, signature, registry.IDs(), chainCaller)
+	if err != nil {
+		respondSIWEError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Address linked", "address": address.Hex()})
+}
+
+// unlinkEthereumAddress removes an address linked by the authenticated user
+// (protected by AuthMiddleware)
+func unlinkEthereumAddress(c *gin.Context) {
+	if !common.IsHexAddress(c.Param("address")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address"})
+		return
+	}
+
+	err := authService.UnlinkEthereumAddress(c.MustGet("user_id").(primitive.ObjectID), common.HexToAddress(c.Param("address")))
+	if err != nil {
+		respondSIWEError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Address unlinked"})
+}
+
+// chainCaller returns the RPC client of a registered chain for EIP-1271
+// checks
+func chainCaller(chainID int64) (siwe.ContractCaller, error) {
+	chain, err := registry.Get(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return chains.Client(chain)
+}
+
+// respondSIWEError maps Sign-In with Ethereum errors to HTTP responses
+func respondSIWEError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, siwe.ErrInvalidMessage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAddressNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAddressLinked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidNonce), errors.Is(err, auth.ErrUnknownAddress),
+		errors.Is(err, siwe.ErrDomainMismatch), errors.Is(err, siwe.ErrURIMismatch),
+		errors.Is(err, siwe.ErrChainNotAllowed), errors.Is(err, siwe.ErrExpired),
+		errors.Is(err, siwe.ErrNotYetValid), errors.Is(err, siwe.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+
+	"wallet/pkg/chains"
+	"wallet/pkg/models"
+	"wallet/pkg/wallet"
+)
+
+// TransferRequest is the body of a transfer request. The amount is given
+// either as Value in base units (wei) or as Amount in whole units of the
+// chain's native currency, e.g. "1.5 ETH".
+type TransferRequest struct {
+	To     string `json:"to" binding:"required"`
+	Value  string `json:"value"`
+	Amount string `json:"amount"`
+}
+
+// createTransfer signs and broadcasts a native currency transfer from one of
+// the authenticated user's addresses on the chain given by the chain query
+// parameter (protected by AuthMiddleware)
+func createTransfer(c *gin.Context) {
+	chain, err := registry.Resolve(c.Query("chain"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request TransferRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !common.IsHexAddress(request.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipient address"})
+		return
+	}
+
+	var value models.Amount
+	switch {
+	case request.Value != "" && request.Amount == "":
+		value, err = models.ParseAmount(request.Value)
+	case request.Amount != "" && request.Value == "":
+		value, err = models.ParseUnits(request.Amount, chain.NativeSymbol, chain.NativeDecimals)
+	default:
+		err = errors.New("exactly one of value or amount is required")
+	}
+	if err == nil && value.Sign() <= 0 {
+		err = errors.New("amount must be positive")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	record, err := walletService.Transfer(userData, chain, c.Param("address"), common.HexToAddress(request.To), value.Int())
+	if errors.Is(err, wallet.ErrAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, wallet.ErrEmailNotVerified) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil && record.Hash != "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "transaction": record})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"transaction":  record,
+		"explorer_url": chains.TxURL(chain, record.Hash),
+	})
+}
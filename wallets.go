@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet/pkg/wallet"
+)
+
+// listAccounts returns the accounts of the authenticated user's wallet with
+// their derivation indices (protected by AuthMiddleware)
+func listAccounts(c *gin.Context) {
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": userData.Wallet.Accounts, "next_index": userData.Wallet.NextIndex})
+}
+
+// createAccount derives the next account of the authenticated user's wallet
+// (protected by AuthMiddleware)
+func createAccount(c *gin.Context) {
+	userData, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	account, err := walletService.NewAccount(userData)
+	switch {
+	case errors.Is(err, wallet.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, wallet.ErrWalletChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"account": account})
+	}
+}